@@ -0,0 +1,175 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flagTemplate = flag.StringP("template", "t", "", "Configuration template file name")
+	flagTenants  = flag.StringP("tenants", "T", "", "CSV file with one tenant per line, and the column names on the first line")
+	flagOutfile  = flag.StringP("outfile", "o", "", "Output file path, standard output if empty. When it contains template actions, such as config-{{.Tenant.name}}.yml, one file is generated per tenant")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"%s --template tpl --tenants tenants.csv [--outfile config.yml]\n",
+		os.Args[0],
+	)
+	flag.PrintDefaults()
+}
+
+// Tenant holds the columns of one line of the tenant list, by column name
+type Tenant map[string]string
+
+// templateData is what configuration templates are executed with
+type templateData struct {
+	Tenants []Tenant
+	// Tenant is the tenant a file is generated for, in per-tenant mode
+	Tenant Tenant
+}
+
+// readTenants reads a tenant list in CSV format. The first line holds the
+// column names, and lines starting with # are ignored.
+func readTenants(r io.Reader) ([]Tenant, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty tenant list, want the column names on the first line")
+	}
+	header := records[0]
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		if header[i] == "" {
+			return nil, fmt.Errorf("column %d has no name", i+1)
+		}
+	}
+	tenants := make([]Tenant, 0, len(records)-1)
+	for _, record := range records[1:] {
+		t := make(Tenant, len(header))
+		for i, name := range header {
+			t[name] = strings.TrimSpace(record[i])
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func parseTemplate(name, tpl string) (*template.Template, error) {
+	// missingkey=error catches references to columns the tenant list lacks
+	t, err := template.New(name).Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("template parsing failed: %w", err)
+	}
+	return t, nil
+}
+
+// render executes the configuration template, and checks that the result is
+// valid YAML
+func render(t *template.Template, data templateData) ([]byte, error) {
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("template execution failed: %w", err)
+	}
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(out.Bytes(), &parsed); err != nil {
+		return nil, fmt.Errorf("generated configuration is not valid YAML: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// generate writes the configuration for the tenants to outfile, or one file
+// per tenant if outfile contains template actions
+func generate(tpl string, tenants []Tenant, outfile string) error {
+	t, err := parseTemplate("config", tpl)
+	if err != nil {
+		return err
+	}
+	data := templateData{Tenants: tenants}
+	if !strings.Contains(outfile, "{{") {
+		out, err := render(t, data)
+		if err != nil {
+			return err
+		}
+		if outfile == "" {
+			_, err = os.Stdout.Write(out)
+			return err
+		}
+		if err := os.WriteFile(outfile, out, 0644); err != nil {
+			return err
+		}
+		log.Printf("Generated configuration for %d tenants in '%s'", len(tenants), outfile)
+		return nil
+	}
+
+	nameTemplate, err := parseTemplate("outfile", outfile)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(tenants))
+	for i, tenant := range tenants {
+		data.Tenant = tenant
+		var name strings.Builder
+		if err := nameTemplate.Execute(&name, data); err != nil {
+			return fmt.Errorf("tenant %d: output file name: %w", i+1, err)
+		}
+		if seen[name.String()] {
+			return fmt.Errorf("tenant %d: duplicate output file name '%s'", i+1, name.String())
+		}
+		seen[name.String()] = true
+		out, err := render(t, data)
+		if err != nil {
+			return fmt.Errorf("tenant %d: %w", i+1, err)
+		}
+		if err := os.WriteFile(name.String(), out, 0644); err != nil {
+			return err
+		}
+	}
+	log.Printf("Generated %d configuration files", len(tenants))
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *flagTemplate == "" || *flagTenants == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	tpl, err := os.ReadFile(*flagTemplate)
+	if err != nil {
+		log.Fatalf("Failed to read template file '%s': %v", *flagTemplate, err)
+	}
+	f, err := os.Open(*flagTenants)
+	if err != nil {
+		log.Fatalf("Failed to open tenant list: %v", err)
+	}
+	tenants, err := readTenants(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read tenant list '%s': %v", *flagTenants, err)
+	}
+	if err := generate(string(tpl), tenants, *flagOutfile); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTenants(t *testing.T) {
+	tenants, err := readTenants(strings.NewReader("# comment\nname, vlan\nblue, 100\ngreen,200\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Tenant{
+		{"name": "blue", "vlan": "100"},
+		{"name": "green", "vlan": "200"},
+	}, tenants)
+
+	for _, in := range []string{
+		"",
+		"name,\nblue,100\n",
+		"name,vlan\nblue\n",
+	} {
+		_, err := readTenants(strings.NewReader(in))
+		assert.Error(t, err, in)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tenants := []Tenant{{"name": "blue"}, {"name": "green"}}
+	tpl, err := parseTemplate("config", "server4:\n  plugins:\n{{range .Tenants}}    - dns: {{.name}}\n{{end}}")
+	require.NoError(t, err)
+	out, err := render(tpl, templateData{Tenants: tenants})
+	require.NoError(t, err)
+	assert.Equal(t, "server4:\n  plugins:\n    - dns: blue\n    - dns: green\n", string(out))
+
+	tpl, err = parseTemplate("config", "{{range .Tenants}}{{.vlan}}{{end}}")
+	require.NoError(t, err)
+	_, err = render(tpl, templateData{Tenants: tenants})
+	assert.Error(t, err, "missing column")
+
+	tpl, err = parseTemplate("config", "{{range .Tenants}}\n- {{.name}}\n  x: {{end}}")
+	require.NoError(t, err)
+	_, err = render(tpl, templateData{Tenants: tenants})
+	assert.Error(t, err, "invalid YAML")
+}
+
+func TestGeneratePerTenant(t *testing.T) {
+	tpl, err := os.ReadFile("config.yml.template")
+	require.NoError(t, err)
+	f, err := os.Open("tenants.csv.example")
+	require.NoError(t, err)
+	defer f.Close()
+	tenants, err := readTenants(f)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, generate(string(tpl), tenants, filepath.Join(dir, "config-{{.Tenant.name}}.yml")))
+	out, err := os.ReadFile(filepath.Join(dir, "config-green.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `- "%eth0.200"`)
+	assert.Contains(t, string(out), "- router: 10.200.0.1")
+
+	err = generate(string(tpl), tenants, filepath.Join(dir, "config.yml{{if false}}{{end}}"))
+	assert.Error(t, err, "duplicate file names")
+}
@@ -0,0 +1,160 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// manifest describes a custom build of coredhcp, see README.md for the format
+type manifest struct {
+	plugins  []string
+	requires [][2]string
+	replaces [][2]string
+	targets  []target
+	cgo      bool
+}
+
+// target is a platform to build for
+type target struct {
+	goos, goarch string
+	// variant is GOARM for arm and GOMIPS/GOMIPS64 for mips, if not empty
+	variant string
+}
+
+func (t target) String() string {
+	s := t.goos + "-" + t.goarch
+	if t.variant != "" {
+		s += "-" + t.variant
+	}
+	return s
+}
+
+// env returns the environment variables selecting the target
+func (t target) env() []string {
+	env := []string{"GOOS=" + t.goos, "GOARCH=" + t.goarch}
+	if t.variant == "" {
+		return env
+	}
+	switch {
+	case t.goarch == "arm":
+		env = append(env, "GOARM="+t.variant)
+	case t.goarch == "mips64" || t.goarch == "mips64le":
+		env = append(env, "GOMIPS64="+t.variant)
+	case strings.HasPrefix(t.goarch, "mips"):
+		env = append(env, "GOMIPS="+t.variant)
+	}
+	return env
+}
+
+func parseManifest(r io.Reader) (*manifest, error) {
+	var m manifest
+	sc := bufio.NewScanner(r)
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		directive, args := fields[0], fields[1:]
+		switch {
+		case directive == "plugin" && len(args) == 1:
+			m.plugins = append(m.plugins, args[0])
+		case directive == "require" && len(args) == 2:
+			m.requires = append(m.requires, [2]string{args[0], args[1]})
+		case directive == "replace" && len(args) == 3 && args[1] == "=>":
+			m.replaces = append(m.replaces, [2]string{args[0], args[2]})
+		case directive == "target" && len(args) == 1:
+			parts := strings.Split(args[0], "/")
+			if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("line %d: invalid target %q, want os/arch[/variant]", lineno, args[0])
+			}
+			t := target{goos: parts[0], goarch: parts[1]}
+			if len(parts) == 3 {
+				t.variant = parts[2]
+			}
+			m.targets = append(m.targets, t)
+		case directive == "cgo" && len(args) == 0:
+			m.cgo = true
+		default:
+			return nil, fmt.Errorf("line %d: invalid directive %q", lineno, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.targets) == 0 {
+		return nil, fmt.Errorf("no target in manifest")
+	}
+	return &m, nil
+}
+
+// goMod returns a go.mod for the generated main package
+func (m *manifest) goMod() string {
+	var b strings.Builder
+	b.WriteString("module coredhcp-custom\n\ngo 1.22\n")
+	if len(m.requires) > 0 {
+		b.WriteString("\nrequire (\n")
+		for _, r := range m.requires {
+			fmt.Fprintf(&b, "\t%s %s\n", r[0], r[1])
+		}
+		b.WriteString(")\n")
+	}
+	for _, r := range m.replaces {
+		fmt.Fprintf(&b, "\nreplace %s => %s\n", r[0], r[1])
+	}
+	return b.String()
+}
+
+// build builds the generated main package in dir for every target of the
+// manifest, into dir/dist. The builds are reproducible: paths, VCS information
+// and build IDs are left out of the binaries, and a go.mod/go.sum already in
+// dir is reused, so the same versions are used every time.
+func build(dir string, m *manifest) error {
+	gomod := path.Join(dir, "go.mod")
+	if _, err := os.Stat(gomod); os.IsNotExist(err) {
+		if err := os.WriteFile(gomod, []byte(m.goMod()), 0644); err != nil {
+			return fmt.Errorf("cannot write go.mod: %w", err)
+		}
+	} else {
+		log.Printf("Reusing existing %s", gomod)
+	}
+	if err := goCmd(dir, nil, "mod", "tidy"); err != nil {
+		return err
+	}
+	cgo := "CGO_ENABLED=0"
+	if m.cgo {
+		cgo = "CGO_ENABLED=1"
+	}
+	for _, t := range m.targets {
+		out := path.Join("dist", "coredhcp-"+t.String())
+		log.Printf("Building %s", out)
+		env := append(t.env(), cgo)
+		if err := goCmd(dir, env, "build", "-trimpath", "-buildvcs=false",
+			"-ldflags=-s -w -buildid=", "-o", out, "."); err != nil {
+			return fmt.Errorf("build for %s failed: %w", t, err)
+		}
+	}
+	return nil
+}
+
+func goCmd(dir string, env []string, args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
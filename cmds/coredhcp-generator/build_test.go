@@ -0,0 +1,49 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := parseManifest(strings.NewReader(`
+# router firmware
+plugin github.com/coredhcp/coredhcp/plugins/dns
+require github.com/coredhcp/coredhcp v0.0.0-20240101000000-000000000000
+replace github.com/example/plugin => ../plugin
+target linux/amd64
+target linux/mips/softfloat
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &manifest{
+		plugins:  []string{"github.com/coredhcp/coredhcp/plugins/dns"},
+		requires: [][2]string{{"github.com/coredhcp/coredhcp", "v0.0.0-20240101000000-000000000000"}},
+		replaces: [][2]string{{"github.com/example/plugin", "../plugin"}},
+		targets:  []target{{"linux", "amd64", ""}, {"linux", "mips", "softfloat"}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+	if env := m.targets[1].env(); !reflect.DeepEqual(env, []string{"GOOS=linux", "GOARCH=mips", "GOMIPS=softfloat"}) {
+		t.Errorf("unexpected environment for %s: %v", m.targets[1], env)
+	}
+
+	for _, bad := range []string{
+		"plugin a\n",                    // no target
+		"target linux\n",                // no arch
+		"target linux/arm/7/x\n",        // too many parts
+		"replace a b\ntarget linux/arm", // no arrow
+		"frobnicate\ntarget linux/arm",  // unknown directive
+	} {
+		if _, err := parseManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("invalid manifest %q was accepted", bad)
+		}
+	}
+}
@@ -26,6 +26,7 @@ var (
 	flagTemplate = flag.StringP("template", "t", defaultTemplateFile, "Template file name")
 	flagOutfile  = flag.StringP("outfile", "o", "", "Output file path")
 	flagFromFile = flag.StringP("from", "f", "", "Optional file name to get the plugin list from, one import path per line")
+	flagBuild    = flag.StringP("build", "b", "", "Optional build manifest. When given, its plugins are included and the binaries it lists are built")
 )
 
 var funcMap = template.FuncMap{
@@ -40,7 +41,7 @@ var funcMap = template.FuncMap{
 
 func usage() {
 	fmt.Fprintf(flag.CommandLine.Output(),
-		"%s [-template tpl] [-outfile out] [-from pluginlist] [plugin [plugin...]]\n",
+		"%s [-template tpl] [-outfile out] [-from pluginlist] [-build manifest] [plugin [plugin...]]\n",
 		os.Args[0],
 	)
 	flag.PrintDefaults()
@@ -103,6 +104,21 @@ func main() {
 			log.Fatalf("Error reading file '%s': %v", *flagFromFile, err)
 		}
 	}
+	var m *manifest
+	if *flagBuild != "" {
+		fd, err := os.Open(*flagBuild)
+		if err != nil {
+			log.Fatalf("Failed to read manifest '%s': %v", *flagBuild, err)
+		}
+		m, err = parseManifest(fd)
+		fd.Close()
+		if err != nil {
+			log.Fatalf("Invalid manifest '%s': %v", *flagBuild, err)
+		}
+		for _, pl := range m.plugins {
+			plugins[pl] = true
+		}
+	}
 	if len(plugins) == 0 {
 		log.Fatalf("No plugin specified!")
 	}
@@ -139,6 +155,13 @@ func main() {
 	if err := t.Execute(outFD, pluginList); err != nil {
 		log.Fatalf("Template execution failed: %v", err)
 	}
-	log.Printf("Generated file '%s'. You can build it by running 'go build' in the output directory.", outfile)
+	if m != nil {
+		if err := build(path.Dir(outfile), m); err != nil {
+			log.Fatalf("Build failed: %v", err)
+		}
+		log.Printf("Built %d binaries in '%s'", len(m.targets), path.Join(path.Dir(outfile), "dist"))
+	} else {
+		log.Printf("Generated file '%s'. You can build it by running 'go build' in the output directory.", outfile)
+	}
 	fmt.Println(path.Dir(outfile))
 }
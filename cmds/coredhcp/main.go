@@ -7,25 +7,36 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 
 	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/server"
 
 	"github.com/coredhcp/coredhcp/plugins"
+	pl_acs "github.com/coredhcp/coredhcp/plugins/acs"
+	pl_addrreg "github.com/coredhcp/coredhcp/plugins/addrreg"
 	pl_autoconfigure "github.com/coredhcp/coredhcp/plugins/autoconfigure"
+	pl_captiveportal "github.com/coredhcp/coredhcp/plugins/captiveportal"
 	pl_dns "github.com/coredhcp/coredhcp/plugins/dns"
+	pl_drop "github.com/coredhcp/coredhcp/plugins/drop"
 	pl_file "github.com/coredhcp/coredhcp/plugins/file"
 	pl_ipv6only "github.com/coredhcp/coredhcp/plugins/ipv6only"
 	pl_leasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
 	pl_mtu "github.com/coredhcp/coredhcp/plugins/mtu"
+	pl_nak "github.com/coredhcp/coredhcp/plugins/nak"
 	pl_nbp "github.com/coredhcp/coredhcp/plugins/nbp"
 	pl_netmask "github.com/coredhcp/coredhcp/plugins/netmask"
+	pl_nextserver "github.com/coredhcp/coredhcp/plugins/nextserver"
 	pl_prefix "github.com/coredhcp/coredhcp/plugins/prefix"
 	pl_range "github.com/coredhcp/coredhcp/plugins/range"
+	pl_ratelimit "github.com/coredhcp/coredhcp/plugins/ratelimit"
 	pl_router "github.com/coredhcp/coredhcp/plugins/router"
 	pl_searchdomains "github.com/coredhcp/coredhcp/plugins/searchdomains"
 	pl_serverid "github.com/coredhcp/coredhcp/plugins/serverid"
@@ -40,8 +51,11 @@ var (
 	flagLogFile     = flag.StringP("logfile", "l", "", "Name of the log file to append to. Default: stdout/stderr only")
 	flagLogNoStdout = flag.BoolP("nostdout", "N", false, "Disable logging to stdout/stderr")
 	flagLogLevel    = flag.StringP("loglevel", "L", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
+	flagLogRedact   = flag.StringP("redactkey", "R", "", "File holding a secret key. When set, MAC addresses and hostnames are replaced by hashes keyed with it in logs")
 	flagConfig      = flag.StringP("conf", "c", "", "Use this configuration file instead of the default location")
 	flagPlugins     = flag.BoolP("plugins", "P", false, "list plugins")
+	flagVersion     = flag.BoolP("version", "v", false, "print version information, and the version of each plugin with --plugins")
+	flagSelfTest    = flag.BoolP("selftest", "T", false, "Run a self-test exchange through the plugin chains before serving, and exit if it fails")
 )
 
 var logLevels = map[string]func(*logrus.Logger){
@@ -62,16 +76,23 @@ func getLogLevels() []string {
 }
 
 var desiredPlugins = []*plugins.Plugin{
+	&pl_acs.Plugin,
+	&pl_addrreg.Plugin,
 	&pl_autoconfigure.Plugin,
+	&pl_captiveportal.Plugin,
 	&pl_dns.Plugin,
+	&pl_drop.Plugin,
 	&pl_file.Plugin,
 	&pl_ipv6only.Plugin,
 	&pl_leasetime.Plugin,
 	&pl_mtu.Plugin,
+	&pl_nak.Plugin,
 	&pl_nbp.Plugin,
+	&pl_nextserver.Plugin,
 	&pl_netmask.Plugin,
 	&pl_prefix.Plugin,
 	&pl_range.Plugin,
+	&pl_ratelimit.Plugin,
 	&pl_router.Plugin,
 	&pl_searchdomains.Plugin,
 	&pl_serverid.Plugin,
@@ -82,10 +103,19 @@ var desiredPlugins = []*plugins.Plugin{
 func main() {
 	flag.Parse()
 
+	if *flagVersion {
+		fmt.Printf("coredhcp %s, core API %d, %s\n", plugins.CoreVersion(), plugins.CoreAPIVersion, runtime.Version())
+	}
 	if *flagPlugins {
 		for _, p := range desiredPlugins {
-			fmt.Println(p.Name)
+			if *flagVersion {
+				fmt.Printf("%s %s\n", p.Name, plugins.VersionOf(p))
+			} else {
+				fmt.Println(p.Name)
+			}
 		}
+	}
+	if *flagVersion || *flagPlugins {
 		os.Exit(0)
 	}
 
@@ -96,6 +126,18 @@ func main() {
 	}
 	fn(log.Logger)
 	log.Infof("Setting log level to '%s'", *flagLogLevel)
+	if *flagLogRedact != "" {
+		key, err := os.ReadFile(*flagLogRedact)
+		if err != nil {
+			log.Fatalf("Failed to read redaction key: %v", err)
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			log.Fatalf("Redaction key file %s is empty", *flagLogRedact)
+		}
+		log.Infof("Redacting MAC addresses and hostnames in logs")
+		logger.WithRedaction(log, key)
+	}
 	if *flagLogFile != "" {
 		log.Infof("Logging to file %s", *flagLogFile)
 		logger.WithFile(log, *flagLogFile)
@@ -115,11 +157,29 @@ func main() {
 		}
 	}
 
+	if *flagSelfTest {
+		config.SelfTest = true
+	}
+	if config.StatusFile != "" {
+		// installed after WithRedaction, so that the errors kept are redacted
+		config.RecentErrors = logger.WithRecentErrors(log, server.StatusErrors)
+	}
+
 	// start server
 	srv, err := server.Start(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// SIGUSR1 runs the self-test again, alongside the requests of clients
+	selfTest := make(chan os.Signal, 1)
+	signal.Notify(selfTest, syscall.SIGUSR1)
+	go func() {
+		for range selfTest {
+			if err := srv.SelfTest(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
 	if err := srv.Wait(); err != nil {
 		log.Error(err)
 	}
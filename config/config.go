@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -32,6 +34,29 @@ type Config struct {
 	v       *viper.Viper
 	Server6 *ServerConfig
 	Server4 *ServerConfig
+	// GoMaxProcs overrides the number of CPUs the Go runtime executes on
+	// simultaneously when non-zero, see runtime.GOMAXPROCS
+	GoMaxProcs int
+	// SetupTimeout is how long plugins may take to set up before the server
+	// gives up starting. Zero means no limit.
+	SetupTimeout time.Duration
+	// StatusFile is the path of the JSON status document written every
+	// StatusInterval, if not empty
+	StatusFile     string
+	StatusInterval time.Duration
+	// RecentErrors, when set, provides the errors listed in the status file.
+	// Programs install it on the logger once, see logger.WithRecentErrors;
+	// when it is nil, the server installs one the first time it needs it.
+	RecentErrors *logger.RecentErrors
+	// SelfTest runs a synthetic exchange through the plugin chains before
+	// serving, and fails the start of the server if it is not answered
+	SelfTest bool
+	// Shared holds plugin arguments common to DHCPv4 and DHCPv6, by plugin
+	// name. They are used for plugins configured without arguments.
+	Shared map[string][]string
+
+	// profile is the built-in configuration selected, if any
+	profile *profile
 }
 
 // New returns a new initialized instance of a Config object
@@ -44,14 +69,92 @@ func New() *Config {
 type ServerConfig struct {
 	Addresses []net.UDPAddr
 	Plugins   []PluginConfig
+	// Workers is the number of goroutines handling requests for each
+	// listener. Zero means one new goroutine per request.
+	Workers int
+	// NakInterval is the minimum time between two NAKs sent to the same
+	// client, DHCPv4 only. Zero means no limit.
+	NakInterval time.Duration
+	// DeferOffers is how long OFFERs are held back, to let another server
+	// answer first, DHCPv4 only and not on UnixSockets. Zero means OFFERs
+	// are sent right away.
+	DeferOffers time.Duration
+	// UnixSockets are paths of unix datagram sockets to listen on, in
+	// addition to Addresses
+	UnixSockets []string
+	// RelayStatsInterval is the interval of the per-relay statistics report.
+	// Zero means no statistics.
+	RelayStatsInterval time.Duration
+	// Deadline is how long after a request is received the client is
+	// assumed to have given up on it. Zero means the server default.
+	Deadline time.Duration
+	// PreferredRatio is the preferred lifetime given to IA addresses and
+	// prefixes whose preferred lifetime equals the valid one, as a fraction
+	// of the valid lifetime, DHCPv6 only. Zero leaves lifetimes unchanged.
+	PreferredRatio float64
+	// DSCP is the differentiated services code point responses sent from
+	// Addresses are marked with, unless a plugin marks them otherwise
+	DSCP int
+	// ResponseFormats change how responses to some clients are serialized,
+	// DHCPv4 only. The first one matching a request applies.
+	ResponseFormats []ResponseFormat
 }
 
+// ResponseFormat is a serialization of DHCPv4 responses for clients that
+// need options in a given order or a minimum message size
+type ResponseFormat struct {
+	// Vendor and UserClass select the clients the format applies to: those
+	// whose vendor class (option 60) contains Vendor, and that send
+	// UserClass in their user class option (77). Empty values match all
+	// clients.
+	Vendor, UserClass string
+	// OptionOrder lists options that come first in responses, in that order
+	OptionOrder []dhcpv4.OptionCode
+	// MinSize is the size responses are padded to
+	MinSize int
+}
+
+// maxResponseSize is the largest DHCPv4 message that fits an Ethernet frame
+// without fragmentation
+const maxResponseSize = 1500 - 20 - 8
+
 // PluginConfig holds the configuration of a plugin
 type PluginConfig struct {
 	Name string
 	Args []string
+	// Timeout is the maximum time the plugin may spend on a request. Zero
+	// means no limit.
+	Timeout time.Duration
+	// OnTimeout is what happens to the request when Timeout expires
+	OnTimeout TimeoutAction
+	// MaxInFlight is the maximum number of requests the plugin may be
+	// working on when it has a Timeout. Zero means the default of the core.
+	MaxInFlight int
 }
 
+// TimeoutAction is the fallback behaviour applied when a plugin times out
+type TimeoutAction string
+
+// Valid values of TimeoutAction
+const (
+	// TimeoutSkip ignores the plugin and carries on with the rest of the chain
+	TimeoutSkip TimeoutAction = "skip"
+	// TimeoutDrop drops the request
+	TimeoutDrop TimeoutAction = "drop"
+	// TimeoutPartial stops the chain and replies with the partial response
+	// built by the plugins before the one that timed out. Plugins setting
+	// default options placed before a slow one thus still reach the client.
+	TimeoutPartial TimeoutAction = "partial"
+)
+
+// Keys that can be given alongside the plugin name in a plugin entry, rather
+// than being taken as the name of a plugin
+const (
+	pluginTimeoutKey     = "timeout"
+	pluginOnTimeoutKey   = "on_timeout"
+	pluginMaxInFlightKey = "max_in_flight"
+)
+
 // Load reads a configuration file and returns a Config object, or an error if
 // any.
 func Load(pathOverride string) (*Config, error) {
@@ -71,18 +174,115 @@ func Load(pathOverride string) (*Config, error) {
 	if err := c.v.ReadInConfig(); err != nil {
 		return nil, err
 	}
-	if err := c.parseConfig(protocolV6); err != nil {
+	if err := c.parse(); err != nil {
 		return nil, err
 	}
-	if err := c.parseConfig(protocolV4); err != nil {
+	return c, nil
+}
+
+// FromMap returns a Config object from settings structured like the
+// configuration file, or an error if any. It is meant for programs building
+// their configuration rather than reading it from a file.
+func FromMap(settings map[string]interface{}) (*Config, error) {
+	c := New()
+	if err := c.v.MergeConfigMap(settings); err != nil {
 		return nil, err
 	}
-	if c.Server6 == nil && c.Server4 == nil {
-		return nil, ConfigErrorFromString("need at least one valid config for DHCPv6 or DHCPv4")
+	if err := c.parse(); err != nil {
+		return nil, err
 	}
 	return c, nil
 }
 
+func (c *Config) parse() error {
+	if err := c.applyProfile(); err != nil {
+		return err
+	}
+	if err := c.parseRuntime(); err != nil {
+		return err
+	}
+	if err := c.parseShared(); err != nil {
+		return err
+	}
+	if err := c.parseConfig(protocolV6); err != nil {
+		return err
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		return err
+	}
+	if c.Server6 == nil && c.Server4 == nil {
+		return ConfigErrorFromString("need at least one valid config for DHCPv6 or DHCPv4")
+	}
+	return nil
+}
+
+func (c *Config) parseRuntime() error {
+	gomaxprocs, err := cast.ToIntE(c.v.Get("gomaxprocs"))
+	if err != nil || gomaxprocs < 0 {
+		return ConfigErrorFromString("invalid gomaxprocs '%v', want a positive integer", c.v.Get("gomaxprocs"))
+	}
+	c.GoMaxProcs = gomaxprocs
+	if timeout := c.v.Get("setup_timeout"); timeout != nil {
+		d, err := cast.ToDurationE(timeout)
+		if err != nil || d < 0 {
+			return ConfigErrorFromString("invalid setup_timeout '%v', want a positive duration", timeout)
+		}
+		c.SetupTimeout = d
+	}
+	c.StatusFile = c.v.GetString("status_file")
+	c.StatusInterval = defaultStatusInterval
+	if interval := c.v.Get("status_interval"); interval != nil {
+		d, err := cast.ToDurationE(interval)
+		if err != nil || d <= 0 {
+			return ConfigErrorFromString("invalid status_interval '%v', want a positive duration", interval)
+		}
+		c.StatusInterval = d
+	}
+	selftest, err := cast.ToBoolE(c.v.Get("selftest"))
+	if err != nil {
+		return ConfigErrorFromString("invalid selftest '%v', want a boolean", c.v.Get("selftest"))
+	}
+	c.SelfTest = selftest
+	return nil
+}
+
+// defaultStatusInterval is how often the status file is written by default
+const defaultStatusInterval = 30 * time.Second
+
+func (c *Config) parseShared() error {
+	shared := c.v.Get("shared")
+	if shared == nil {
+		return nil
+	}
+	conf, err := cast.ToStringMapE(shared)
+	if err != nil {
+		return ConfigErrorFromString("shared: not a map of plugin names to arguments")
+	}
+	c.Shared = make(map[string][]string, len(conf))
+	for name, args := range conf {
+		c.Shared[name] = strings.Fields(cast.ToString(args))
+	}
+	return nil
+}
+
+// sharedArgs returns the shared arguments of a plugin for the given protocol
+// version. Shared arguments that are IP addresses of the other protocol are
+// left out, so that a single list of eg. DNS servers can be shared.
+func (c *Config) sharedArgs(name string, ver protocolVersion) ([]string, bool) {
+	shared, ok := c.Shared[name]
+	if !ok {
+		return nil, false
+	}
+	args := make([]string, 0, len(shared))
+	for _, arg := range shared {
+		if ip := net.ParseIP(arg); ip != nil && (ip.To4() != nil) != (ver == protocolV4) {
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args, true
+}
+
 func protoVersionCheck(v protocolVersion) error {
 	if v != protocolV6 && v != protocolV4 {
 		return fmt.Errorf("invalid protocol version: %d", v)
@@ -97,22 +297,59 @@ func parsePlugins(pluginList []interface{}) ([]PluginConfig, error) {
 		if conf == nil {
 			return nil, ConfigErrorFromString("dhcpv6: plugin #%d is not a string map", idx)
 		}
-		// make sure that only one item is specified, since it's a
-		// map name -> args
-		if len(conf) != 1 {
-			return nil, ConfigErrorFromString("dhcpv6: exactly one plugin per item can be specified")
-		}
-		var (
-			name string
-			args []string
-		)
-		// only one item, as enforced above, so read just that
+		var pc PluginConfig
 		for k, v := range conf {
-			name = k
-			args = strings.Fields(cast.ToString(v))
-			break
+			switch k {
+			case pluginTimeoutKey:
+				timeout, err := cast.ToDurationE(v)
+				if err != nil || timeout < 0 {
+					return nil, ConfigErrorFromString("plugin #%d: invalid timeout '%v'", idx, v)
+				}
+				pc.Timeout = timeout
+			case pluginOnTimeoutKey:
+				switch action := TimeoutAction(cast.ToString(v)); action {
+				case TimeoutSkip, TimeoutDrop, TimeoutPartial:
+					pc.OnTimeout = action
+				default:
+					return nil, ConfigErrorFromString("plugin #%d: invalid on_timeout action '%v', want one of %s, %s, %s",
+						idx, v, TimeoutSkip, TimeoutDrop, TimeoutPartial)
+				}
+			case pluginMaxInFlightKey:
+				n, err := cast.ToIntE(v)
+				if err != nil || n <= 0 {
+					return nil, ConfigErrorFromString("plugin #%d: invalid max_in_flight '%v', want a positive number", idx, v)
+				}
+				pc.MaxInFlight = n
+			default:
+				// make sure that only one plugin is specified, since it's a
+				// map name -> args
+				if pc.Name != "" {
+					return nil, ConfigErrorFromString("dhcpv6: exactly one plugin per item can be specified")
+				}
+				pc.Name = k
+				switch v.(type) {
+				case []interface{}, []string:
+					// arguments given as a list are kept as they are, so
+					// that they can contain whitespace
+					pc.Args = cast.ToStringSlice(v)
+				default:
+					pc.Args = strings.Fields(cast.ToString(v))
+				}
+			}
+		}
+		if pc.Name == "" {
+			return nil, ConfigErrorFromString("plugin #%d: no plugin name specified", idx)
+		}
+		if pc.OnTimeout != "" && pc.Timeout == 0 {
+			return nil, ConfigErrorFromString("plugin #%d: on_timeout requires a timeout", idx)
+		}
+		if pc.MaxInFlight != 0 && pc.Timeout == 0 {
+			return nil, ConfigErrorFromString("plugin #%d: max_in_flight requires a timeout", idx)
+		}
+		if pc.Timeout != 0 && pc.OnTimeout == "" {
+			pc.OnTimeout = TimeoutSkip
 		}
-		plugins = append(plugins, PluginConfig{Name: name, Args: args})
+		plugins = append(plugins, pc)
 	}
 	return plugins, nil
 }
@@ -219,6 +456,20 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 	if err != nil {
 		return err
 	}
+	for i := range plugins {
+		p := &plugins[i]
+		if len(p.Args) == 0 {
+			if args, ok := c.sharedArgs(p.Name, ver); ok {
+				log.Printf("DHCPv%d: using shared args for plugin `%s`", ver, p.Name)
+				p.Args = args
+			}
+		}
+	}
+	if c.profile != nil {
+		if err := c.profile.fill(ver, plugins); err != nil {
+			return err
+		}
+	}
 	for _, p := range plugins {
 		log.Printf("DHCPv%d: found plugin `%s` with %d args: %v", ver, p.Name, len(p.Args), p.Args)
 	}
@@ -228,9 +479,92 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 		return err
 	}
 
+	workers, err := cast.ToIntE(c.v.Get(fmt.Sprintf("server%d.workers", ver)))
+	if err != nil || workers < 0 {
+		return ConfigErrorFromString("dhcpv%d: invalid workers '%v', want a positive integer", ver, c.v.Get(fmt.Sprintf("server%d.workers", ver)))
+	}
+
+	var nakInterval time.Duration
+	if v := c.v.Get("server4.nak_interval"); ver == protocolV4 && v != nil {
+		nakInterval, err = cast.ToDurationE(v)
+		if err != nil || nakInterval < 0 {
+			return ConfigErrorFromString("dhcpv4: invalid nak_interval '%v', want a positive duration", v)
+		}
+	}
+
+	var deferOffers time.Duration
+	if v := c.v.Get("server4.defer_offers"); ver == protocolV4 && v != nil {
+		deferOffers, err = cast.ToDurationE(v)
+		if err != nil || deferOffers < 0 {
+			return ConfigErrorFromString("dhcpv4: invalid defer_offers '%v', want a positive duration", v)
+		}
+	}
+
+	var preferredRatio float64
+	if v := c.v.Get("server6.preferred_ratio"); ver == protocolV6 && v != nil {
+		preferredRatio, err = cast.ToFloat64E(v)
+		if err != nil || preferredRatio <= 0 || preferredRatio > 1 {
+			return ConfigErrorFromString("dhcpv6: invalid preferred_ratio '%v', want a number in (0, 1]", v)
+		}
+	}
+
+	var dscp int
+	if v := c.v.Get(fmt.Sprintf("server%d.dscp", ver)); v != nil {
+		dscp, err = parseDSCP(v)
+		if err != nil {
+			return ConfigErrorFromString("dhcpv%d: invalid dscp '%v': %v", ver, v, err)
+		}
+	}
+
+	var deadline time.Duration
+	if v := c.v.Get(fmt.Sprintf("server%d.deadline", ver)); v != nil {
+		deadline, err = cast.ToDurationE(v)
+		if err != nil || deadline < 0 {
+			return ConfigErrorFromString("dhcpv%d: invalid deadline '%v', want a positive duration", ver, v)
+		}
+	}
+
+	var unixSockets []string
+	if v := c.v.Get(fmt.Sprintf("server%d.unix", ver)); v != nil {
+		unixSockets, err = cast.ToStringSliceE(v)
+		if err != nil {
+			unixSockets = []string{cast.ToString(v)}
+		}
+		for _, path := range unixSockets {
+			if path == "" {
+				return ConfigErrorFromString("dhcpv%d: empty unix socket path", ver)
+			}
+		}
+	}
+
+	var formats []ResponseFormat
+	if v := c.v.Get("server4.response_formats"); ver == protocolV4 && v != nil {
+		formats, err = parseResponseFormats(v)
+		if err != nil {
+			return ConfigErrorFromString("dhcpv4: invalid response_formats: %v", err)
+		}
+	}
+
+	var relayStats time.Duration
+	if v := c.v.Get(fmt.Sprintf("server%d.relay_stats_interval", ver)); v != nil {
+		relayStats, err = cast.ToDurationE(v)
+		if err != nil || relayStats < 0 {
+			return ConfigErrorFromString("dhcpv%d: invalid relay_stats_interval '%v', want a positive duration", ver, v)
+		}
+	}
+
 	sc := ServerConfig{
-		Addresses: listeners,
-		Plugins:   plugins,
+		Addresses:          listeners,
+		Plugins:            plugins,
+		Workers:            workers,
+		NakInterval:        nakInterval,
+		DeferOffers:        deferOffers,
+		Deadline:           deadline,
+		PreferredRatio:     preferredRatio,
+		DSCP:               dscp,
+		ResponseFormats:    formats,
+		UnixSockets:        unixSockets,
+		RelayStatsInterval: relayStats,
 	}
 	if ver == protocolV6 {
 		c.Server6 = &sc
@@ -240,11 +574,126 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 	return nil
 }
 
+// dscpClasses are the names of the standard DiffServ classes: class selectors
+// (RFC 2474), assured forwarding (RFC 2597) and expedited forwarding (RFC 3246)
+var dscpClasses = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14, "AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30, "AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46,
+}
+
+// parseDSCP parses a differentiated services code point, given as a number
+// or a class name such as CS6
+func parseDSCP(v interface{}) (int, error) {
+	if dscp, ok := dscpClasses[strings.ToUpper(cast.ToString(v))]; ok {
+		return dscp, nil
+	}
+	dscp, err := cast.ToIntE(v)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, errors.New("want a number between 0 and 63, or a class name such as CS6 or EF")
+	}
+	return dscp, nil
+}
+
+// parseResponseFormats parses a list of response formats, each a map with the
+// vendor, userclass, option_order and min_size keys
+func parseResponseFormats(v interface{}) ([]ResponseFormat, error) {
+	entries, err := cast.ToSliceE(v)
+	if err != nil {
+		return nil, errors.New("want a list")
+	}
+	formats := make([]ResponseFormat, 0, len(entries))
+	for i, entry := range entries {
+		m, err := cast.ToStringMapE(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: want a map", i+1)
+		}
+		var f ResponseFormat
+		for key, value := range m {
+			switch key {
+			case "vendor":
+				f.Vendor = cast.ToString(value)
+			case "userclass":
+				f.UserClass = cast.ToString(value)
+			case "option_order":
+				f.OptionOrder, err = parseOptionOrder(value)
+				if err != nil {
+					return nil, fmt.Errorf("entry %d: invalid option_order '%v': %v", i+1, value, err)
+				}
+			case "min_size":
+				f.MinSize, err = cast.ToIntE(value)
+				if err != nil || f.MinSize < 0 || f.MinSize > maxResponseSize {
+					return nil, fmt.Errorf("entry %d: invalid min_size '%v', want at most %d bytes", i+1, value, maxResponseSize)
+				}
+			default:
+				return nil, fmt.Errorf("entry %d: unknown key %q", i+1, key)
+			}
+		}
+		if len(f.OptionOrder) == 0 && f.MinSize == 0 {
+			return nil, fmt.Errorf("entry %d: need option_order, min_size or both", i+1)
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// parseOptionOrder parses DHCPv4 option codes, given as a list or separated by
+// spaces
+func parseOptionOrder(v interface{}) ([]dhcpv4.OptionCode, error) {
+	var values []string
+	if s, ok := v.(string); ok {
+		values = strings.Fields(s)
+	} else if list, err := cast.ToSliceE(v); err == nil {
+		values = cast.ToStringSlice(list)
+	} else {
+		values = []string{cast.ToString(v)}
+	}
+	seen := make(map[uint8]bool)
+	codes := make([]dhcpv4.OptionCode, 0, len(values))
+	for _, value := range values {
+		code, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || code == uint64(dhcpv4.OptionPad.Code()) || code == uint64(dhcpv4.OptionEnd.Code()) {
+			return nil, fmt.Errorf("%q is not an option code", value)
+		}
+		if seen[uint8(code)] {
+			return nil, fmt.Errorf("option %d is listed twice", code)
+		}
+		seen[uint8(code)] = true
+		codes = append(codes, dhcpv4.GenericOptionCode(code))
+	}
+	return codes, nil
+}
+
 // BUG(Natolumin): When listening on link-local multicast addresses without
 // binding to a specific interface, new interfaces coming up after the server
 // starts will not be taken into account.
 
-func expandLLMulticast(addr *net.UDPAddr) ([]net.UDPAddr, error) {
+// ifaceFilter selects the interfaces multicast listeners are expanded to, by
+// name. Patterns use the syntax of path.Match.
+type ifaceFilter struct {
+	allow []string // all interfaces if empty
+	deny  []string
+}
+
+func (f ifaceFilter) match(name string) bool {
+	for _, pattern := range f.deny {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, pattern := range f.allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func expandLLMulticast(addr *net.UDPAddr, filter ifaceFilter) ([]net.UDPAddr, error) {
 	if !addr.IP.IsLinkLocalMulticast() && !addr.IP.IsInterfaceLocalMulticast() {
 		return nil, errors.New("Address is not multicast")
 	}
@@ -266,6 +715,10 @@ func expandLLMulticast(addr *net.UDPAddr) ([]net.UDPAddr, error) {
 		if (iface.Flags & needFlags) != needFlags {
 			continue
 		}
+		if !filter.match(iface.Name) {
+			log.Debugf("not listening on interface %s, excluded by interfaces_allow or interfaces_deny", iface.Name)
+			continue
+		}
 		caddr := *addr
 		caddr.Zone = iface.Name
 		ret = append(ret, caddr)
@@ -276,12 +729,12 @@ func expandLLMulticast(addr *net.UDPAddr) ([]net.UDPAddr, error) {
 	return ret, nil
 }
 
-func defaultListen(ver protocolVersion) ([]net.UDPAddr, error) {
+func defaultListen(ver protocolVersion, filter ifaceFilter) ([]net.UDPAddr, error) {
 	switch ver {
 	case protocolV4:
 		return []net.UDPAddr{{Port: dhcpv4.ServerPort}}, nil
 	case protocolV6:
-		l, err := expandLLMulticast(&net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort})
+		l, err := expandLLMulticast(&net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort}, filter)
 		if err != nil {
 			return nil, err
 		}
@@ -294,6 +747,29 @@ func defaultListen(ver protocolVersion) ([]net.UDPAddr, error) {
 	return nil, errors.New("defaultListen: Incorrect protocol version")
 }
 
+// parseIfaceFilter reads the interfaces_allow and interfaces_deny patterns,
+// which restrict the interfaces multicast listeners are expanded to
+func (c *Config) parseIfaceFilter(ver protocolVersion) (ifaceFilter, error) {
+	var f ifaceFilter
+	for key, patterns := range map[string]*[]string{"interfaces_allow": &f.allow, "interfaces_deny": &f.deny} {
+		v := c.v.Get(fmt.Sprintf("server%d.%s", ver, key))
+		if v == nil {
+			continue
+		}
+		list, err := cast.ToStringSliceE(v)
+		if err != nil {
+			list = []string{cast.ToString(v)}
+		}
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return f, ConfigErrorFromString("dhcpv%d: invalid %s pattern '%s'", ver, key, pattern)
+			}
+		}
+		*patterns = list
+	}
+	return f, nil
+}
+
 func (c *Config) parseListen(ver protocolVersion) ([]net.UDPAddr, error) {
 	if err := protoVersionCheck(ver); err != nil {
 		return nil, err
@@ -309,8 +785,17 @@ func (c *Config) parseListen(ver protocolVersion) ([]net.UDPAddr, error) {
 		listen = "%" + cast.ToString(iface)
 	}
 
+	filter, err := c.parseIfaceFilter(ver)
+	if err != nil {
+		return nil, err
+	}
+
 	if listen == nil {
-		return defaultListen(ver)
+		if c.v.Get(fmt.Sprintf("server%d.unix", ver)) != nil {
+			// only listen on the unix sockets
+			return []net.UDPAddr{}, nil
+		}
+		return defaultListen(ver, filter)
 	}
 
 	addrs, err := cast.ToStringSliceE(listen)
@@ -327,7 +812,7 @@ func (c *Config) parseListen(ver protocolVersion) ([]net.UDPAddr, error) {
 
 		if l.Zone == "" && (l.IP.IsLinkLocalMulticast() || l.IP.IsInterfaceLocalMulticast()) {
 			// link-local multicast specified without interface gets expanded to listen on all interfaces
-			expanded, err := expandLLMulticast(l)
+			expanded, err := expandLLMulticast(l, filter)
 			if err != nil {
 				return nil, err
 			}
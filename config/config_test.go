@@ -4,7 +4,14 @@
 
 package config
 
-import "testing"
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
 
 func TestSplitHostPort(t *testing.T) {
 	testcases := []struct {
@@ -50,3 +57,306 @@ func TestSplitHostPort(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePlugins(t *testing.T) {
+	testcases := []struct {
+		name string
+		item map[string]interface{}
+		want PluginConfig
+		err  bool
+	}{
+		{"plain", map[string]interface{}{"dns": "8.8.8.8 8.8.4.4"},
+			PluginConfig{Name: "dns", Args: []string{"8.8.8.8", "8.8.4.4"}}, false},
+		{"list", map[string]interface{}{"range": []interface{}{"leases file.txt", "10.0.0.1"}},
+			PluginConfig{Name: "range", Args: []string{"leases file.txt", "10.0.0.1"}}, false},
+		{"timeout", map[string]interface{}{"range": "a b", "timeout": "200ms"},
+			PluginConfig{Name: "range", Args: []string{"a", "b"}, Timeout: 200 * time.Millisecond, OnTimeout: TimeoutSkip}, false},
+		{"timeout with action", map[string]interface{}{"range": "", "timeout": "1s", "on_timeout": "drop"},
+			PluginConfig{Name: "range", Args: []string{}, Timeout: time.Second, OnTimeout: TimeoutDrop}, false},
+		{"max in flight", map[string]interface{}{"range": "", "timeout": "1s", "max_in_flight": 8},
+			PluginConfig{Name: "range", Args: []string{}, Timeout: time.Second, OnTimeout: TimeoutSkip, MaxInFlight: 8}, false},
+		{"bad max in flight", map[string]interface{}{"range": "", "timeout": "1s", "max_in_flight": 0}, PluginConfig{}, true},
+		{"max in flight without timeout", map[string]interface{}{"range": "", "max_in_flight": 8}, PluginConfig{}, true},
+		{"two plugins", map[string]interface{}{"dns": "", "router": ""}, PluginConfig{}, true},
+		{"no plugin", map[string]interface{}{"timeout": "1s"}, PluginConfig{}, true},
+		{"bad timeout", map[string]interface{}{"dns": "", "timeout": "soon"}, PluginConfig{}, true},
+		{"bad action", map[string]interface{}{"dns": "", "timeout": "1s", "on_timeout": "retry"}, PluginConfig{}, true},
+		{"action without timeout", map[string]interface{}{"dns": "", "on_timeout": "drop"}, PluginConfig{}, true},
+	}
+
+	for _, tc := range testcases {
+		plugins, err := parsePlugins([]interface{}{tc.item})
+		if tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error state: %v", tc.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(plugins) != 1 || !reflect.DeepEqual(plugins[0], tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, plugins, tc.want)
+		}
+	}
+}
+
+func TestSharedArgs(t *testing.T) {
+	c := New()
+	c.Shared = map[string][]string{
+		"dns":           {"192.0.2.53", "2001:db8::53", "198.51.100.53"},
+		"searchdomains": {"example.com", "example.org"},
+	}
+	testcases := []struct {
+		name string
+		ver  protocolVersion
+		args []string
+		ok   bool
+	}{
+		{"dns", protocolV4, []string{"192.0.2.53", "198.51.100.53"}, true},
+		{"dns", protocolV6, []string{"2001:db8::53"}, true},
+		{"searchdomains", protocolV4, []string{"example.com", "example.org"}, true},
+		{"searchdomains", protocolV6, []string{"example.com", "example.org"}, true},
+		{"router", protocolV4, nil, false},
+	}
+	for _, tc := range testcases {
+		args, ok := c.sharedArgs(tc.name, tc.ver)
+		if ok != tc.ok || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%s (v%d): got %v, %v, want %v, %v", tc.name, tc.ver, args, ok, tc.args, tc.ok)
+		}
+	}
+}
+
+func TestParseServerConfig(t *testing.T) {
+	testcases := []struct {
+		name   string
+		server map[string]interface{}
+		want   time.Duration
+		err    bool
+	}{
+		{"defaults", map[string]interface{}{}, 0, false},
+		{"nak_interval", map[string]interface{}{"nak_interval": "10s"}, 10 * time.Second, false},
+		{"bad nak_interval", map[string]interface{}{"nak_interval": "often"}, 0, true},
+		{"negative nak_interval", map[string]interface{}{"nak_interval": "-1s"}, 0, true},
+		{"bad deadline", map[string]interface{}{"deadline": "never"}, 0, true},
+		{"bad defer_offers", map[string]interface{}{"defer_offers": "later"}, 0, true},
+		{"bad relay_stats_interval", map[string]interface{}{"relay_stats_interval": "-1m"}, 0, true},
+	}
+
+	for _, tc := range testcases {
+		c := New()
+		tc.server["plugins"] = []interface{}{map[string]interface{}{"dns": "192.0.2.53"}}
+		c.v.Set("server4", tc.server)
+		err := c.parseConfig(protocolV4)
+		if tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error state: %v", tc.name, err)
+			continue
+		}
+		if err == nil && c.Server4.NakInterval != tc.want {
+			t.Errorf("%s: got nak_interval %s, want %s", tc.name, c.Server4.NakInterval, tc.want)
+		}
+	}
+}
+
+func TestParsePreferredRatio(t *testing.T) {
+	testcases := []struct {
+		name  string
+		ratio interface{}
+		want  float64
+		err   bool
+	}{
+		{"unset", nil, 0, false},
+		{"half", 0.5, 0.5, false},
+		{"string", "0.625", 0.625, false},
+		{"one", 1, 1, false},
+		{"zero", 0, 0, true},
+		{"above one", 1.5, 0, true},
+		{"not a number", "half", 0, true},
+	}
+
+	for _, tc := range testcases {
+		c := New()
+		server := map[string]interface{}{
+			"plugins": []interface{}{map[string]interface{}{"dns": "2001:db8::53"}},
+		}
+		if tc.ratio != nil {
+			server["preferred_ratio"] = tc.ratio
+		}
+		c.v.Set("server6", server)
+		err := c.parseConfig(protocolV6)
+		if tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error state: %v", tc.name, err)
+			continue
+		}
+		if err == nil && c.Server6.PreferredRatio != tc.want {
+			t.Errorf("%s: got preferred_ratio %v, want %v", tc.name, c.Server6.PreferredRatio, tc.want)
+		}
+	}
+}
+
+func TestParseDSCP(t *testing.T) {
+	testcases := []struct {
+		v    interface{}
+		want int
+		err  bool
+	}{
+		{46, 46, false},
+		{"0", 0, false},
+		{"cs6", 48, false},
+		{"AF41", 34, false},
+		{64, 0, true},
+		{-1, 0, true},
+		{"best", 0, true},
+	}
+	for _, tc := range testcases {
+		got, err := parseDSCP(tc.v)
+		if tc.err != (err != nil) || got != tc.want {
+			t.Errorf("parseDSCP(%v): got %d, %v, want %d (error: %t)", tc.v, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestParseResponseFormats(t *testing.T) {
+	formats, err := parseResponseFormats([]interface{}{
+		map[string]interface{}{"vendor": "MSFT", "option_order": "53 54 1", "min_size": 548},
+		map[string]interface{}{"option_order": []interface{}{3, 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ResponseFormat{
+		{Vendor: "MSFT", MinSize: 548, OptionOrder: []dhcpv4.OptionCode{
+			dhcpv4.GenericOptionCode(53), dhcpv4.GenericOptionCode(54), dhcpv4.GenericOptionCode(1),
+		}},
+		{OptionOrder: []dhcpv4.OptionCode{dhcpv4.GenericOptionCode(3), dhcpv4.GenericOptionCode(1)}},
+	}
+	if !reflect.DeepEqual(formats, want) {
+		t.Errorf("got %+v, want %+v", formats, want)
+	}
+
+	for _, v := range []interface{}{
+		"not a list",
+		[]interface{}{map[string]interface{}{"vendor": "MSFT"}},
+		[]interface{}{map[string]interface{}{"option_order": "1 1"}},
+		[]interface{}{map[string]interface{}{"option_order": "255"}},
+		[]interface{}{map[string]interface{}{"option_order": "256"}},
+		[]interface{}{map[string]interface{}{"min_size": 9000}},
+		[]interface{}{map[string]interface{}{"min_size": 576, "padding": 1}},
+	} {
+		if _, err := parseResponseFormats(v); err == nil {
+			t.Errorf("no error for %v", v)
+		}
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	c, err := FromMap(map[string]interface{}{
+		"setup_timeout": "30s",
+		"status_file":   "/run/coredhcp/status.json",
+		"server4": map[string]interface{}{
+			"listen":  []interface{}{"127.0.0.1:6767"},
+			"plugins": []interface{}{map[string]interface{}{"dns": "192.0.2.53"}},
+			"workers": 4,
+			"dscp":    "CS6",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server6 != nil {
+		t.Error("got a DHCPv6 configuration")
+	}
+	if len(c.Server4.Addresses) != 1 || c.Server4.Addresses[0].String() != "127.0.0.1:6767" {
+		t.Errorf("got addresses %v, want 127.0.0.1:6767", c.Server4.Addresses)
+	}
+	want := []PluginConfig{{Name: "dns", Args: []string{"192.0.2.53"}}}
+	if !reflect.DeepEqual(c.Server4.Plugins, want) {
+		t.Errorf("got plugins %+v, want %+v", c.Server4.Plugins, want)
+	}
+	if c.Server4.Workers != 4 {
+		t.Errorf("got %d workers, want 4", c.Server4.Workers)
+	}
+	if c.Server4.DSCP != 48 {
+		t.Errorf("got DSCP %d, want 48", c.Server4.DSCP)
+	}
+	if c.SetupTimeout != 30*time.Second {
+		t.Errorf("got setup_timeout %s, want 30s", c.SetupTimeout)
+	}
+	if c.StatusFile != "/run/coredhcp/status.json" || c.StatusInterval != defaultStatusInterval {
+		t.Errorf("got status file %q every %s, want /run/coredhcp/status.json every %s", c.StatusFile, c.StatusInterval, defaultStatusInterval)
+	}
+
+	c, err = FromMap(map[string]interface{}{
+		"server6": map[string]interface{}{
+			"unix":    "/run/coredhcp6.sock",
+			"plugins": []interface{}{map[string]interface{}{"dns": "2001:db8::53"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Server6.Addresses) != 0 || !reflect.DeepEqual(c.Server6.UnixSockets, []string{"/run/coredhcp6.sock"}) {
+		t.Errorf("got addresses %v and unix sockets %v, want only the unix socket", c.Server6.Addresses, c.Server6.UnixSockets)
+	}
+
+	if _, err := FromMap(map[string]interface{}{}); err == nil {
+		t.Error("no error for a configuration without servers")
+	}
+	if _, err := FromMap(map[string]interface{}{
+		"setup_timeout": "-1s",
+		"server6":       map[string]interface{}{"plugins": []interface{}{}},
+	}); err == nil {
+		t.Error("no error for a negative setup_timeout")
+	}
+	if _, err := FromMap(map[string]interface{}{
+		"status_interval": "0s",
+		"server6":         map[string]interface{}{"plugins": []interface{}{}},
+	}); err == nil {
+		t.Error("no error for a zero status_interval")
+	}
+}
+
+func TestIfaceFilter(t *testing.T) {
+	testcases := []struct {
+		name   string
+		filter ifaceFilter
+		iface  string
+		want   bool
+	}{
+		{"no filter", ifaceFilter{}, "eth0", true},
+		{"allowed", ifaceFilter{allow: []string{"eth*", "bond0"}}, "eth1", true},
+		{"not allowed", ifaceFilter{allow: []string{"eth*", "bond0"}}, "docker0", false},
+		{"denied", ifaceFilter{deny: []string{"docker*", "veth*"}}, "veth1a2b", false},
+		{"not denied", ifaceFilter{deny: []string{"docker*", "veth*"}}, "eth0", true},
+		{"deny wins", ifaceFilter{allow: []string{"*"}, deny: []string{"lo"}}, "lo", false},
+	}
+	for _, tc := range testcases {
+		if got := tc.filter.match(tc.iface); got != tc.want {
+			t.Errorf("%s: got %v for %s, want %v", tc.name, got, tc.iface, tc.want)
+		}
+	}
+}
+
+func TestParseIfaceFilter(t *testing.T) {
+	c := New()
+	c.v.Set("server6", map[string]interface{}{
+		"interfaces_allow": []interface{}{"eth*", "bond?"},
+		"interfaces_deny":  "eth9",
+	})
+	f, err := c.parseIfaceFilter(protocolV6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ifaceFilter{allow: []string{"eth*", "bond?"}, deny: []string{"eth9"}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+
+	c.v.Set("server6", map[string]interface{}{"interfaces_deny": "eth["})
+	if _, err := c.parseIfaceFilter(protocolV6); err == nil {
+		t.Error("no error for an invalid pattern")
+	}
+
+	// Nothing is left to listen on
+	if _, err := expandLLMulticast(&net.UDPAddr{IP: net.ParseIP("ff02::1:2"), Port: 547}, ifaceFilter{deny: []string{"*"}}); err == nil {
+		t.Error("no error when all interfaces are denied")
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+	"gopkg.in/yaml.v3"
+)
+
+// Profiles are configurations for common deployments, selected with the
+// top-level profile key. They are found in the profiles directory, and
+// structured like configuration files.
+//
+//go:embed profiles/*.yml
+var profileFiles embed.FS
+
+// profile is a built-in configuration the configuration file is laid over
+type profile struct {
+	name     string
+	settings map[string]interface{}
+	// needsArgs holds the plugins the profile lists without arguments, by
+	// protocol version. The configuration must provide their arguments.
+	needsArgs map[protocolVersion]map[string]bool
+	// defaults holds the arguments the profile lists for its plugins, by
+	// protocol version. They are used when the configuration provides none,
+	// and "${name}" stands for the first argument of the plugin name.
+	defaults map[protocolVersion]map[string][]string
+}
+
+// profileNames returns the names of the built-in profiles
+func profileNames() []string {
+	files, _ := fs.Glob(profileFiles, "profiles/*.yml")
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(path.Base(f), ".yml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadProfile(name string) (*profile, error) {
+	data, err := profileFiles.ReadFile("profiles/" + name + ".yml")
+	if err != nil {
+		return nil, ConfigErrorFromString("unknown profile '%s', want one of %s", name, strings.Join(profileNames(), ", "))
+	}
+	p := profile{
+		name:      name,
+		needsArgs: make(map[protocolVersion]map[string]bool),
+		defaults:  make(map[protocolVersion]map[string][]string),
+	}
+	if err := yaml.Unmarshal(data, &p.settings); err != nil {
+		return nil, fmt.Errorf("BUG: invalid profile %s: %w", name, err)
+	}
+	for _, ver := range []protocolVersion{protocolV4, protocolV6} {
+		key := fmt.Sprintf("server%d", ver)
+		if p.settings[key] == nil {
+			continue
+		}
+		section := cast.ToStringMap(p.settings[key])
+		needs := make(map[string]bool)
+		defaults := make(map[string][]string)
+		list := cast.ToSlice(section["plugins"])
+		for i, entry := range list {
+			name := pluginEntryName(entry)
+			if name == "" {
+				continue
+			}
+			settings := cast.ToStringMap(entry)
+			if settings[name] == nil {
+				needs[name] = true
+				continue
+			}
+			// The chain lists the plugin without arguments, so that shared
+			// arguments apply before the defaults of the profile
+			defaults[name] = strings.Fields(cast.ToString(settings[name]))
+			stripped := make(map[string]interface{}, len(settings))
+			for k, v := range settings {
+				stripped[k] = v
+			}
+			stripped[name] = nil
+			list[i] = stripped
+		}
+		section["plugins"] = list
+		p.settings[key] = section
+		p.needsArgs[ver] = needs
+		p.defaults[ver] = defaults
+	}
+	return &p, nil
+}
+
+// fill gives the plugins of a chain that the configuration provides no
+// arguments for the defaults of the profile, or reports the first one the
+// profile needs arguments for
+func (p *profile) fill(ver protocolVersion, plugins []PluginConfig) error {
+	given := make(map[string][]string)
+	for _, pc := range plugins {
+		if _, ok := given[pc.Name]; !ok && len(pc.Args) > 0 {
+			given[pc.Name] = pc.Args
+		}
+	}
+	for i := range plugins {
+		pc := &plugins[i]
+		if len(pc.Args) > 0 {
+			continue
+		}
+		if p.needsArgs[ver][pc.Name] {
+			return ConfigErrorFromString("dhcpv%d: profile %s needs arguments for plugin `%s`, in shared or in the server%d plugins",
+				ver, p.name, pc.Name, ver)
+		}
+		defaults, ok := p.defaults[ver][pc.Name]
+		if !ok {
+			continue
+		}
+		args := make([]string, 0, len(defaults))
+		for _, arg := range defaults {
+			if ref, ok := strings.CutPrefix(arg, "${"); ok && strings.HasSuffix(ref, "}") {
+				ref = strings.TrimSuffix(ref, "}")
+				if len(given[ref]) == 0 {
+					return ConfigErrorFromString("dhcpv%d: profile %s derives the arguments of plugin `%s` from those of `%s`, which has none",
+						ver, p.name, pc.Name, ref)
+				}
+				arg = given[ref][0]
+			}
+			args = append(args, arg)
+		}
+		log.Printf("DHCPv%d: using profile %s args for plugin `%s`", ver, p.name, pc.Name)
+		pc.Args = args
+	}
+	return nil
+}
+
+// applyProfile lays the configuration over the profile it selects, if any.
+// Settings of the configuration replace those of the profile, except that
+// plugins are merged into the chain of the profile: the first entry for a
+// plugin of the chain replaces it in place, and the others are appended.
+func (c *Config) applyProfile() error {
+	v := c.v.Get("profile")
+	if v == nil {
+		return nil
+	}
+	p, err := loadProfile(cast.ToString(v))
+	if err != nil {
+		return err
+	}
+	for key, value := range p.settings {
+		override := c.v.Get(key)
+		switch {
+		case override == nil:
+			c.v.Set(key, value)
+		case key == "server4" || key == "server6":
+			section, err := cast.ToStringMapE(override)
+			if err != nil {
+				return ConfigErrorFromString("%s: not a map of settings", key)
+			}
+			c.v.Set(key, mergeServer(cast.ToStringMap(value), section))
+		default:
+			if settings, err := cast.ToStringMapE(override); err == nil {
+				merged := cast.ToStringMap(value)
+				for k, v := range settings {
+					merged[k] = v
+				}
+				c.v.Set(key, merged)
+			}
+		}
+	}
+	c.profile = p
+	log.Printf("Using profile %s", p.name)
+	return nil
+}
+
+// mergeServer returns the settings of a server section of a profile, with
+// those of the configuration laid over them
+func mergeServer(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		if k == "plugins" {
+			v = mergePlugins(cast.ToSlice(base[k]), cast.ToSlice(v))
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+func mergePlugins(base, override []interface{}) []interface{} {
+	merged := append([]interface{}(nil), base...)
+	position := make(map[string]int, len(base))
+	for i, entry := range base {
+		if name := pluginEntryName(entry); name != "" {
+			if _, ok := position[name]; !ok {
+				position[name] = i
+			}
+		}
+	}
+	for _, entry := range override {
+		name := pluginEntryName(entry)
+		if i, ok := position[name]; ok && name != "" {
+			merged[i] = entry
+			delete(position, name)
+			continue
+		}
+		merged = append(merged, entry)
+	}
+	return merged
+}
+
+// pluginEntryName returns the name of the plugin of an item of a plugins
+// list, or "" if it has none
+func pluginEntryName(entry interface{}) string {
+	for k := range cast.ToStringMap(entry) {
+		switch k {
+		case pluginTimeoutKey, pluginOnTimeoutKey, pluginMaxInFlightKey:
+		default:
+			return k
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// profileArgs are arguments for every plugin the profiles need them for
+var profileArgs = map[string]interface{}{
+	"server_id": "10.10.10.1",
+	"dns":       "10.10.10.1 2001:db8::53",
+	"router":    "10.10.10.1",
+	"netmask":   "255.255.255.0",
+	"range":     "leases.txt 10.10.10.100 10.10.10.200 12h",
+	"prefix":    "2001:db8::/48 56",
+	"nbp":       "tftp://10.10.10.5/pxelinux.0",
+}
+
+func pluginNames(plugins []PluginConfig) []string {
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestProfiles(t *testing.T) {
+	names := profileNames()
+	if len(names) == 0 {
+		t.Fatal("no profiles")
+	}
+	for _, name := range names {
+		c, err := FromMap(map[string]interface{}{
+			"profile": name,
+			"shared":  profileArgs,
+			"server6": map[string]interface{}{
+				"plugins": []interface{}{map[string]interface{}{"server_id": "LL 00:de:ad:be:ef:00"}},
+			},
+		})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, sc := range []*ServerConfig{c.Server4, c.Server6} {
+			if sc == nil {
+				continue
+			}
+			for _, p := range sc.Plugins {
+				if len(p.Args) == 0 {
+					t.Errorf("%s: plugin %s has no arguments", name, p.Name)
+				}
+			}
+		}
+	}
+}
+
+func TestProfileOverrides(t *testing.T) {
+	c, err := FromMap(map[string]interface{}{
+		"profile": "isp-relay",
+		"shared":  profileArgs,
+		"server4": map[string]interface{}{
+			"workers": 2,
+			"plugins": []interface{}{
+				map[string]interface{}{"ratelimit": "circuit 5/1m"},
+				map[string]interface{}{"nak": "unknown subscriber"},
+			},
+		},
+		"server6": map[string]interface{}{
+			"plugins": []interface{}{map[string]interface{}{"server_id": "LL 00:de:ad:be:ef:00"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"server_id", "ratelimit", "dns", "router", "netmask", "range", "nak"}
+	if got := pluginNames(c.Server4.Plugins); !reflect.DeepEqual(got, want) {
+		t.Errorf("got DHCPv4 plugins %v, want %v", got, want)
+	}
+	if got := c.Server4.Plugins[1].Args; !reflect.DeepEqual(got, []string{"circuit", "5/1m"}) {
+		t.Errorf("got ratelimit args %v, want the overridden ones", got)
+	}
+	if got := c.Server6.Plugins[1].Args; !reflect.DeepEqual(got, []string{"remote", "20/1m", "burst=5", "max_clients=4"}) {
+		t.Errorf("got DHCPv6 ratelimit args %v, want the profile ones", got)
+	}
+	if c.Server4.Workers != 2 || c.Server6.Workers != 16 {
+		t.Errorf("got %d DHCPv4 and %d DHCPv6 workers, want 2 and 16", c.Server4.Workers, c.Server6.Workers)
+	}
+	if c.Server4.NakInterval != 10*time.Second {
+		t.Errorf("got nak_interval %s, want the profile's 10s", c.Server4.NakInterval)
+	}
+}
+
+func TestProfileDefaults(t *testing.T) {
+	c, err := FromMap(map[string]interface{}{
+		"profile": "home-router",
+		"shared": map[string]interface{}{
+			"server_id": "192.168.1.1",
+			"dns":       "9.9.9.9",
+			"range":     "leases.txt 192.168.1.100 192.168.1.200 12h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"server_id": {"192.168.1.1"},
+		"dns":       {"9.9.9.9"},
+		"router":    {"192.168.1.1"},
+		"netmask":   {"255.255.255.0"},
+		"range":     {"leases.txt", "192.168.1.100", "192.168.1.200", "12h"},
+	}
+	for _, p := range c.Server4.Plugins {
+		if !reflect.DeepEqual(p.Args, want[p.Name]) {
+			t.Errorf("got %s args %v, want %v", p.Name, p.Args, want[p.Name])
+		}
+	}
+
+	_, err = FromMap(map[string]interface{}{
+		"profile": "home-router",
+		"server4": map[string]interface{}{
+			"plugins": []interface{}{
+				map[string]interface{}{"server_id": ""},
+				map[string]interface{}{"dns": ""},
+			},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "`server_id`") {
+		t.Errorf("got %v for missing server_id arguments, want an error naming the plugin", err)
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	_, err := FromMap(map[string]interface{}{"profile": "datacenter"})
+	if err == nil || !strings.Contains(err.Error(), "home-router") {
+		t.Errorf("got %v for an unknown profile, want the list of profiles", err)
+	}
+
+	args := map[string]interface{}{}
+	for k, v := range profileArgs {
+		if k != "range" {
+			args[k] = v
+		}
+	}
+	_, err = FromMap(map[string]interface{}{"profile": "home-router", "shared": args})
+	if err == nil || !strings.Contains(err.Error(), "`range`") {
+		t.Errorf("got %v for missing range arguments, want an error naming the plugin", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package filewatch calls reload functions when files change, for plugins
+// that refresh their state from files. All files are watched by a single
+// shared fsnotify watcher.
+//
+// The directory of each file is watched rather than the file itself, so that
+// files replaced by a rename (as done by editors and config management tools)
+// or rotated keep being watched. Bursts of events are debounced into a single
+// reload.
+package filewatch
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+var log = logger.GetLogger("filewatch")
+
+// Debounce is how long a file must stay unchanged after an event before it
+// is reloaded
+var Debounce = 25 * time.Millisecond
+
+var (
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	// dirs counts the watches in each watched directory
+	dirs map[string]int
+	// watches holds the watches by file path
+	watches map[string][]*Watch
+)
+
+// Watch is a registered file watch
+type Watch struct {
+	path   string
+	reload func()
+
+	// mu protects timer and stopped, reloadMu serializes reloads
+	mu       sync.Mutex
+	timer    *time.Timer
+	stopped  bool
+	reloadMu sync.Mutex
+}
+
+// Add calls reload whenever the file at path is written, created, renamed or
+// removed, until the returned Watch is stopped. reload is never called
+// concurrently with itself.
+func Add(path string, reload func()) (*Watch, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot watch %s: %w", path, err)
+	}
+	dir := filepath.Dir(abs)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create watcher: %w", err)
+		}
+		watcher = w
+		dirs = make(map[string]int)
+		watches = make(map[string][]*Watch)
+		go run(w)
+	}
+	if dirs[dir] == 0 {
+		if err := watcher.Add(dir); err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	dirs[dir]++
+	w := &Watch{path: abs, reload: reload}
+	watches[abs] = append(watches[abs], w)
+	return w, nil
+}
+
+// Stop unregisters the watch. A reload in progress is not interrupted, but
+// no new one will start.
+func (w *Watch) Stop() {
+	mu.Lock()
+	list := watches[w.path]
+	for i, other := range list {
+		if other == w {
+			watches[w.path] = append(list[:i:i], list[i+1:]...)
+			dir := filepath.Dir(w.path)
+			dirs[dir]--
+			if dirs[dir] == 0 {
+				delete(dirs, dir)
+				if err := watcher.Remove(dir); err != nil {
+					log.Warningf("failed to stop watching %s: %v", dir, err)
+				}
+			}
+			break
+		}
+	}
+	if len(watches[w.path]) == 0 {
+		delete(watches, w.path)
+	}
+	mu.Unlock()
+
+	w.mu.Lock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+}
+
+// trigger schedules a reload after the debounce delay
+func (w *Watch) trigger() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(Debounce, w.fire)
+	} else {
+		w.timer.Reset(Debounce)
+	}
+}
+
+func (w *Watch) fire() {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+	w.mu.Lock()
+	stopped := w.stopped
+	w.mu.Unlock()
+	if !stopped {
+		w.reload()
+	}
+}
+
+// run dispatches the events of w to the watches of the files they are about
+func run(w *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			mu.Lock()
+			list := watches[filepath.Clean(ev.Name)]
+			mu.Unlock()
+			for _, watch := range list {
+				watch.trigger()
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Warningf("file watcher error: %v", err)
+		}
+	}
+}
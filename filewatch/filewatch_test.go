@@ -0,0 +1,53 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "leases.txt")
+	require.NoError(t, os.WriteFile(path, []byte("a\n"), 0644))
+
+	var reloads atomic.Int32
+	w, err := Add(path, func() { reloads.Add(1) })
+	require.NoError(t, err)
+
+	// A burst of writes is reloaded once
+	for i := 0; i < 5; i++ {
+		require.NoError(t, os.WriteFile(path, []byte("b\n"), 0644))
+	}
+	assert.Eventually(t, func() bool { return reloads.Load() == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(3 * Debounce)
+	assert.EqualValues(t, 1, reloads.Load(), "burst of writes was not debounced")
+
+	// Replacing the file by a rename, like editors do, is seen as well
+	tmp := filepath.Join(dir, "leases.txt.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("c\n"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	assert.Eventually(t, func() bool { return reloads.Load() == 2 }, time.Second, 5*time.Millisecond)
+
+	// Other files in the directory are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), nil, 0644))
+	time.Sleep(3 * Debounce)
+	assert.EqualValues(t, 2, reloads.Load(), "reloaded for another file")
+
+	w.Stop()
+	require.NoError(t, os.WriteFile(path, []byte("d\n"), 0644))
+	time.Sleep(3 * Debounce)
+	assert.EqualValues(t, 2, reloads.Load(), "reloaded after Stop")
+	mu.Lock()
+	assert.Empty(t, dirs, "directory still watched after the last Stop")
+	mu.Unlock()
+}
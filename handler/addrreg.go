@@ -0,0 +1,16 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import "github.com/insomniacslk/dhcp/dhcpv6"
+
+// Message types of the registration of self-assigned addresses (RFC 9686),
+// which the dhcpv6 library does not define. The server answers an
+// ADDR-REG-INFORM with an ADDR-REG-REPLY, which the plugin handling
+// registrations fills in.
+const (
+	MessageTypeAddrRegInform dhcpv6.MessageType = 36
+	MessageTypeAddrRegReply  dhcpv6.MessageType = 37
+)
@@ -0,0 +1,82 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// ClientClass selects DHCPv4 clients by the classes they send: those whose
+// vendor class (option 60) contains Vendor, and that send UserClass in their
+// user class option (77). Empty values match all clients.
+//
+// Enterprise selects the clients sending a vendor-identifying vendor class
+// (option 124, RFC 3925) for that enterprise number, with EnterpriseClass
+// among its classes if it is set. Zero, a reserved enterprise number, matches
+// all clients.
+type ClientClass struct {
+	Vendor, UserClass string
+	Enterprise        uint32
+	EnterpriseClass   string
+}
+
+// Matches returns whether req comes from a client of the class
+func (c ClientClass) Matches(req *dhcpv4.DHCPv4) bool {
+	if c.Vendor != "" && !strings.Contains(req.ClassIdentifier(), c.Vendor) {
+		return false
+	}
+	if c.Enterprise != 0 && !c.matchesEnterprise(req) {
+		return false
+	}
+	if c.UserClass != "" {
+		for _, uc := range req.UserClass() {
+			if uc == c.UserClass {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// SetEnterprise sets Enterprise and EnterpriseClass from a plugin argument
+// of the form <enterprise number>[:<class>]
+func (c *ClientClass) SetEnterprise(value string) error {
+	number, class, _ := strings.Cut(value, ":")
+	n, err := strconv.ParseUint(number, 10, 32)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid enterprise number %q", number)
+	}
+	c.Enterprise, c.EnterpriseClass = uint32(n), class
+	return nil
+}
+
+func (c ClientClass) matchesEnterprise(req *dhcpv4.DHCPv4) bool {
+	for _, id := range req.VIVC() {
+		if id.EntID != iana.EnterpriseID(c.Enterprise) {
+			continue
+		}
+		if c.EnterpriseClass == "" {
+			return true
+		}
+		// the class data is a list of classes, each prefixed by its length
+		for data := id.Data; len(data) > 0; {
+			n := int(data[0])
+			if n >= len(data) {
+				break
+			}
+			if string(data[1:1+n]) == c.EnterpriseClass {
+				return true
+			}
+			data = data[1+n:]
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestClientClassMatches(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007")),
+		dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		class ClientClass
+		want  bool
+	}{
+		{ClientClass{}, true},
+		{ClientClass{Vendor: "PXEClient"}, true},
+		{ClientClass{Vendor: "MSFT"}, false},
+		{ClientClass{UserClass: "iPXE"}, true},
+		{ClientClass{UserClass: "gPXE"}, false},
+		{ClientClass{Vendor: "PXEClient", UserClass: "iPXE"}, true},
+		{ClientClass{Vendor: "MSFT", UserClass: "iPXE"}, false},
+	}
+	for _, tc := range testcases {
+		if got := tc.class.Matches(req); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.class, got, tc.want)
+		}
+	}
+}
+
+func TestClientClassMatchesEnterprise(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithOption(dhcpv4.OptVIVC(
+			dhcpv4.VIVCIdentifier{EntID: 3561, Data: []byte("\x0cdslforum.org\x03cpe")},
+			dhcpv4.VIVCIdentifier{EntID: 4491, Data: []byte("\x05modem")},
+		)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		class ClientClass
+		want  bool
+	}{
+		{ClientClass{Enterprise: 3561}, true},
+		{ClientClass{Enterprise: 4491}, true},
+		{ClientClass{Enterprise: 9}, false},
+		{ClientClass{Enterprise: 3561, EnterpriseClass: "dslforum.org"}, true},
+		{ClientClass{Enterprise: 3561, EnterpriseClass: "cpe"}, true},
+		{ClientClass{Enterprise: 3561, EnterpriseClass: "modem"}, false},
+		{ClientClass{Enterprise: 4491, EnterpriseClass: "modem"}, true},
+		{ClientClass{Enterprise: 3561, Vendor: "PXEClient"}, false},
+	}
+	for _, tc := range testcases {
+		if got := tc.class.Matches(req); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.class, got, tc.want)
+		}
+	}
+
+	// a truncated class is ignored
+	req.UpdateOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 3561, Data: []byte("\x0cdslforum")}))
+	if (ClientClass{Enterprise: 3561, EnterpriseClass: "dslforum"}).Matches(req) {
+		t.Error("matched a truncated class")
+	}
+}
+
+func TestClientClassSetEnterprise(t *testing.T) {
+	var c ClientClass
+	if err := c.SetEnterprise("3561:dslforum.org"); err != nil || c.Enterprise != 3561 || c.EnterpriseClass != "dslforum.org" {
+		t.Errorf("got %+v, %v, want enterprise 3561 and class dslforum.org", c, err)
+	}
+	if err := c.SetEnterprise("4491"); err != nil || c.Enterprise != 4491 || c.EnterpriseClass != "" {
+		t.Errorf("got %+v, %v, want enterprise 4491 and no class", c, err)
+	}
+	for _, value := range []string{"", "0", "x:cpe", "4294967296"} {
+		if err := c.SetEnterprise(value); err == nil {
+			t.Errorf("no error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Metadata holds information about how a request was received, which is not
+// part of the DHCP message itself.
+type Metadata struct {
+	// IfIndex and IfName identify the interface the request was received on.
+	// IfIndex is 0 if the interface is unknown.
+	IfIndex int
+	IfName  string
+	// Peer is the address the request was received from, which is the relay
+	// for relayed requests
+	Peer net.Addr
+	// Dst is the destination address of the request, and HopLimit the TTL
+	// (DHCPv4) or hop limit (DHCPv6) it arrived with. They are nil and 0 when
+	// unknown. Packets from the link itself arrive with the hop limit they
+	// were sent with, usually 255 for DHCPv6 and 64 or 128 for DHCPv4, so
+	// lower values reveal requests coming from further away.
+	Dst      net.IP
+	HopLimit int
+	// DSCP is the differentiated services code point of the request, DHCPv6
+	// only. It is 0 when unknown.
+	DSCP int
+	// ResponseDSCP is the differentiated services code point the response is
+	// marked with, which plugins can set to apply QoS policies. It is 0 by
+	// default, to keep the marking configured for the listener.
+	ResponseDSCP int
+	// Pool is the name of the pool the address in the response was allocated
+	// from. It is set by allocating plugins, so that the plugins after them
+	// can apply pool-specific settings, see ForPool4.
+	Pool string
+	// Context expires when the client has certainly given up on the request.
+	// Plugins calling external services should pass it along, see Context4
+	// and Context6.
+	Context context.Context
+	// DryRun is set for synthetic requests, such as those of the startup
+	// self-test. Plugins answer them as usual, but must not keep any state
+	// for them, such as a lease.
+	DryRun bool
+}
+
+// Context4 returns the context of a DHCPv4 request being handled, or a
+// background context if there is none
+func Context4(req *dhcpv4.DHCPv4) context.Context {
+	if md := Metadata4(req); md != nil && md.Context != nil {
+		return md.Context
+	}
+	return context.Background()
+}
+
+// Context6 returns the context of a DHCPv6 request being handled, or a
+// background context if there is none
+func Context6(req dhcpv6.DHCPv6) context.Context {
+	if md := Metadata6(req); md != nil && md.Context != nil {
+		return md.Context
+	}
+	return context.Background()
+}
+
+// metadata maps requests being handled to their Metadata. Handlers have a
+// fixed signature, so this lets the server pass more information along with
+// a request without breaking existing plugins.
+var metadata sync.Map
+
+// SetMetadata4 attaches md to a DHCPv4 request until ClearMetadata4 is called.
+// It is meant to be called by the server before running the handlers.
+func SetMetadata4(req *dhcpv4.DHCPv4, md *Metadata) {
+	metadata.Store(req, md)
+}
+
+// ClearMetadata4 detaches the metadata from a DHCPv4 request
+func ClearMetadata4(req *dhcpv4.DHCPv4) {
+	metadata.Delete(req)
+}
+
+// Metadata4 returns the metadata of a DHCPv4 request being handled, or nil
+// if there is none.
+func Metadata4(req *dhcpv4.DHCPv4) *Metadata {
+	if md, ok := metadata.Load(req); ok {
+		return md.(*Metadata)
+	}
+	return nil
+}
+
+// SetMetadata6 attaches md to a DHCPv6 request until ClearMetadata6 is called.
+// The request is the message as received, before decapsulating relay messages.
+func SetMetadata6(req dhcpv6.DHCPv6, md *Metadata) {
+	metadata.Store(req, md)
+}
+
+// ClearMetadata6 detaches the metadata from a DHCPv6 request
+func ClearMetadata6(req dhcpv6.DHCPv6) {
+	metadata.Delete(req)
+}
+
+// Metadata6 returns the metadata of a DHCPv6 request being handled, or nil
+// if there is none.
+func Metadata6(req dhcpv6.DHCPv6) *Metadata {
+	if md, ok := metadata.Load(req); ok {
+		return md.(*Metadata)
+	}
+	return nil
+}
+
+// ForPool4 wraps h so that it only handles requests whose address was
+// allocated from pool. It returns h as is if pool is empty.
+func ForPool4(pool string, h Handler4) Handler4 {
+	if pool == "" {
+		return h
+	}
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		if md := Metadata4(req); md == nil || md.Pool != pool {
+			return resp, false
+		}
+		return h(req, resp)
+	}
+}
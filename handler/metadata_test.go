@@ -0,0 +1,41 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestForPool4(t *testing.T) {
+	var called bool
+	h := ForPool4("guests", func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		called = true
+		return resp, false
+	})
+	testcases := []struct {
+		name string
+		md   *Metadata
+		want bool
+	}{
+		{"no metadata", nil, false},
+		{"no pool", &Metadata{}, false},
+		{"other pool", &Metadata{Pool: "staff"}, false},
+		{"matching pool", &Metadata{Pool: "guests"}, true},
+	}
+	for _, tc := range testcases {
+		called = false
+		req := &dhcpv4.DHCPv4{}
+		if tc.md != nil {
+			SetMetadata4(req, tc.md)
+		}
+		h(req, &dhcpv4.DHCPv4{})
+		ClearMetadata4(req)
+		if called != tc.want {
+			t.Errorf("%s: handler called=%v, want %v", tc.name, called, tc.want)
+		}
+	}
+}
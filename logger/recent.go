@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RecentError is an error logged by the server
+type RecentError struct {
+	Time    time.Time `json:"time"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Message string    `json:"message"`
+}
+
+// RecentErrors is a log hook keeping the last errors logged, for status
+// reports
+type RecentErrors struct {
+	max int
+
+	mu     sync.Mutex
+	errors []RecentError
+}
+
+// WithRecentErrors keeps the last max errors logged. It must be called after
+// WithRedaction, so that the errors kept are redacted.
+func WithRecentErrors(log *logrus.Entry, max int) *RecentErrors {
+	r := &RecentErrors{max: max}
+	log.Logger.AddHook(r)
+	return r
+}
+
+// Levels implements logrus.Hook
+func (r *RecentErrors) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+// Fire implements logrus.Hook
+func (r *RecentErrors) Fire(e *logrus.Entry) error {
+	prefix, _ := e.Data["prefix"].(string)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errors) == r.max {
+		r.errors = append(r.errors[:0], r.errors[1:]...)
+	}
+	r.errors = append(r.errors, RecentError{Time: e.Time, Prefix: prefix, Message: e.Message})
+	return nil
+}
+
+// List returns the errors kept, oldest first
+func (r *RecentErrors) List() []RecentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecentError(nil), r.errors...)
+}
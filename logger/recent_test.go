@@ -0,0 +1,33 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentErrors(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	log := l.WithField("prefix", "test")
+	r := WithRecentErrors(log, 2)
+
+	log.Warning("not an error")
+	log.Error("first")
+	log.Error("second")
+	log.Errorf("third %d", 3)
+
+	errors := r.List()
+	if assert.Len(t, errors, 2) {
+		assert.Equal(t, "second", errors[0].Message)
+		assert.Equal(t, "third 3", errors[1].Message)
+		assert.Equal(t, "test", errors[1].Prefix)
+		assert.False(t, errors[1].Time.IsZero())
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// macRegexp matches 6 and 8 byte hardware addresses, with ':' or '-'
+	// separators
+	macRegexp = regexp.MustCompile(`\b[0-9A-Fa-f]{2}[:-][0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){4}(?:[:-][0-9A-Fa-f]{2}){0,2}\b`)
+	// summaryRegexp matches the options identifying clients as printed in
+	// packet summaries, one per line: the hostname (DHCPv4 option 12), the
+	// client FQDN (DHCPv4 option 81, DHCPv6 option 39) and the client
+	// identifier (DHCPv4 option 61, DHCPv6 option 1). Their values run to
+	// the end of the line, as hostnames and identifiers may contain spaces.
+	summaryRegexp = regexp.MustCompile(`(Host Name|FQDN|Client identifier|Client ID)(: )([^\r\n]+)`)
+)
+
+// summaryKinds are the kinds of hash of the options of summaryRegexp
+var summaryKinds = map[string]string{
+	"Host Name":         "host",
+	"FQDN":              "host",
+	"Client identifier": "client",
+	"Client ID":         "client",
+}
+
+// hostnameFields are the log fields holding hostnames
+var hostnameFields = map[string]bool{"hostname": true, "host_name": true, "fqdn": true}
+
+// redactHook replaces personal data in log entries by a keyed hash of it
+type redactHook struct {
+	key []byte
+}
+
+// WithRedaction replaces MAC addresses, hostnames and client identifiers in log
+// messages and fields by a hash keyed with key. Entries about the same client
+// can still be correlated, but the client cannot be identified without the
+// key. It must be called before WithFile, so that redaction applies to the log
+// file.
+func WithRedaction(log *logrus.Entry, key []byte) {
+	log.Logger.AddHook(&redactHook{key: key})
+}
+
+// Levels implements logrus.Hook
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook. Entries are copied before hooks run, so they
+// can be modified in place.
+func (h *redactHook) Fire(e *logrus.Entry) error {
+	e.Message = h.redact(e.Message)
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			if hostnameFields[k] {
+				e.Data[k] = h.hash("host", v)
+			} else {
+				e.Data[k] = h.redact(v)
+			}
+		case net.HardwareAddr:
+			e.Data[k] = h.hash("mac", v.String())
+		}
+	}
+	return nil
+}
+
+// redact replaces the MAC addresses, hostnames and client identifiers in s.
+// Options are replaced first, so that a client identifier holding a MAC
+// address is hashed as a whole.
+func (h *redactHook) redact(s string) string {
+	s = summaryRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		sub := summaryRegexp.FindStringSubmatch(m)
+		return sub[1] + sub[2] + h.hash(summaryKinds[sub[1]], sub[3])
+	})
+	return macRegexp.ReplaceAllStringFunc(s, func(mac string) string {
+		return h.hash("mac", strings.ToLower(strings.ReplaceAll(mac, "-", ":")))
+	})
+}
+
+// hash returns a short keyed hash of the value, prefixed with its kind
+func (h *redactHook) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return kind + "-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
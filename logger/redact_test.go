@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	h := &redactHook{key: []byte("secret")}
+	mac := h.hash("mac", "aa:bb:cc:dd:ee:ff")
+
+	assert.Equal(t, "MAC address "+mac+" is new", h.redact("MAC address aa:bb:cc:dd:ee:ff is new"))
+	assert.Equal(t, mac, h.redact("AA-BB-CC-DD-EE-FF"), "notation does not change the hash")
+	assert.Equal(t, "Host Name: "+h.hash("host", "laptop")+"\n", h.redact("Host Name: laptop\n"))
+	assert.Equal(t, "    Host Name: "+h.hash("host", "alice laptop")+"\n    DHCP Message Type: DISCOVER\n",
+		h.redact("    Host Name: alice laptop\n    DHCP Message Type: DISCOVER\n"), "hostnames run to the end of the line")
+
+	// client FQDN, DHCPv4 option 81 and DHCPv6 option 39
+	fqdn4 := "[1 0 0 97 108 105 99 101 46 101 120 97 109 112 108 101]"
+	assert.Equal(t, "    FQDN: "+h.hash("host", fqdn4), h.redact("    FQDN: "+fqdn4))
+	fqdn6 := "{Flags=0 DomainName=[alice.example.com]}"
+	assert.Equal(t, "    FQDN: "+h.hash("host", fqdn6)+"\n", h.redact("    FQDN: "+fqdn6+"\n"))
+
+	// client identifiers, DHCPv4 option 61 and DHCPv6 option 1, hashed as a
+	// whole even when they contain a MAC address
+	assert.Equal(t, "    Client identifier: "+h.hash("client", "[1 170 187 204 221 238 255]"),
+		h.redact("    Client identifier: [1 170 187 204 221 238 255]"))
+	duid := "DUID-LLT{HWType=Ethernet HWAddr=aa:bb:cc:dd:ee:ff Time=845495683}"
+	assert.Equal(t, "    Client ID: "+h.hash("client", duid)+"\n    Elapsed Time: 0s",
+		h.redact("    Client ID: "+duid+"\n    Elapsed Time: 0s"))
+	assert.Equal(t, "lease 10.0.0.1 for 2001:db8::1", h.redact("lease 10.0.0.1 for 2001:db8::1"), "addresses are kept")
+
+	other := &redactHook{key: []byte("other")}
+	assert.NotEqual(t, mac, other.hash("mac", "aa:bb:cc:dd:ee:ff"), "hash is not keyed")
+}
+
+func TestRedactHook(t *testing.T) {
+	h := &redactHook{key: []byte("secret")}
+	e := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"hwaddr":   net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		"hostname": "laptop",
+		"fqdn":     "laptop.example.com",
+		"iface":    "eth0",
+	})
+	e.Message = "request from aa:bb:cc:dd:ee:ff"
+	assert.NoError(t, h.Fire(e))
+	for _, v := range []interface{}{e.Message, e.Data["hwaddr"], e.Data["hostname"], e.Data["fqdn"]} {
+		s := v.(string)
+		assert.False(t, strings.Contains(s, "aa:bb") || strings.Contains(s, "laptop"), "not redacted: %s", s)
+	}
+	assert.Equal(t, "eth0", e.Data["iface"])
+}
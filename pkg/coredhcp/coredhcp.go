@@ -0,0 +1,293 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package coredhcp is the API for programs embedding a CoreDHCP server.
+//
+// Unlike the other packages of this module, whose exported identifiers may
+// change between releases as the server evolves, this package follows
+// semantic versioning: within a major version of the module, its exported
+// identifiers are neither removed nor changed in incompatible ways. Programs
+// embedding CoreDHCP should only depend on it, and on the plugins they use.
+// The one exception is RegisterBuiltin, which takes the description of a
+// plugin of this module as the plugins package defines it.
+//
+// A typical embedding registers its plugins, builds a configuration and runs
+// a server until it is stopped:
+//
+//	if err := coredhcp.RegisterBuiltin(&rangeplugin.Plugin); err != nil {
+//		...
+//	}
+//	conf, err := coredhcp.NewConfig().
+//		Listen4("0.0.0.0%eth1").
+//		Plugin4("server_id", "10.0.0.1").
+//		Plugin4("range", "leases.db", "10.0.0.10", "10.0.0.200", "1h").
+//		Build()
+//	...
+//	srv, err := coredhcp.Start(conf)
+//	...
+//	defer srv.Stop()
+//
+// Leases are owned by the plugins that allocate them, and are accessed through
+// the plugins' own APIs and storage.
+package coredhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/server"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Handler4 handles a DHCPv4 request. It is given the request and the response
+// built by the plugins before it, and returns the response, possibly modified,
+// along with whether the chain stops there. A nil response with true drops
+// the request.
+type Handler4 = func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
+
+// Handler6 is like Handler4, for DHCPv6 requests
+type Handler6 = func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool)
+
+// Plugin describes a plugin for Register. Plugins already described for the
+// server, such as the builtin ones, are registered with RegisterBuiltin
+// instead.
+type Plugin struct {
+	// Name is the name of the plugin in configurations
+	Name string
+	// Setup6 and Setup4 set up an instance of the plugin for DHCPv6 and
+	// DHCPv4 with the arguments of its configuration entry. Either can be
+	// nil when the plugin does not handle that protocol.
+	Setup6 func(args ...string) (Handler6, error)
+	Setup4 func(args ...string) (Handler4, error)
+	// DependsOn lists the plugins that must be set up before this one when
+	// they are configured
+	DependsOn []string
+	// Version is reported in the plugin inventory
+	Version string
+}
+
+var (
+	// registeredMu guards registered, and the registrations with the plugins
+	// package
+	registeredMu sync.Mutex
+	// registered maps the names of the plugins registered through this package
+	// to their description, to tell a plugin registered again from another one
+	registered = make(map[string]interface{})
+)
+
+// Register makes a plugin available to configurations. Registering the same
+// plugin more than once has no effect, while registering a different plugin
+// under the name of a registered one is an error. Register and
+// RegisterBuiltin can be called from several goroutines, but plugins must be
+// registered before the servers using them are started.
+func Register(p *Plugin) error {
+	if p == nil {
+		return errors.New("cannot register nil plugin")
+	}
+	desc := &plugins.Plugin{
+		Name:      p.Name,
+		DependsOn: p.DependsOn,
+		Version:   p.Version,
+	}
+	if p.Setup6 != nil {
+		desc.Setup6 = func(args ...string) (handler.Handler6, error) {
+			return p.Setup6(args...)
+		}
+	}
+	if p.Setup4 != nil {
+		desc.Setup4 = func(args ...string) (handler.Handler4, error) {
+			return p.Setup4(args...)
+		}
+	}
+	return register(p.Name, p, desc)
+}
+
+// RegisterBuiltin makes a plugin of this module, such as those under the
+// plugins directory, available to configurations. Like Register, registering
+// it more than once has no effect.
+func RegisterBuiltin(p *plugins.Plugin) error {
+	if p == nil {
+		return errors.New("cannot register nil plugin")
+	}
+	return register(p.Name, p, p)
+}
+
+func register(name string, key interface{}, desc *plugins.Plugin) error {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if _, ok := plugins.RegisteredPlugins[name]; ok {
+		if registered[name] == key {
+			return nil
+		}
+		return fmt.Errorf("another plugin named '%s' is already registered", name)
+	}
+	if err := plugins.RegisterPlugin(desc); err != nil {
+		return err
+	}
+	registered[name] = key
+	return nil
+}
+
+// Config is a server configuration
+type Config struct {
+	c *config.Config
+}
+
+// LoadConfig reads a configuration file, in the format described in
+// cmds/coredhcp/config.yml.example.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return nil, errors.New("no configuration file given")
+	}
+	c, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{c: c}, nil
+}
+
+// ConfigBuilder builds a configuration programmatically. Its methods map to
+// the keys of the configuration file, and the configuration is checked the
+// same way when Build is called.
+type ConfigBuilder struct {
+	settings map[string]interface{}
+}
+
+// NewConfig returns an empty configuration builder
+func NewConfig() *ConfigBuilder {
+	return &ConfigBuilder{settings: make(map[string]interface{})}
+}
+
+func (b *ConfigBuilder) server(ver int) map[string]interface{} {
+	key := fmt.Sprintf("server%d", ver)
+	s, ok := b.settings[key].(map[string]interface{})
+	if !ok {
+		s = make(map[string]interface{})
+		b.settings[key] = s
+	}
+	return s
+}
+
+func (b *ConfigBuilder) listen(ver int, addrs []string) *ConfigBuilder {
+	s := b.server(ver)
+	listen, _ := s["listen"].([]interface{})
+	for _, addr := range addrs {
+		listen = append(listen, addr)
+	}
+	s["listen"] = listen
+	return b
+}
+
+func (b *ConfigBuilder) plugin(ver int, name string, args []string) *ConfigBuilder {
+	s := b.server(ver)
+	list, _ := s["plugins"].([]interface{})
+	// Arguments are kept as a list, so that they can contain whitespace
+	entry := make([]interface{}, len(args))
+	for i, arg := range args {
+		entry[i] = arg
+	}
+	s["plugins"] = append(list, map[string]interface{}{name: entry})
+	return b
+}
+
+// Listen4 adds DHCPv4 listen addresses, in the "address%interface:port"
+// format of the configuration file
+func (b *ConfigBuilder) Listen4(addrs ...string) *ConfigBuilder {
+	return b.listen(4, addrs)
+}
+
+// Listen6 adds DHCPv6 listen addresses, in the "[address%interface]:port"
+// format of the configuration file
+func (b *ConfigBuilder) Listen6(addrs ...string) *ConfigBuilder {
+	return b.listen(6, addrs)
+}
+
+// Plugin4 appends a plugin to the DHCPv4 plugin chain
+func (b *ConfigBuilder) Plugin4(name string, args ...string) *ConfigBuilder {
+	return b.plugin(4, name, args)
+}
+
+// Plugin6 appends a plugin to the DHCPv6 plugin chain
+func (b *ConfigBuilder) Plugin6(name string, args ...string) *ConfigBuilder {
+	return b.plugin(6, name, args)
+}
+
+// Set sets any other key of the configuration file, given as a dotted path
+// such as "server4.workers" or "gomaxprocs"
+func (b *ConfigBuilder) Set(key string, value interface{}) *ConfigBuilder {
+	m := b.settings
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+	return b
+}
+
+// Build checks the configuration and returns it
+func (b *ConfigBuilder) Build() (*Config, error) {
+	c, err := config.FromMap(b.settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{c: c}, nil
+}
+
+// Server is a running DHCP server
+type Server struct {
+	s *server.Servers
+}
+
+// Start sets up the plugins of a configuration, which must have been
+// registered, and starts serving requests.
+func Start(conf *Config) (*Server, error) {
+	s, err := server.Start(conf.c)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{s: s}, nil
+}
+
+// StartInMemory is like Start, but serves requests from in-memory
+// connections instead of sockets, to test a configuration without privileges.
+// It returns the client ends of the DHCPv4 and DHCPv6 connections, which are
+// nil when the corresponding server is not configured.
+func StartInMemory(conf *Config) (srv *Server, client4, client6 net.PacketConn, err error) {
+	s, client4, client6, err := server.StartInMemory(conf.c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &Server{s: s}, client4, client6, nil
+}
+
+// SelfTest sends synthetic requests through the plugins of the server, and
+// returns an error if they are not answered. It runs alongside the requests
+// of clients; set the "selftest" key of the configuration to run it before
+// any client is served instead.
+func (s *Server) SelfTest() error {
+	return s.s.SelfTest()
+}
+
+// Stop stops serving requests
+func (s *Server) Stop() {
+	s.s.Close()
+}
+
+// Wait blocks until the server stops, because of an error or because Stop was
+// called, and returns the errors of its listeners
+func (s *Server) Wait() error {
+	return s.s.Wait()
+}
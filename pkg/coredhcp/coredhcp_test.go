@@ -0,0 +1,107 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package coredhcp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routerPlugin sets the router option to its argument
+var routerPlugin = Plugin{
+	Name: "embedtest_router",
+	Setup4: func(args ...string) (Handler4, error) {
+		router := net.ParseIP(args[0])
+		return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			resp.UpdateOption(dhcpv4.OptRouter(router))
+			return resp, false
+		}, nil
+	},
+}
+
+func TestRegister(t *testing.T) {
+	require.NoError(t, Register(&routerPlugin))
+	assert.NoError(t, Register(&routerPlugin), "registering the same plugin again")
+	assert.Error(t, Register(&Plugin{Name: routerPlugin.Name}))
+	assert.Error(t, Register(nil))
+
+	builtin := &plugins.Plugin{Name: "embedtest_builtin"}
+	require.NoError(t, RegisterBuiltin(builtin))
+	assert.NoError(t, RegisterBuiltin(builtin), "registering the same plugin again")
+	assert.Error(t, Register(&Plugin{Name: builtin.Name}))
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	p := &Plugin{Name: "embedtest_concurrent"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, Register(p))
+		}()
+	}
+	wg.Wait()
+	assert.Error(t, Register(&Plugin{Name: p.Name}))
+}
+
+func TestConfigBuilder(t *testing.T) {
+	_, err := NewConfig().Build()
+	assert.Error(t, err, "configuration without servers")
+
+	conf, err := NewConfig().
+		Listen4("127.0.0.1:6767").
+		Plugin4("dns", "192.0.2.53", "192.0.2.54").
+		Plugin4("file", "static leases.txt").
+		Set("server4.workers", 2).
+		Build()
+	require.NoError(t, err)
+	require.NotNil(t, conf.c.Server4)
+	assert.Nil(t, conf.c.Server6)
+	assert.Equal(t, "127.0.0.1:6767", conf.c.Server4.Addresses[0].String())
+	assert.Equal(t, []string{"192.0.2.53", "192.0.2.54"}, conf.c.Server4.Plugins[0].Args)
+	assert.Equal(t, []string{"static leases.txt"}, conf.c.Server4.Plugins[1].Args)
+	assert.Equal(t, 2, conf.c.Server4.Workers)
+}
+
+func TestStartInMemory(t *testing.T) {
+	require.NoError(t, Register(&routerPlugin))
+	conf, err := NewConfig().Plugin4(routerPlugin.Name, "192.0.2.1").Build()
+	require.NoError(t, err)
+	srv, client4, client6, err := StartInMemory(conf)
+	require.NoError(t, err)
+	assert.Nil(t, client6)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	_, err = client4.WriteTo(req.ToBytes(), nil)
+	require.NoError(t, err)
+	require.NoError(t, client4.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 1500)
+	n, _, err := client4.ReadFrom(buf)
+	require.NoError(t, err)
+	resp, err := dhcpv4.FromBytes(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1").To4()}, resp.Router())
+
+	srv.Stop()
+	done := make(chan struct{})
+	go func() {
+		_ = srv.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package acs provides the address of a TR-069 auto-configuration server (ACS)
+// to CPEs such as home gateways, as described in TR-069 Annex F.
+//
+// The first argument is the ACS URL. It can be followed by optional key=value
+// arguments:
+// - code=<provisioning code>: provisioning code sent along with the URL
+// - vendor=<class>: only answer clients whose vendor class (option 60)
+// contains this string, for example dslforum.org
+// - userclass=<class>: only answer clients sending this user class (option 77)
+// - vendorclass=<enterprise number>[:<class>]: only answer clients sending a
+// vendor-identifying vendor class (option 124) for this enterprise number, with
+// this class among its classes if given
+// - enterprise=<number>: send the sub-options in the vendor-identifying vendor
+// specific information option (125) for this enterprise number, rather than in
+// the vendor specific information option (43)
+//
+// The plugin only answers clients that request the option it sends. Several
+// instances can be configured for different vendors. Instances with different
+// enterprise numbers answering the same client add their sub-options to the
+// same option 125, while for option 43 and for a same enterprise number, the
+// last instance wins. For example:
+//
+//	server4:
+//	  plugins:
+//	    - acs: https://acs.example.net/cwmp vendor=dslforum.org code=residential
+//	    - acs: https://acs.example.net/vendor-x enterprise=4491 userclass=gateway
+package acs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+var log = logger.GetLogger("plugins/acs")
+
+// Plugin wraps the ACS plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "acs",
+	Setup4: setup4,
+}
+
+// Sub-options of the vendor specific information, TR-069 Annex F
+const (
+	subOptionURL              = 1
+	subOptionProvisioningCode = 2
+)
+
+type config struct {
+	class handler.ClientClass
+	// option is the option to send, encoded once at setup
+	option dhcpv4.Option
+	// enterprise is the enterprise number of option 125, if it is the
+	// option to send
+	enterprise *uint32
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need an ACS URL")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ACS URL %q, want an http or https URL", args[0])
+	}
+	var (
+		c          config
+		code       string
+		enterprise uint64
+		vivso      bool
+	)
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid argument %q, want key=value", arg)
+		}
+		switch key {
+		case "code":
+			code = value
+		case "vendor":
+			c.class.Vendor = value
+		case "userclass":
+			c.class.UserClass = value
+		case "vendorclass":
+			if err := c.class.SetEnterprise(value); err != nil {
+				return nil, err
+			}
+		case "enterprise":
+			enterprise, err = strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid enterprise number %q", value)
+			}
+			vivso = true
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+
+	data, err := encodeSubOptions(args[0], code)
+	if err != nil {
+		return nil, err
+	}
+	if vivso {
+		// RFC 3925, section 4
+		if len(data) > math.MaxUint8-5 {
+			return nil, errors.New("sub-options too long for option 125")
+		}
+		e := uint32(enterprise)
+		v := binary.BigEndian.AppendUint32(nil, e)
+		v = append(v, byte(len(data)))
+		c.option = dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, append(v, data...))
+		c.enterprise = &e
+	} else {
+		c.option = dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, data)
+	}
+	log.Infof("loaded ACS URL %s", args[0])
+	return c.Handler4, nil
+}
+
+// encodeSubOptions encodes the ACS URL and provisioning code as sub-options
+func encodeSubOptions(acsURL, code string) ([]byte, error) {
+	var data []byte
+	for _, sub := range []struct {
+		code  byte
+		value string
+	}{{subOptionURL, acsURL}, {subOptionProvisioningCode, code}} {
+		if sub.value == "" {
+			continue
+		}
+		if len(sub.value) > math.MaxUint8 {
+			return nil, fmt.Errorf("sub-option %d is longer than %d bytes", sub.code, math.MaxUint8)
+		}
+		data = append(data, sub.code, byte(len(sub.value)))
+		data = append(data, sub.value...)
+	}
+	return data, nil
+}
+
+// merge returns option 125 with the sub-options of this instance added to
+// those of the other enterprises in the option already in the response, as
+// RFC 3925 allows one option to carry several enterprises
+func (c *config) merge(existing []byte) dhcpv4.Option {
+	var data []byte
+	for len(existing) >= 5 {
+		n := 5 + int(existing[4])
+		if n > len(existing) {
+			log.Warningf("Dropping truncated option 125 data for enterprise %d", binary.BigEndian.Uint32(existing))
+			break
+		}
+		if binary.BigEndian.Uint32(existing) != *c.enterprise {
+			data = append(data, existing[:n]...)
+		}
+		existing = existing[n:]
+	}
+	// Options longer than 255 bytes are split when sent, RFC 3396
+	data = append(data, c.option.Value.ToBytes()...)
+	return dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, data)
+}
+
+// Handler4 handles DHCPv4 packets for the acs plugin
+func (c *config) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if !req.IsOptionRequested(c.option.Code) || !c.class.Matches(req) {
+		return resp, false
+	}
+	if c.enterprise != nil {
+		resp.Options.Update(c.merge(resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific)))
+	} else {
+		resp.Options.Update(c.option)
+	}
+	return resp, false
+}
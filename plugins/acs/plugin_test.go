@@ -0,0 +1,125 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package acs
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(t *testing.T, h func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool), mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, mods...)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop := h(req, resp)
+	assert.False(t, stop)
+	return resp
+}
+
+func TestOption43(t *testing.T) {
+	h, err := setup4("http://acs.example.net/cwmp", "code=res", "vendor=dslforum.org")
+	require.NoError(t, err)
+
+	resp := request(t, h,
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("dslforum.org")))
+	want := append([]byte{1, 27}, "http://acs.example.net/cwmp"...)
+	want = append(want, 2, 3, 'r', 'e', 's')
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+
+	// other vendor
+	resp = request(t, h,
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("other")))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+
+	// not requested
+	resp = request(t, h, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("dslforum.org")))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+}
+
+func TestVendorClass(t *testing.T) {
+	h, err := setup4("https://acs.example.net", "vendorclass=3561:dslforum.org")
+	require.NoError(t, err)
+
+	resp := request(t, h,
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation),
+		dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 3561, Data: []byte("\x0cdslforum.org")})))
+	assert.NotNil(t, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+
+	resp = request(t, h,
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation),
+		dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 4491, Data: []byte("\x0cdslforum.org")})))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation), "other enterprise")
+
+	_, err = setup4("https://acs.example.net", "vendorclass=dslforum.org")
+	assert.Error(t, err)
+}
+
+func TestOption125(t *testing.T) {
+	h, err := setup4("https://acs.example.net", "enterprise=3561", "userclass=gateway")
+	require.NoError(t, err)
+
+	resp := request(t, h,
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorIdentifyingVendorSpecific),
+		dhcpv4.WithOption(dhcpv4.OptUserClass("gateway")))
+	want := []byte{0, 0, 0x0d, 0xe9, 25, 1, 23}
+	want = append(want, "https://acs.example.net"...)
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+
+	resp = request(t, h, dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific), "sent without user class")
+}
+
+func TestOption125Merge(t *testing.T) {
+	h1, err := setup4("https://acs.example.net", "enterprise=3561")
+	require.NoError(t, err)
+	h2, err := setup4("https://acs.example.org", "enterprise=4491")
+	require.NoError(t, err)
+	h3, err := setup4("https://acs.example.com", "enterprise=3561")
+	require.NoError(t, err)
+	chain := func(handlers ...func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)) func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			for _, h := range handlers {
+				resp, _ = h(req, resp)
+			}
+			return resp, false
+		}
+	}
+	record := func(enterprise []byte, url string) []byte {
+		r := append(enterprise, byte(2+len(url)), 1, byte(len(url)))
+		return append(r, url...)
+	}
+
+	// both enterprises are sent
+	resp := request(t, chain(h1, h2), dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+	want := append(record([]byte{0, 0, 0x0d, 0xe9}, "https://acs.example.net"), record([]byte{0, 0, 0x11, 0x8b}, "https://acs.example.org")...)
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+
+	// the last instance for an enterprise replaces the sub-options of the others
+	resp = request(t, chain(h1, h2, h3), dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+	want = append(record([]byte{0, 0, 0x11, 0x8b}, "https://acs.example.org"), record([]byte{0, 0, 0x0d, 0xe9}, "https://acs.example.com")...)
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+}
+
+func TestSetup4(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"acs.example.net"},
+		{"ftp://acs.example.net"},
+		{"http://acs.example.net", "code"},
+		{"http://acs.example.net", "enterprise=x"},
+		{"http://acs.example.net", "color=blue"},
+	} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
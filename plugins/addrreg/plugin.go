@@ -0,0 +1,252 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package addrreg lets DHCPv6 clients register the addresses they assigned
+// themselves, typically with SLAAC, as specified in RFC 9686. This is
+// experimental.
+//
+// The plugin advertises the registration mechanism to clients requesting the
+// ADDR-REG-ENABLE option, and answers their ADDR-REG-INFORM messages. Each
+// registration is logged and kept until its valid lifetime expires, giving
+// visibility into addresses that were not assigned through DHCP. A valid
+// lifetime of zero removes the registration.
+//
+// Addresses are only accepted from their owner: the request must be sent from
+// the registered address, or relayed on behalf of it. The optional
+// prefix=<prefix> arguments further restrict the addresses that can be
+// registered, and max=<count> the number of registrations kept, 65536 by
+// default. Registrations of new addresses are dropped while the limit is
+// reached. The number of registrations is reported in the status file of the
+// server, as the utilization of a pool.
+//
+// It must come after server_id, as it completes the reply to registrations and
+// stops the chain. Example usage:
+//
+//	server6:
+//	  plugins:
+//	    - server_id: LL 00:de:ad:be:ef:00
+//	    - addrreg: prefix=2001:db8:1::/64 prefix=2001:db8:2::/64
+package addrreg
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/addrreg")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "addrreg",
+	Setup6: setup6,
+}
+
+// optionAddrRegEnable is from RFC 9686, the dhcpv6 library does not define it
+const optionAddrRegEnable dhcpv6.OptionCode = 148
+
+// pruneInterval is how often expired registrations are forgotten
+const pruneInterval = time.Minute
+
+// defaultMax is the default maximum number of registrations of an instance
+const defaultMax = 1 << 16
+
+type registration struct {
+	duid    dhcpv6.DUID
+	expires time.Time
+}
+
+// registry holds the registered addresses of one plugin instance
+type registry struct {
+	prefixes []*net.IPNet
+	max      int
+
+	mu            sync.Mutex
+	registrations map[string]registration
+	lastPrune     time.Time
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	r := &registry{
+		max:           defaultMax,
+		registrations: make(map[string]registration),
+		lastPrune:     time.Now(),
+	}
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid argument %q, want key=value", arg)
+		}
+		switch k {
+		case "prefix":
+			_, prefix, err := net.ParseCIDR(v)
+			if err != nil || prefix.IP.To4() != nil {
+				return nil, fmt.Errorf("invalid IPv6 prefix %q", v)
+			}
+			r.prefixes = append(r.prefixes, prefix)
+		case "max":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid max %q, want a positive integer", v)
+			}
+			r.max = n
+		default:
+			return nil, fmt.Errorf("unknown argument %q", k)
+		}
+	}
+	log.Printf("loaded address registration for prefixes %v", r.prefixes)
+	plugins.ReportPool(r.poolStatus)
+	return r.Handler6, nil
+}
+
+// poolStatus returns the number of registrations, out of the maximum
+func (r *registry) poolStatus() plugins.PoolStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefixes := make([]string, 0, len(r.prefixes))
+	for _, prefix := range r.prefixes {
+		prefixes = append(prefixes, prefix.String())
+	}
+	if len(prefixes) == 0 {
+		prefixes = append(prefixes, "::/0")
+	}
+	return plugins.PoolStatus{
+		Plugin: "addrreg",
+		Range:  strings.Join(prefixes, ","),
+		Size:   uint64(r.max),
+		Used:   uint64(len(r.registrations)),
+	}
+}
+
+// Handler6 handles DHCPv6 packets for the addrreg plugin
+func (r *registry) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Error(err)
+		return nil, true
+	}
+	if msg.Type() != handler.MessageTypeAddrRegInform {
+		if msg.IsOptionRequested(optionAddrRegEnable) {
+			resp.AddOption(&dhcpv6.OptionGeneric{OptionCode: optionAddrRegEnable})
+		}
+		return resp, false
+	}
+
+	// RFC 9686, section 4.2.1: invalid registrations are dropped silently
+	if msg.Options.ServerID() != nil {
+		log.Debugf("dropping registration with a server ID")
+		return nil, true
+	}
+	addrs := msg.Options.Get(dhcpv6.OptionIAAddr)
+	if len(addrs) != 1 {
+		log.Debugf("dropping registration with %d addresses", len(addrs))
+		return nil, true
+	}
+	addr, ok := addrs[0].(*dhcpv6.OptIAAddress)
+	if !ok {
+		return nil, true
+	}
+	source := sourceAddr(req)
+	if source == nil || !source.Equal(addr.IPv6Addr) {
+		log.Infof("dropping registration of %s sent by %s", addr.IPv6Addr, source)
+		return nil, true
+	}
+	if !r.allowed(addr.IPv6Addr) {
+		log.Infof("dropping registration of %s outside of the configured prefixes", addr.IPv6Addr)
+		return nil, true
+	}
+
+	if md := handler.Metadata6(req); md != nil && md.DryRun {
+		resp.AddOption(addr)
+		return resp, true
+	}
+	if !r.register(addr.IPv6Addr, msg.Options.ClientID(), addr.ValidLifetime, time.Now()) {
+		return nil, true
+	}
+	resp.AddOption(addr)
+	return resp, true
+}
+
+// sourceAddr returns the address a request originates from: the peer address
+// of the relay closest to the client for relayed requests, the source of the
+// datagram otherwise. It returns nil if it is unknown.
+func sourceAddr(req dhcpv6.DHCPv6) net.IP {
+	if req.IsRelay() {
+		relay, err := dhcpv6.DecapsulateRelayIndex(req, -1)
+		if err != nil {
+			return nil
+		}
+		return relay.(*dhcpv6.RelayMessage).PeerAddr
+	}
+	if md := handler.Metadata6(req); md != nil {
+		if peer, ok := md.Peer.(*net.UDPAddr); ok {
+			return peer.IP
+		}
+	}
+	return nil
+}
+
+func (r *registry) allowed(ip net.IP) bool {
+	if len(r.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// register records the registration of ip, and returns whether it was
+// accepted. New addresses are refused while there are max registrations.
+func (r *registry) register(ip net.IP, duid dhcpv6.DUID, valid time.Duration, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastPrune) >= pruneInterval {
+		r.prune(now)
+	}
+	key := ip.String()
+	if valid == 0 {
+		if _, ok := r.registrations[key]; ok {
+			log.Infof("address %s unregistered by %s", ip, duid)
+			delete(r.registrations, key)
+		}
+		return true
+	}
+	prev, ok := r.registrations[key]
+	if !ok && len(r.registrations) >= r.max {
+		// expired registrations may have made room since the last prune
+		r.prune(now)
+		if len(r.registrations) >= r.max {
+			log.Warningf("dropping registration of %s by %s, over the limit of %d registrations", ip, duid, r.max)
+			return false
+		}
+	}
+	if ok && !prev.duid.Equal(duid) {
+		log.Warningf("address %s registered by %s, was registered by %s until %s",
+			ip, duid, prev.duid, prev.expires.Format(time.RFC3339))
+	}
+	r.registrations[key] = registration{duid: duid, expires: now.Add(valid)}
+	log.Infof("address %s registered by %s for %s", ip, duid, valid)
+	return true
+}
+
+// prune forgets the expired registrations. Must be called with mu held.
+func (r *registry) prune(now time.Time) {
+	for key, reg := range r.registrations {
+		if !now.Before(reg.expires) {
+			delete(r.registrations, key)
+		}
+	}
+	r.lastPrune = now
+}
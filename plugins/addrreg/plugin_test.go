@@ -0,0 +1,142 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package addrreg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var duid = &dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+
+func newInform(t *testing.T, addr string, valid time.Duration) *dhcpv6.Message {
+	msg, err := dhcpv6.NewMessage(dhcpv6.WithClientID(duid))
+	require.NoError(t, err)
+	msg.MessageType = handler.MessageTypeAddrRegInform
+	msg.AddOption(&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP(addr), PreferredLifetime: valid, ValidLifetime: valid})
+	return msg
+}
+
+func newReply(req *dhcpv6.Message) *dhcpv6.Message {
+	// ADDR-REG-REPLY, as made by the server
+	return &dhcpv6.Message{MessageType: handler.MessageTypeAddrRegReply, TransactionID: req.TransactionID}
+}
+
+// handle runs h on req as if received from peer
+func handle(h handler.Handler6, req dhcpv6.DHCPv6, resp dhcpv6.DHCPv6, peer string) (dhcpv6.DHCPv6, bool) {
+	handler.SetMetadata6(req, &handler.Metadata{Peer: &net.UDPAddr{IP: net.ParseIP(peer), Port: 546}})
+	defer handler.ClearMetadata6(req)
+	return h(req, resp)
+}
+
+func TestRegister(t *testing.T) {
+	h, err := setup6("prefix=2001:db8:1::/64")
+	require.NoError(t, err)
+
+	req := newInform(t, "2001:db8:1::10", time.Hour)
+	resp, stop := handle(h, req, newReply(req), "2001:db8:1::10")
+	require.True(t, stop)
+	require.NotNil(t, resp)
+	addrs := resp.(*dhcpv6.Message).Options.Get(dhcpv6.OptionIAAddr)
+	require.Len(t, addrs, 1)
+	assert.Equal(t, net.ParseIP("2001:db8:1::10"), addrs[0].(*dhcpv6.OptIAAddress).IPv6Addr)
+
+	for _, tt := range []struct {
+		name, addr, peer string
+	}{
+		{"other source", "2001:db8:1::10", "2001:db8:1::11"},
+		{"outside prefixes", "2001:db8:2::10", "2001:db8:2::10"},
+	} {
+		req := newInform(t, tt.addr, time.Hour)
+		resp, stop := handle(h, req, newReply(req), tt.peer)
+		assert.True(t, stop, tt.name)
+		assert.Nil(t, resp, tt.name)
+	}
+
+	req = newInform(t, "2001:db8:1::10", time.Hour)
+	req.AddOption(dhcpv6.OptServerID(duid))
+	resp, _ = handle(h, req, newReply(req), "2001:db8:1::10")
+	assert.Nil(t, resp, "registration with a server ID")
+}
+
+func TestRegisterRelayed(t *testing.T) {
+	h, err := setup6()
+	require.NoError(t, err)
+	inform := newInform(t, "2001:db8:1::10", time.Hour)
+	relay, err := dhcpv6.EncapsulateRelay(inform, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("2001:db8:1::10"))
+	require.NoError(t, err)
+	resp, stop := handle(h, relay, newReply(inform), "2001:db8:ffff::1")
+	assert.True(t, stop)
+	assert.NotNil(t, resp, "source is the peer address of the relay")
+
+	relay.PeerAddr = net.ParseIP("fe80::10")
+	resp, _ = handle(h, relay, newReply(inform), "2001:db8:ffff::1")
+	assert.Nil(t, resp)
+}
+
+func TestRegistrations(t *testing.T) {
+	r := &registry{max: 2, registrations: make(map[string]registration)}
+	now := time.Now()
+	other := &dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 2}}
+	ip := net.ParseIP("2001:db8::10")
+
+	r.register(ip, duid, time.Hour, now)
+	require.Contains(t, r.registrations, ip.String())
+	assert.Equal(t, now.Add(time.Hour), r.registrations[ip.String()].expires)
+	r.register(ip, other, time.Hour, now)
+	assert.True(t, r.registrations[ip.String()].duid.Equal(other), "registration taken over")
+	r.register(ip, other, 0, now)
+	assert.NotContains(t, r.registrations, ip.String(), "zero lifetime")
+
+	r.register(ip, duid, time.Minute, now)
+	r.register(net.ParseIP("2001:db8::11"), duid, time.Hour, now.Add(2*pruneInterval))
+	assert.NotContains(t, r.registrations, ip.String(), "expired")
+	assert.Len(t, r.registrations, 1)
+
+	later := now.Add(2 * pruneInterval)
+	assert.True(t, r.register(net.ParseIP("2001:db8::12"), duid, time.Minute, later))
+	assert.False(t, r.register(net.ParseIP("2001:db8::13"), duid, time.Hour, later), "over the limit")
+	assert.True(t, r.register(net.ParseIP("2001:db8::11"), duid, time.Hour, later), "renewal at the limit")
+	assert.True(t, r.register(net.ParseIP("2001:db8::13"), duid, time.Hour, later.Add(time.Minute)), "after an expiry")
+	assert.Equal(t, plugins.PoolStatus{Plugin: "addrreg", Range: "::/0", Size: 2, Used: 2}, r.poolStatus())
+}
+
+func TestAddrRegEnable(t *testing.T) {
+	h, err := setup6()
+	require.NoError(t, err)
+	for _, requested := range []bool{false, true} {
+		var mods []dhcpv6.Modifier
+		if requested {
+			mods = append(mods, dhcpv6.WithRequestedOptions(optionAddrRegEnable))
+		}
+		req, err := dhcpv6.NewSolicit(duid.LinkLayerAddr, mods...)
+		require.NoError(t, err)
+		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, err)
+		result, stop := h(req, resp)
+		require.False(t, stop)
+		assert.Equal(t, requested, result.GetOneOption(optionAddrRegEnable) != nil)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	for _, args := range [][]string{
+		{"prefix=192.0.2.0/24"},
+		{"prefix=2001:db8::"},
+		{"2001:db8::/64"},
+		{"lifetime=1h"},
+		{"max=0"},
+	} {
+		_, err := setup6(args...)
+		assert.Error(t, err, args)
+	}
+}
@@ -32,8 +32,6 @@ import (
 
 var log = logger.GetLogger("plugins/autoconfigure")
 
-var autoconfigure dhcpv4.AutoConfiguration
-
 var Plugin = plugins.Plugin{
 	Name:   "autoconfigure",
 	Setup4: setup4,
@@ -46,32 +44,39 @@ var argMap = map[string]dhcpv4.AutoConfiguration{
 	"AutoConfigure":      dhcpv4.AutoConfigure,
 }
 
+// autoconfigure is the value answered by an instance of the plugin to clients
+// that support auto-configuration
+type autoconfigure dhcpv4.AutoConfiguration
+
 func setup4(args ...string) (handler.Handler4, error) {
+	var value autoconfigure
 	if len(args) > 0 {
-		var ok bool
-		autoconfigure, ok = argMap[args[0]]
+		ac, ok := argMap[args[0]]
 		if !ok {
 			return nil, fmt.Errorf("unexpected value '%v' for autoconfigure argument", args[0])
 		}
+		value = autoconfigure(ac)
 	}
 	if len(args) > 1 {
 		return nil, errors.New("too many arguments")
 	}
-	return Handler4, nil
+	last.Set4(value.Handler4)
+	return value.Handler4, nil
 }
 
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+// Handler4 handles DHCPv4 packets for the autoconfigure plugin
+func (value autoconfigure) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if resp.MessageType() != dhcpv4.MessageTypeOffer || !resp.YourIPAddr.IsUnspecified() {
 		return resp, false
 	}
 
 	ac, ok := req.AutoConfigure()
 	if ok {
-		resp.UpdateOption(dhcpv4.OptAutoConfigure(autoconfigure))
+		resp.UpdateOption(dhcpv4.OptAutoConfigure(dhcpv4.AutoConfiguration(value)))
 		log.WithFields(logrus.Fields{
 			"mac":           req.ClientHWAddr.String(),
 			"autoconfigure": fmt.Sprintf("%v", ac),
-		}).Debugf("Responded with autoconfigure %v", autoconfigure)
+		}).Debugf("Responded with autoconfigure %v", dhcpv4.AutoConfiguration(value))
 		return resp, false
 	}
 
@@ -84,3 +89,11 @@ func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	// it is not answered.
 	return nil, true
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
@@ -25,7 +25,7 @@ func TestOptionRequested0(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := autoconfigure(0).Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -54,8 +54,7 @@ func TestOptionRequested1(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	autoconfigure = 1
-	resp, stop := Handler4(req, stub)
+	resp, stop := autoconfigure(1).Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -84,7 +83,7 @@ func TestNotRequestedAssignedIP(t *testing.T) {
 	}
 	stub.YourIPAddr = net.ParseIP("192.0.2.100")
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := autoconfigure(0).Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -108,7 +107,7 @@ func TestNotRequestedNoIP(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := autoconfigure(0).Handler4(req, stub)
 	if resp != nil {
 		t.Error("plugin returned a message")
 	}
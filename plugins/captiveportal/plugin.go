@@ -0,0 +1,159 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package captiveportal advertises the URI of a captive portal API to clients,
+// as described in RFC 8910.
+//
+// For DHCPv6 the URI is sent in option 103. For DHCPv4, RFC 8910 moved the
+// option from code 160, which RFC 7710 had assigned but was already in use by
+// some vendors, to code 114. The code(s) to send can be chosen with an
+// optional codes=<codes> argument:
+// - codes=114 (default): the standard code
+// - codes=160: the deprecated code, for fleets of legacy clients only
+// - codes=114,160: both codes, to every client
+// - codes=auto: the code(s) requested by the client, 114 if it requests none
+//
+// Clients requesting the deprecated code are logged at debug level, to help
+// tracking down the clients left before dropping it.
+//
+// Example usage:
+//
+//	server6:
+//	  - plugins:
+//	    - captiveportal: https://portal.example.net/api
+//
+//	server4:
+//	  - plugins:
+//	    - captiveportal: https://portal.example.net/api codes=auto
+package captiveportal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/captiveportal")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "captiveportal",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// optionCaptivePortalLegacy is the DHCPv4 captive portal option code assigned
+// by RFC 7710, deprecated by RFC 8910
+var optionCaptivePortalLegacy = dhcpv4.GenericOptionCode(160)
+
+func parseURI(arg string) (string, error) {
+	u, err := url.Parse(arg)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("invalid captive portal URI %q", arg)
+	}
+	if u.Scheme != "https" {
+		// RFC 8910 section 2
+		log.Warningf("captive portal URI %s is not an https URI, clients may ignore it", arg)
+	}
+	return u.String(), nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("exactly one argument must be passed to the captiveportal plugin for DHCPv6, got %d", len(args))
+	}
+	uri, err := parseURI(args[0])
+	if err != nil {
+		return nil, err
+	}
+	opt := &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionCaptivePortal,
+		OptionData: []byte(uri),
+	}
+	log.Printf("loaded captive portal URI %s for DHCPv6", uri)
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		resp.UpdateOption(opt)
+		return resp, false
+	}, nil
+}
+
+// policy4 holds the DHCPv4 option codes to send
+type policy4 struct {
+	standard, legacy bool
+	// auto sends the codes requested by the client instead
+	auto bool
+}
+
+func parseCodes(value string) (policy4, error) {
+	var p policy4
+	if value == "auto" {
+		p.auto = true
+		return p, nil
+	}
+	for _, code := range strings.Split(value, ",") {
+		switch code {
+		case "114":
+			p.standard = true
+		case "160":
+			p.legacy = true
+		default:
+			return p, fmt.Errorf("invalid captive portal option code %q, want 114, 160 or auto", code)
+		}
+	}
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("want a captive portal URI and optionally codes=<codes>, got %d arguments", len(args))
+	}
+	uri, err := parseURI(args[0])
+	if err != nil {
+		return nil, err
+	}
+	p := policy4{standard: true}
+	if len(args) == 2 {
+		value, ok := strings.CutPrefix(args[1], "codes=")
+		if !ok {
+			return nil, fmt.Errorf("unknown argument %q", args[1])
+		}
+		if p, err = parseCodes(value); err != nil {
+			return nil, err
+		}
+	}
+	if len(uri) > 255 {
+		return nil, errors.New("captive portal URI is longer than 255 bytes")
+	}
+	log.Printf("loaded captive portal URI %s for DHCPv4", uri)
+	return p.handler4([]byte(uri)), nil
+}
+
+func (p policy4) handler4(uri []byte) handler.Handler4 {
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		standard, legacy := p.standard, p.legacy
+		if req.IsOptionRequested(optionCaptivePortalLegacy) {
+			log.Debugf("client %s requested the deprecated captive portal option 160", req.ClientHWAddr)
+			if p.auto {
+				legacy = true
+			}
+		}
+		if p.auto {
+			standard = req.IsOptionRequested(dhcpv4.OptionURL) || !legacy
+		}
+		if standard {
+			resp.Options.Update(dhcpv4.OptGeneric(dhcpv4.OptionURL, uri))
+		}
+		if legacy {
+			resp.Options.Update(dhcpv4.OptGeneric(optionCaptivePortalLegacy, uri))
+		}
+		return resp, false
+	}
+}
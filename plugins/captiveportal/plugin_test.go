@@ -0,0 +1,85 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package captiveportal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const uri = "https://portal.example.net/api"
+
+func TestSetup4Codes(t *testing.T) {
+	for _, tt := range []struct {
+		codes            string
+		requested        []dhcpv4.OptionCode
+		standard, legacy bool
+	}{
+		{codes: "", standard: true},
+		{codes: "codes=114", requested: []dhcpv4.OptionCode{optionCaptivePortalLegacy}, standard: true},
+		{codes: "codes=160", legacy: true},
+		{codes: "codes=114,160", standard: true, legacy: true},
+		{codes: "codes=auto", standard: true},
+		{codes: "codes=auto", requested: []dhcpv4.OptionCode{optionCaptivePortalLegacy}, legacy: true},
+		{codes: "codes=auto", requested: []dhcpv4.OptionCode{dhcpv4.OptionURL, optionCaptivePortalLegacy}, standard: true, legacy: true},
+	} {
+		args := []string{uri}
+		if tt.codes != "" {
+			args = append(args, tt.codes)
+		}
+		h, err := setup4(args...)
+		require.NoError(t, err)
+
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+			dhcpv4.WithRequestedOptions(tt.requested...))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, stop := h(req, resp)
+		require.False(t, stop)
+
+		assert.Equal(t, tt.standard, resp.Options.Has(dhcpv4.OptionURL), "option 114 with %q requesting %v", tt.codes, tt.requested)
+		assert.Equal(t, tt.legacy, resp.Options.Has(optionCaptivePortalLegacy), "option 160 with %q requesting %v", tt.codes, tt.requested)
+		if tt.standard {
+			assert.Equal(t, []byte(uri), resp.Options.Get(dhcpv4.OptionURL))
+		}
+	}
+}
+
+func TestSetup4Errors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"portal"},
+		{uri, "codes=42"},
+		{uri, "codes="},
+		{uri, "code=114"},
+		{uri, "codes=114", "codes=160"},
+	} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestSetup6(t *testing.T) {
+	h, err := setup6(uri)
+	require.NoError(t, err)
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	result, stop := h(req, resp)
+	require.False(t, stop)
+	opt := result.GetOneOption(dhcpv6.OptionCaptivePortal)
+	require.NotNil(t, opt)
+	assert.Equal(t, []byte(uri), opt.ToBytes())
+
+	_, err = setup6(uri, "codes=114")
+	assert.Error(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"net"
 
+	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
@@ -19,49 +20,72 @@ var log = logger.GetLogger("plugins/dns")
 
 // Plugin wraps the DNS plugin information.
 var Plugin = plugins.Plugin{
-	Name:   "dns",
-	Setup6: setup6,
-	Setup4: setup4,
+	Name:              "dns",
+	Setup6WithOptions: setup6,
+	Setup4WithOptions: setup4,
+	MinCoreAPI:        2,
 }
 
+// dnsServers are the servers sent by an instance of the plugin
+type dnsServers []net.IP
+
+// merged6 and merged4 collect the servers of the instances set up without a
+// pool, which are sent together
 var (
-	dnsServers6 []net.IP
-	dnsServers4 []net.IP
+	merged6 plugins.Merged[net.IP]
+	merged4 plugins.Merged[net.IP]
 )
 
-func setup6(args ...string) (handler.Handler6, error) {
+func setup6(cfg config.PluginConfig, deps plugins.Deps) (handler.Handler6, error) {
+	args := cfg.Args
 	if len(args) < 1 {
 		return nil, errors.New("need at least one DNS server")
 	}
+	var servers dnsServers
 	for _, arg := range args {
 		server := net.ParseIP(arg)
 		if server.To16() == nil {
-			return Handler6, errors.New("expected an DNS server address, got: " + arg)
+			return nil, errors.New("expected an DNS server address, got: " + arg)
 		}
-		dnsServers6 = append(dnsServers6, server)
+		servers = append(servers, server)
+	}
+	log.Infof("loaded %d DNS servers.", len(servers))
+	list := merged6.Add(deps.Config, servers...)
+	h := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		return dnsServers(*list).Handler6(req, resp)
 	}
-	log.Infof("loaded %d DNS servers.", len(dnsServers6))
-	return Handler6, nil
+	last.Set6(h)
+	return h, nil
 }
 
-func setup4(args ...string) (handler.Handler4, error) {
+func setup4(cfg config.PluginConfig, deps plugins.Deps) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
+	pool, args := plugins.PoolArg(cfg.Args)
 	if len(args) < 1 {
 		return nil, errors.New("need at least one DNS server")
 	}
+	var servers dnsServers
 	for _, arg := range args {
 		DNSServer := net.ParseIP(arg)
 		if DNSServer.To4() == nil {
-			return Handler4, errors.New("expected an DNS server address, got: " + arg)
+			return nil, errors.New("expected an DNS server address, got: " + arg)
 		}
-		dnsServers4 = append(dnsServers4, DNSServer)
+		servers = append(servers, DNSServer)
+	}
+	log.Infof("loaded %d DNS servers.", len(servers))
+	if pool != "" {
+		return handler.ForPool4(pool, servers.Handler4), nil
+	}
+	list := merged4.Add(deps.Config, servers...)
+	h := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		return dnsServers(*list).Handler4(req, resp)
 	}
-	log.Infof("loaded %d DNS servers.", len(dnsServers4))
-	return Handler4, nil
+	last.Set4(h)
+	return h, nil
 }
 
 // Handler6 handles DHCPv6 packets for the dns plugin
-func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (servers dnsServers) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	decap, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
@@ -69,15 +93,28 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	}
 
 	if decap.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(dnsServers6...))
+		resp.UpdateOption(dhcpv6.OptDNS(servers...))
 	}
 	return resp, false
 }
 
 //Handler4 handles DHCPv4 packets for the dns plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (servers dnsServers) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
-		resp.Options.Update(dhcpv4.OptDNS(dnsServers4...))
+		resp.Options.Update(dhcpv4.OptDNS(servers...))
 	}
 	return resp, false
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup6.
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	return last.Handler6(req, resp)
+}
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
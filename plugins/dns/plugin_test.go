@@ -8,6 +8,8 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -26,12 +28,12 @@ func TestAddServer6(t *testing.T) {
 	}
 	stub.MessageType = dhcpv6.MessageTypeReply
 
-	dnsServers6 = []net.IP{
+	dnsServers6 := dnsServers{
 		net.ParseIP("2001:db8::1"),
 		net.ParseIP("2001:db8::3"),
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := dnsServers6.Handler6(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -69,11 +71,11 @@ func TestNotRequested6(t *testing.T) {
 	}
 	stub.MessageType = dhcpv6.MessageTypeReply
 
-	dnsServers6 = []net.IP{
+	dnsServers6 := dnsServers{
 		net.ParseIP("2001:db8::1"),
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := dnsServers6.Handler6(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -97,12 +99,12 @@ func TestAddServer4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
+	dnsServers4 := dnsServers{
 		net.ParseIP("192.0.2.1"),
 		net.ParseIP("192.0.2.3"),
 	}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := dnsServers4.Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -130,12 +132,12 @@ func TestNotRequested4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
+	dnsServers4 := dnsServers{
 		net.ParseIP("192.0.2.1"),
 	}
 	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionBroadcastAddress))
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := dnsServers4.Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -147,3 +149,69 @@ func TestNotRequested4(t *testing.T) {
 		t.Errorf("Found %d DNS servers when explicitly not requested", len(servers))
 	}
 }
+
+func TestPackageHandler4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := plugins.Deps{Config: &config.Config{}}
+	for _, args := range [][]string{{"192.0.2.1"}, {"192.0.2.2"}, {"pool=guests", "192.0.2.3"}} {
+		if _, err := setup4(config.PluginConfig{Args: args}, deps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := Handler4(req, stub)
+	if servers := resp.DNS(); len(servers) != 2 || !servers[1].Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Errorf("got servers %v, want those of the instances without a pool", servers)
+	}
+}
+
+func TestMergeInstances4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := func(h func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)) []net.IP {
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := h(req, stub)
+		return resp.DNS()
+	}
+
+	deps := plugins.Deps{Config: &config.Config{}}
+	first, err := setup4(config.PluginConfig{Args: []string{"192.0.2.1"}}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := setup4(config.PluginConfig{Args: []string{"192.0.2.2", "192.0.2.3"}}, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range []func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool){first, second} {
+		if got := servers(h); len(got) != 3 || !got[0].Equal(net.IPv4(192, 0, 2, 1)) || !got[2].Equal(net.IPv4(192, 0, 2, 3)) {
+			t.Errorf("instance %d sent servers %v, want the servers of both instances in order", i, got)
+		}
+	}
+
+	// a reload starts a new list, and the instances of the previous
+	// configuration keep theirs
+	reloaded, err := setup4(config.PluginConfig{Args: []string{"192.0.2.4"}}, plugins.Deps{Config: &config.Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servers(reloaded); len(got) != 1 || !got[0].Equal(net.IPv4(192, 0, 2, 4)) {
+		t.Errorf("reloaded instance sent servers %v, want only its own", got)
+	}
+	if got := servers(first); len(got) != 3 {
+		t.Errorf("previous instance sent servers %v after a reload, want its 3 servers", got)
+	}
+}
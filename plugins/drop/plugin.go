@@ -0,0 +1,62 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package drop ignores every request reaching it, which stops the chain
+// without answering. Placed after the plugins serving known clients, it makes
+// refusing the others explicit in the configuration, rather than relying on
+// them not being found. It takes no arguments:
+//
+//	server4:
+//	  plugins:
+//	    - server_id: 10.10.10.1
+//	    - file: "leases4.txt"
+//	    - drop:
+package drop
+
+import (
+	"errors"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/drop")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "drop",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) > 0 {
+		return nil, errors.New("drop takes no arguments")
+	}
+	return Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) > 0 {
+		return nil, errors.New("drop takes no arguments")
+	}
+	return Handler4, nil
+}
+
+// Handler6 drops every DHCPv6 request
+func Handler6(req, _ dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if msg, err := req.GetInnerMessage(); err == nil {
+		log.Debugf("dropping %s from %s", msg.Type(), msg.Options.ClientID())
+	}
+	return nil, true
+}
+
+// Handler4 drops every DHCPv4 request
+func Handler4(req, _ *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	log.Debugf("dropping %s from %s", req.MessageType(), req.ClientHWAddr)
+	return nil, true
+}
@@ -0,0 +1,40 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package drop
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrop(t *testing.T) {
+	h4, err := setup4()
+	require.NoError(t, err)
+	req4, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp4, err := dhcpv4.NewReplyFromRequest(req4)
+	require.NoError(t, err)
+	resp4, stop := h4(req4, resp4)
+	assert.Nil(t, resp4)
+	assert.True(t, stop)
+
+	h6, err := setup6()
+	require.NoError(t, err)
+	req6, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp6, err := dhcpv6.NewAdvertiseFromSolicit(req6)
+	require.NoError(t, err)
+	result, stop := h6(req6, resp6)
+	assert.Nil(t, result)
+	assert.True(t, stop)
+
+	_, err = setup4("now")
+	assert.Error(t, err)
+}
@@ -10,6 +10,7 @@ package example
 // Feedback is welcome!
 
 import (
+	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
@@ -37,7 +38,8 @@ var log = logger.GetLogger("plugins/example")
 // var Plugin = plugins.Plugin{
 //     Name: "example",
 //     Setup6: setup6,
-//     Setup4: setup4,
+//     Setup4WithOptions: setup4,
+//     MinCoreAPI: 2,
 // }
 //
 // Name is simply the name used to register the plugin. It must be unique to
@@ -48,6 +50,10 @@ var log = logger.GetLogger("plugins/example")
 // handlers. They conform to the `plugins.SetupFunc6` and `plugins.SetupFunc4`
 // interfaces, so they must return a `plugins.Handler6` and a `plugins.Handler4`
 // respectively.
+// Setup6WithOptions and Setup4WithOptions can be used instead. They also get
+// the whole plugin entry of the configuration and the services of the core,
+// like a logger, in a `plugins.Deps`. Plugins using them set MinCoreAPI to 2,
+// the version of the core that introduced them.
 // A `nil` setup function means that that protocol won't be handled by this
 // plugin.
 //
@@ -62,9 +68,10 @@ var log = logger.GetLogger("plugins/example")
 //   - file: "leases.txt"
 //
 var Plugin = plugins.Plugin{
-	Name:   "example",
-	Setup6: setup6,
-	Setup4: setup4,
+	Name:              "example",
+	Setup6:            setup6,
+	Setup4WithOptions: setup4,
+	MinCoreAPI:        2,
 }
 
 // setup6 is the setup function to initialize the handler for DHCPv6
@@ -79,10 +86,11 @@ func setup6(args ...string) (handler.Handler6, error) {
 	return exampleHandler6, nil
 }
 
-// setup4 behaves like setupExample6, but for DHCPv4 packets. It
-// implements the `plugin.SetupFunc4` interface.
-func setup4(args ...string) (handler.Handler4, error) {
-	log.Printf("loaded plugin for DHCPv4.")
+// setup4 behaves like setup6, but for DHCPv4 packets. It implements the
+// `plugin.SetupFunc4WithOptions` interface: cfg is the plugin entry of the
+// configuration, with its arguments, and deps the services of the core.
+func setup4(cfg config.PluginConfig, deps plugins.Deps) (handler.Handler4, error) {
+	deps.Log.Printf("loaded plugin for DHCPv4 with args %v.", cfg.Args)
 	return exampleHandler4, nil
 }
 
@@ -111,6 +119,11 @@ func exampleHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 // implements the `handler.Handler4` interface.
 func exampleHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	log.Printf("received DHCPv4 packet: %s", req.Summary())
+	// the server attaches information that is not part of the packet itself,
+	// like the interface it was received on, as metadata.
+	if md := handler.Metadata4(req); md != nil {
+		log.Printf("received on interface %q", md.IfName)
+	}
 	// return the unmodified response, and false. This means that the next
 	// plugin in the chain will be called, and the unmodified response packet
 	// will be used as its input.
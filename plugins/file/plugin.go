@@ -23,8 +23,25 @@
 //
 // If the file path is not absolute, it is relative to the cwd where coredhcp is run.
 //
+// For DHCPv6, the address can be followed by its lifetimes, as valid=<duration>
+// and preferred=<duration>, or by "deprecated" for a preferred lifetime of 0
+// to phase the address out. Both lifetimes default to 3600s:
+//
+//	00:11:22:33:44:55 2001:db8::1 valid=24h preferred=12h
+//	01:23:45:67:89:01 2001:db8::2 deprecated
+//
 // Optionally, when the 'autorefresh' argument is given, the plugin will try to refresh
 // the lease mapping during runtime whenever the lease file is updated.
+//
+// With autorefresh, a 'grace=<duration>' argument keeps serving the records
+// removed from the file for that long, so that a mistaken edit does not
+// immediately move clients to another plugin, such as a dynamic range. Adding
+// a removed record back restores it. Removed records whose address has been
+// given to another MAC address in the file are not served anymore.
+//
+// The DHCPv4 and DHCPv6 records of a file are kept separately, and instances
+// of the plugin configured with the same file share them, so it is only loaded
+// and watched once.
 package file
 
 import (
@@ -37,16 +54,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/coredhcp/coredhcp/filewatch"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
-	"github.com/fsnotify/fsnotify"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
 const (
 	autoRefreshArg = "autorefresh"
+	graceArg       = "grace"
 )
 
 var log = logger.GetLogger("plugins/file")
@@ -58,19 +76,51 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var recLock sync.RWMutex
+// leaseFile holds the records loaded from a lease file. The plugin
+// instances configured with the same file share it, see plugins.Instances.
+type leaseFile struct {
+	filename string
+	lock     sync.RWMutex
+	// records4 and records6 map MAC addresses to the DHCPv4 and DHCPv6
+	// records, and are nil until the file is used for that protocol
+	records4 map[string]net.IP
+	records6 map[string]Record6
+	watch    *filewatch.Watch
+	// grace is how long records removed from the file are kept in deleted4
+	// and deleted6, by MAC address
+	grace    time.Duration
+	deleted4 map[string]softDeleted[net.IP]
+	deleted6 map[string]softDeleted[Record6]
+}
+
+// softDeleted is a record removed from the lease file, which is still served
+// until the grace period expires
+type softDeleted[T any] struct {
+	record T
+	until  time.Time
+}
 
-// StaticRecords holds a MAC -> IP address mapping
+var instances plugins.Instances[*leaseFile]
+
+// StaticRecords holds the records of the lease file loaded last, for either
+// protocol.
+//
+// Deprecated: records are kept per lease file and protocol, and this map is
+// only set for compatibility. It is replaced, not modified, when a file is
+// loaded.
 var StaticRecords map[string]net.IP
 
-// DHCPv6Records and DHCPv4Records are mappings between MAC addresses in
-// form of a string, to network configurations.
-var (
-	DHCPv6Records map[string]net.IP
-	DHCPv4Records map[string]net.IP
-)
+// staticRecordsLock serializes the files loaded in parallel setting
+// StaticRecords
+var staticRecordsLock sync.Mutex
+
+func setStaticRecords(records map[string]net.IP) {
+	staticRecordsLock.Lock()
+	StaticRecords = records
+	staticRecordsLock.Unlock()
+}
 
-// LoadDHCPv4Records loads the DHCPv4Records global map with records stored on
+// LoadDHCPv4Records returns the DHCPv4 records stored in
 // the specified file. The records have to be one per line, a mac address and an
 // IPv4 address.
 func LoadDHCPv4Records(filename string) (map[string]net.IP, error) {
@@ -106,16 +156,47 @@ func LoadDHCPv4Records(filename string) (map[string]net.IP, error) {
 	return records, nil
 }
 
-// LoadDHCPv6Records loads the DHCPv6Records global map with records stored on
+// defaultLifetime is the preferred and valid lifetime of DHCPv6 records that
+// don't set them
+const defaultLifetime = 3600 * time.Second
+
+// Record6 is a DHCPv6 record of a lease file
+type Record6 struct {
+	IP                net.IP
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+}
+
+// LoadDHCPv6Records returns the DHCPv6 records stored in
 // the specified file. The records have to be one per line, a mac address and an
-// IPv6 address.
+// IPv6 address, see LoadDHCPv6Leases for the optional lifetimes.
 func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
+	leases, err := LoadDHCPv6Leases(filename)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]net.IP, len(leases))
+	for mac, lease := range leases {
+		records[mac] = lease.IP
+	}
+	return records, nil
+}
+
+// LoadDHCPv6Leases returns the DHCPv6 records stored in the specified file.
+// The records have to be one per line, a mac address and an IPv6 address,
+// optionally followed by:
+// - valid=<duration> and preferred=<duration> to override the default
+// lifetimes of 3600s. The preferred lifetime defaults to the valid one.
+// - deprecated to give a preferred lifetime of 0, so that the client stops
+// using the address for new connections while keeping the existing ones,
+// before the record is removed
+func LoadDHCPv6Leases(filename string) (map[string]Record6, error) {
 	log.Infof("reading leases from %s", filename)
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	records := make(map[string]net.IP)
+	records := make(map[string]Record6)
 	for _, lineBytes := range bytes.Split(data, []byte{'\n'}) {
 		line := string(lineBytes)
 		if len(line) == 0 {
@@ -125,8 +206,8 @@ func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
 			continue
 		}
 		tokens := strings.Fields(line)
-		if len(tokens) != 2 {
-			return nil, fmt.Errorf("malformed line, want 2 fields, got %d: %s", len(tokens), line)
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("malformed line, want at least 2 fields, got %d: %s", len(tokens), line)
 		}
 		hwaddr, err := net.ParseMAC(tokens[0])
 		if err != nil {
@@ -136,13 +217,64 @@ func LoadDHCPv6Records(filename string) (map[string]net.IP, error) {
 		if ipaddr.To16() == nil || ipaddr.To4() != nil {
 			return nil, fmt.Errorf("expected an IPv6 address, got: %v", ipaddr)
 		}
-		records[hwaddr.String()] = ipaddr
+		record, err := parseLifetimes(tokens[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, line)
+		}
+		record.IP = ipaddr
+		records[hwaddr.String()] = record
 	}
 	return records, nil
 }
 
+// parseLifetimes parses the optional fields of a DHCPv6 record
+func parseLifetimes(fields []string) (Record6, error) {
+	var (
+		r          = Record6{ValidLifetime: defaultLifetime}
+		preferred  = time.Duration(-1)
+		deprecated bool
+	)
+	for _, field := range fields {
+		if field == "deprecated" {
+			deprecated = true
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return r, fmt.Errorf("unknown field %q", field)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return r, fmt.Errorf("invalid %s lifetime %q", key, value)
+		}
+		switch key {
+		case "valid":
+			if d == 0 {
+				return r, errors.New("valid lifetime cannot be 0")
+			}
+			r.ValidLifetime = d
+		case "preferred":
+			preferred = d
+		default:
+			return r, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	switch {
+	case deprecated:
+		r.PreferredLifetime = 0
+	case preferred >= 0:
+		r.PreferredLifetime = preferred
+	default:
+		r.PreferredLifetime = r.ValidLifetime
+	}
+	if r.PreferredLifetime > r.ValidLifetime {
+		return r, errors.New("preferred lifetime longer than the valid lifetime")
+	}
+	return r, nil
+}
+
 // Handler6 handles DHCPv6 packets for the file plugin
-func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (l *leaseFile) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	m, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("BUG: could not decapsulate: %v", err)
@@ -161,23 +293,26 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	}
 	log.Debugf("looking up an IP address for MAC %s", mac.String())
 
-	recLock.RLock()
-	defer recLock.RUnlock()
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 
-	ipaddr, ok := StaticRecords[mac.String()]
+	record, ok := l.records6[mac.String()]
+	if !ok {
+		record, ok = lookupDeleted(l.deleted6, mac.String(), time.Now())
+	}
 	if !ok {
 		log.Warningf("MAC address %s is unknown", mac.String())
 		return resp, false
 	}
-	log.Debugf("found IP address %s for MAC %s", ipaddr, mac.String())
+	log.Debugf("found IP address %s for MAC %s", record.IP, mac.String())
 
 	resp.AddOption(&dhcpv6.OptIANA{
 		IaId: m.Options.OneIANA().IaId,
 		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
 			&dhcpv6.OptIAAddress{
-				IPv6Addr:          ipaddr,
-				PreferredLifetime: 3600 * time.Second,
-				ValidLifetime:     3600 * time.Second,
+				IPv6Addr:          record.IP,
+				PreferredLifetime: record.PreferredLifetime,
+				ValidLifetime:     record.ValidLifetime,
 			},
 		}},
 	})
@@ -185,11 +320,14 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 }
 
 // Handler4 handles DHCPv4 packets for the file plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	recLock.RLock()
-	defer recLock.RUnlock()
+func (l *leaseFile) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 
-	ipaddr, ok := StaticRecords[req.ClientHWAddr.String()]
+	ipaddr, ok := l.records4[req.ClientHWAddr.String()]
+	if !ok {
+		ipaddr, ok = lookupDeleted(l.deleted4, req.ClientHWAddr.String(), time.Now())
+	}
 	if !ok {
 		log.Warningf("MAC address %s is unknown", req.ClientHWAddr.String())
 		return resp, false
@@ -199,84 +337,209 @@ func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	return resp, true
 }
 
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup6.
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	return last.Handler6(req, resp)
+}
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
+
 func setup6(args ...string) (handler.Handler6, error) {
-	h6, _, err := setupFile(true, args...)
-	return h6, err
+	l, err := setupFile(true, args...)
+	if err != nil {
+		return nil, err
+	}
+	last.Set6(l.Handler6)
+	return l.Handler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	_, h4, err := setupFile(false, args...)
-	return h4, err
+	l, err := setupFile(false, args...)
+	if err != nil {
+		return nil, err
+	}
+	last.Set4(l.Handler4)
+	return l.Handler4, nil
 }
 
-func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, error) {
-	var err error
+// setupFile returns the shared instance for the lease file, with its records
+// for the protocol loaded
+func setupFile(v6 bool, args ...string) (*leaseFile, error) {
 	if len(args) < 1 {
-		return nil, nil, errors.New("need a file name")
+		return nil, errors.New("need a file name")
 	}
 	filename := args[0]
 	if filename == "" {
-		return nil, nil, errors.New("got empty file name")
+		return nil, errors.New("got empty file name")
+	}
+	var (
+		autoRefresh bool
+		grace       time.Duration
+	)
+	for _, arg := range args[1:] {
+		if arg == autoRefreshArg {
+			autoRefresh = true
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key != graceArg {
+			return nil, fmt.Errorf("unknown argument %q", arg)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid grace period %q", value)
+		}
+		grace = d
+	}
+	if grace != 0 && !autoRefresh {
+		return nil, errors.New("grace needs autorefresh, records are never removed otherwise")
+	}
+	l, err := instances.Get(filename, func() (*leaseFile, error) {
+		return &leaseFile{filename: filename, grace: grace}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l.grace != grace {
+		return nil, fmt.Errorf("%s is already used with a grace period of %s", filename, l.grace)
 	}
 
-	// load initial database from lease file
-	if err = loadFromFile(v6, filename); err != nil {
-		return nil, nil, err
+	l.lock.RLock()
+	loaded := (v6 && l.records6 != nil) || (!v6 && l.records4 != nil)
+	l.lock.RUnlock()
+	if !loaded {
+		// load initial database from lease file
+		if err := l.load(v6); err != nil {
+			return nil, err
+		}
+		log.Infof("loaded %d leases from %s", l.count(v6), filename)
 	}
 
 	// when the 'autorefresh' argument was passed, watch the lease file for
 	// changes and reload the lease mapping on any event
-	if len(args) > 1 && args[1] == autoRefreshArg {
-		// creates a new file watcher
-		watcher, err := fsnotify.NewWatcher()
+	if autoRefresh && l.watch == nil {
+		l.watch, err = filewatch.Add(filename, l.reload)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create watcher: %w", err)
+			return nil, err
 		}
+	}
+	return l, nil
+}
 
-		// have file watcher watch over lease file
-		if err = watcher.Add(filename); err != nil {
-			return nil, nil, fmt.Errorf("failed to watch %s: %w", filename, err)
+// reload loads the records of the file again, for the protocols it is used for
+func (l *leaseFile) reload() {
+	l.lock.RLock()
+	protocols := make([]bool, 0, 2)
+	if l.records4 != nil {
+		protocols = append(protocols, false)
+	}
+	if l.records6 != nil {
+		protocols = append(protocols, true)
+	}
+	l.lock.RUnlock()
+	for _, v6 := range protocols {
+		if err := l.load(v6); err != nil {
+			log.Warningf("failed to refresh from %s: %s", l.filename, err)
+			continue
 		}
-
-		// very simple watcher on the lease file to trigger a refresh on any event
-		// on the file
-		go func() {
-			for range watcher.Events {
-				err := loadFromFile(v6, filename)
-				if err != nil {
-					log.Warningf("failed to refresh from %s: %s", filename, err)
-
-					continue
-				}
-
-				log.Infof("updated to %d leases from %s", len(StaticRecords), filename)
-			}
-		}()
+		log.Infof("updated to %d leases from %s", l.count(v6), l.filename)
 	}
+}
 
-	log.Infof("loaded %d leases from %s", len(StaticRecords), filename)
-	return Handler6, Handler4, nil
+func (l *leaseFile) count(v6 bool) int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if v6 {
+		return len(l.records6)
+	}
+	return len(l.records4)
 }
 
-func loadFromFile(v6 bool, filename string) error {
-	var err error
-	var records map[string]net.IP
-	var protver int
+func (l *leaseFile) load(v6 bool) error {
 	if v6 {
-		protver = 6
-		records, err = LoadDHCPv6Records(filename)
-	} else {
-		protver = 4
-		records, err = LoadDHCPv4Records(filename)
+		records, err := LoadDHCPv6Leases(l.filename)
+		if err != nil {
+			return fmt.Errorf("failed to load DHCPv6 records: %w", err)
+		}
+		l.lock.Lock()
+		l.deleted6 = updateDeleted(l.filename, l.deleted6, l.records6, records,
+			func(r Record6) net.IP { return r.IP }, l.grace, time.Now())
+		l.records6 = records
+		l.lock.Unlock()
+		ips := make(map[string]net.IP, len(records))
+		for mac, r := range records {
+			ips[mac] = r.IP
+		}
+		setStaticRecords(ips)
+		return nil
 	}
+	records, err := LoadDHCPv4Records(l.filename)
 	if err != nil {
-		return fmt.Errorf("failed to load DHCPv%d records: %w", protver, err)
+		return fmt.Errorf("failed to load DHCPv4 records: %w", err)
 	}
+	l.lock.Lock()
+	l.deleted4 = updateDeleted(l.filename, l.deleted4, l.records4, records,
+		func(ip net.IP) net.IP { return ip }, l.grace, time.Now())
+	l.records4 = records
+	l.lock.Unlock()
+	setStaticRecords(records)
+	return nil
+}
 
-	recLock.Lock()
-	defer recLock.Unlock()
-
-	StaticRecords = records
+// updateDeleted returns the soft-deleted records of a file whose records
+// changed from old to current. Records removed from the file are kept until
+// the grace period expires, unless their address is now used by another MAC
+// address. Restored and expired records are forgotten.
+func updateDeleted[T any](filename string, deleted map[string]softDeleted[T], old, current map[string]T,
+	addr func(T) net.IP, grace time.Duration, now time.Time) map[string]softDeleted[T] {
+	if grace == 0 {
+		return nil
+	}
+	used := make(map[string]bool, len(current))
+	for _, r := range current {
+		used[addr(r).String()] = true
+	}
+	result := make(map[string]softDeleted[T])
+	for mac, d := range deleted {
+		switch _, restored := current[mac]; {
+		case restored:
+			log.Infof("record for %s restored in %s", mac, filename)
+		case !now.Before(d.until):
+		case used[addr(d.record).String()]:
+			log.Warningf("removed record for %s not served anymore, %s is now used by another MAC address", mac, addr(d.record))
+		default:
+			result[mac] = d
+		}
+	}
+	for mac, r := range old {
+		if _, ok := current[mac]; ok {
+			continue
+		}
+		if used[addr(r).String()] {
+			log.Warningf("record for %s removed from %s, %s is now used by another MAC address", mac, filename, addr(r))
+			continue
+		}
+		result[mac] = softDeleted[T]{record: r, until: now.Add(grace)}
+		log.Warningf("record for %s removed from %s, still serving %s until %s, add it back to restore it",
+			mac, filename, addr(r), now.Add(grace).Format(time.RFC3339))
+	}
+	return result
+}
 
-	return nil
+// lookupDeleted returns the soft-deleted record for mac, if it has not
+// expired at now. Must be called with the lock held.
+func lookupDeleted[T any](deleted map[string]softDeleted[T], mac string, now time.Time) (T, bool) {
+	d, ok := deleted[mac]
+	if !ok || !now.Before(d.until) {
+		var none T
+		return none, false
+	}
+	log.Infof("serving removed record for %s until %s", mac, d.until.Format(time.RFC3339))
+	return d.record, true
 }
@@ -7,6 +7,8 @@ package file
 import (
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -212,6 +214,35 @@ func TestLoadDHCPv6Records(t *testing.T) {
 	})
 }
 
+func TestLoadDHCPv6Leases(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(tmp, []byte(
+		"00:11:22:33:44:55 2001:db8::10:1\n"+
+			"11:22:33:44:55:66 2001:db8::10:2 valid=24h preferred=12h\n"+
+			"22:33:44:55:66:77 2001:db8::10:3 valid=2h\n"+
+			"33:44:55:66:77:88 2001:db8::10:4 deprecated\n"), 0644))
+	records, err := LoadDHCPv6Leases(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Record6{
+		"00:11:22:33:44:55": {net.ParseIP("2001:db8::10:1"), time.Hour, time.Hour},
+		"11:22:33:44:55:66": {net.ParseIP("2001:db8::10:2"), 12 * time.Hour, 24 * time.Hour},
+		"22:33:44:55:66:77": {net.ParseIP("2001:db8::10:3"), 2 * time.Hour, 2 * time.Hour},
+		"33:44:55:66:77:88": {net.ParseIP("2001:db8::10:4"), 0, time.Hour},
+	}, records)
+
+	for _, line := range []string{
+		"00:11:22:33:44:55 2001:db8::10:1 valid=0s",
+		"00:11:22:33:44:55 2001:db8::10:1 valid=1h preferred=2h",
+		"00:11:22:33:44:55 2001:db8::10:1 preferred=soon",
+		"00:11:22:33:44:55 2001:db8::10:1 expires=1h",
+		"00:11:22:33:44:55 2001:db8::10:1 obsolete",
+	} {
+		require.NoError(t, os.WriteFile(tmp, []byte(line+"\n"), 0644))
+		_, err := LoadDHCPv6Leases(tmp)
+		assert.Error(t, err, line)
+	}
+}
+
 func TestHandler4(t *testing.T) {
 	t.Run("unknown MAC", func(t *testing.T) {
 		// prepare DHCPv4 request
@@ -225,7 +256,8 @@ func TestHandler4(t *testing.T) {
 
 		// if we handle this DHCP request, nothing should change since the lease is
 		// unknown
-		result, stop := Handler4(req, resp)
+		l := &leaseFile{records4: map[string]net.IP{}}
+		result, stop := l.Handler4(req, resp)
 		assert.Same(t, result, resp)
 		assert.False(t, stop)
 		assert.Nil(t, result.YourIPAddr)
@@ -243,19 +275,16 @@ func TestHandler4(t *testing.T) {
 
 		// add lease for the MAC in the lease map
 		clIPAddr := net.ParseIP("192.0.2.100")
-		StaticRecords = map[string]net.IP{
+		l := &leaseFile{records4: map[string]net.IP{
 			mac: clIPAddr,
-		}
+		}}
 
 		// if we handle this DHCP request, the YourIPAddr field should be set
 		// in the result
-		result, stop := Handler4(req, resp)
+		result, stop := l.Handler4(req, resp)
 		assert.Same(t, result, resp)
 		assert.True(t, stop)
 		assert.Equal(t, clIPAddr, result.YourIPAddr)
-
-		// cleanup
-		StaticRecords = make(map[string]net.IP)
 	})
 }
 
@@ -272,7 +301,8 @@ func TestHandler6(t *testing.T) {
 
 		// if we handle this DHCP request, nothing should change since the lease is
 		// unknown
-		result, stop := Handler6(req, resp)
+		l := &leaseFile{records6: map[string]Record6{}}
+		result, stop := l.Handler6(req, resp)
 		assert.False(t, stop)
 		assert.Equal(t, 0, len(result.GetOption(dhcpv6.OptionIANA)))
 	})
@@ -289,38 +319,40 @@ func TestHandler6(t *testing.T) {
 
 		// add lease for the MAC in the lease map
 		clIPAddr := net.ParseIP("2001:db8::10:1")
-		StaticRecords = map[string]net.IP{
-			mac: clIPAddr,
-		}
+		l := &leaseFile{records6: map[string]Record6{
+			mac: {IP: clIPAddr, PreferredLifetime: time.Hour, ValidLifetime: 2 * time.Hour},
+		}}
 
 		// if we handle this DHCP request, there should be a specific IANA option
 		// set in the resulting response
-		result, stop := Handler6(req, resp)
+		result, stop := l.Handler6(req, resp)
 		assert.False(t, stop)
 		if assert.Equal(t, 1, len(result.GetOption(dhcpv6.OptionIANA))) {
 			opt := result.GetOneOption(dhcpv6.OptionIANA)
-			assert.Contains(t, opt.String(), "IP=2001:db8::10:1")
+			addr := opt.(*dhcpv6.OptIANA).Options.OneAddress()
+			if assert.NotNil(t, addr) {
+				assert.Equal(t, clIPAddr, addr.IPv6Addr)
+				assert.Equal(t, time.Hour, addr.PreferredLifetime)
+				assert.Equal(t, 2*time.Hour, addr.ValidLifetime)
+			}
 		}
-
-		// cleanup
-		StaticRecords = make(map[string]net.IP)
 	})
 }
 
 func TestSetupFile(t *testing.T) {
 	// too few arguments
-	_, _, err := setupFile(false)
+	_, err := setupFile(false)
 	assert.Error(t, err)
 
 	// empty file name
-	_, _, err = setupFile(false, "")
+	_, err = setupFile(false, "")
 	assert.Error(t, err)
 
 	// trigger error in LoadDHCPv*Records
-	_, _, err = setupFile(false, "/foo/bar")
+	_, err = setupFile(false, "/foo/bar")
 	assert.Error(t, err)
 
-	_, _, err = setupFile(true, "/foo/bar")
+	_, err = setupFile(true, "/foo/bar")
 	assert.Error(t, err)
 
 	// setup temp leases file
@@ -337,20 +369,18 @@ func TestSetupFile(t *testing.T) {
 		_, err = tmp.WriteString("11:22:33:44:55:66 2001:db8::10:2\n")
 		require.NoError(t, err)
 
-		assert.Equal(t, 0, len(StaticRecords))
-
-		// leases should show up in StaticRecords
-		_, _, err = setupFile(true, tmp.Name())
+		// leases should show up in the records of the instance
+		l, err := setupFile(true, tmp.Name())
 		if assert.NoError(t, err) {
-			assert.Equal(t, 2, len(StaticRecords))
+			assert.Equal(t, 2, l.count(true))
+			assert.Nil(t, l.records4, "DHCPv4 records loaded for a DHCPv6 setup")
 		}
 	})
 
 	t.Run("autorefresh enabled", func(t *testing.T) {
-		_, _, err = setupFile(true, tmp.Name(), autoRefreshArg)
-		if assert.NoError(t, err) {
-			assert.Equal(t, 2, len(StaticRecords))
-		}
+		l, err := setupFile(true, tmp.Name(), autoRefreshArg)
+		require.NoError(t, err)
+		assert.Equal(t, 2, l.count(true))
 		// we add more leases to the file
 		// this should trigger an event to refresh the leases database
 		// without calling setupFile again
@@ -359,10 +389,114 @@ func TestSetupFile(t *testing.T) {
 		// since the event is processed asynchronously, give it a little time
 		time.Sleep(time.Millisecond * 100)
 		// an additional record should show up in the database
-		// but we should respect the locking first
-		recLock.RLock()
-		defer recLock.RUnlock()
-
-		assert.Equal(t, 3, len(StaticRecords))
+		assert.Equal(t, 3, l.count(true))
 	})
 }
+
+func TestSetupFileShared(t *testing.T) {
+	dir := t.TempDir()
+	file4 := filepath.Join(dir, "leases4.txt")
+	file6 := filepath.Join(dir, "leases6.txt")
+	require.NoError(t, os.WriteFile(file4, []byte("00:11:22:33:44:55 192.0.2.100\n"), 0644))
+	require.NoError(t, os.WriteFile(file6, []byte("00:11:22:33:44:55 2001:db8::10:1\n"), 0644))
+
+	// instances with the same file are shared
+	a, err := setupFile(false, file4)
+	require.NoError(t, err)
+	b, err := setupFile(false, file4)
+	require.NoError(t, err)
+	assert.Same(t, a, b)
+
+	// setting up the other protocol with another file doesn't affect them
+	l6, err := setupFile(true, file6)
+	require.NoError(t, err)
+	assert.NotSame(t, a, l6)
+	assert.Equal(t, net.ParseIP("192.0.2.100"), a.records4["00:11:22:33:44:55"])
+	assert.Equal(t, net.ParseIP("2001:db8::10:1"), l6.records6["00:11:22:33:44:55"].IP)
+}
+
+func TestPackageHandler4(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(file, []byte("00:11:22:33:44:55 192.0.2.100\n"), 0644))
+	_, err := setup4(file)
+	require.NoError(t, err)
+
+	claddr, _ := net.ParseMAC("00:11:22:33:44:55")
+	result, stop := Handler4(&dhcpv4.DHCPv4{ClientHWAddr: claddr}, &dhcpv4.DHCPv4{})
+	assert.True(t, stop)
+	assert.Equal(t, net.ParseIP("192.0.2.100"), result.YourIPAddr)
+	assert.Equal(t, net.ParseIP("192.0.2.100"), StaticRecords["00:11:22:33:44:55"])
+}
+
+func TestSoftDelete(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.txt")
+	write := func(lines ...string) {
+		require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644))
+	}
+	lookup := func(l *leaseFile, mac string) net.IP {
+		claddr, _ := net.ParseMAC(mac)
+		resp := &dhcpv4.DHCPv4{}
+		l.Handler4(&dhcpv4.DHCPv4{ClientHWAddr: claddr}, resp)
+		return resp.YourIPAddr
+	}
+	l := &leaseFile{filename: file, grace: time.Hour}
+
+	write("00:11:22:33:44:55 192.0.2.100", "11:22:33:44:55:66 192.0.2.101")
+	require.NoError(t, l.load(false))
+	write("00:11:22:33:44:55 192.0.2.100")
+	require.NoError(t, l.load(false))
+	assert.Equal(t, net.ParseIP("192.0.2.101"), lookup(l, "11:22:33:44:55:66"), "removed record")
+
+	// restored
+	write("00:11:22:33:44:55 192.0.2.100", "11:22:33:44:55:66 192.0.2.101")
+	require.NoError(t, l.load(false))
+	assert.Empty(t, l.deleted4)
+
+	// the address is given to another MAC address
+	write("00:11:22:33:44:55 192.0.2.100", "22:33:44:55:66:77 192.0.2.101")
+	require.NoError(t, l.load(false))
+	assert.Nil(t, lookup(l, "11:22:33:44:55:66"))
+
+	// no grace period
+	l = &leaseFile{filename: file}
+	require.NoError(t, l.load(false))
+	write("00:11:22:33:44:55 192.0.2.100")
+	require.NoError(t, l.load(false))
+	assert.Nil(t, lookup(l, "22:33:44:55:66:77"))
+}
+
+func TestSoftDeleteExpiry(t *testing.T) {
+	now := time.Now()
+	addr := func(r Record6) net.IP { return r.IP }
+	old := map[string]Record6{"00:11:22:33:44:55": {IP: net.ParseIP("2001:db8::1")}}
+	deleted := updateDeleted("leases.txt", nil, old, map[string]Record6{}, addr, time.Hour, now)
+	require.Contains(t, deleted, "00:11:22:33:44:55")
+
+	_, ok := lookupDeleted(deleted, "00:11:22:33:44:55", now.Add(59*time.Minute))
+	assert.True(t, ok)
+	_, ok = lookupDeleted(deleted, "00:11:22:33:44:55", now.Add(time.Hour))
+	assert.False(t, ok, "expired")
+
+	deleted = updateDeleted("leases.txt", deleted, map[string]Record6{}, map[string]Record6{}, addr, time.Hour, now.Add(time.Hour))
+	assert.Empty(t, deleted, "expired records are forgotten")
+}
+
+func TestSetupFileGrace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(file, []byte("00:11:22:33:44:55 192.0.2.100\n"), 0644))
+
+	for _, args := range [][]string{
+		{file, "grace=1h"},
+		{file, autoRefreshArg, "grace=soon"},
+		{file, autoRefreshArg, "grace=0s"},
+		{file, "refresh"},
+	} {
+		_, err := setupFile(false, args...)
+		assert.Error(t, err, args)
+	}
+	l, err := setupFile(false, file, autoRefreshArg, "grace=1h")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, l.grace)
+	_, err = setupFile(true, file, autoRefreshArg, "grace=2h")
+	assert.Error(t, err, "conflicting grace periods")
+}
@@ -0,0 +1,51 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import "sync"
+
+// Instances is a registry of plugin state, by key. A plugin configured several
+// times with the same arguments, typically under both server6 and server4,
+// keeps one instance per key in it, so that its setup functions share that
+// instance instead of each loading their own copy, or overwriting package
+// globals set by the other.
+//
+// The key is chosen by the plugin, usually from the arguments that identify
+// the state, such as the name of the file it is loaded from. The zero value
+// is an empty registry, meant to be a package variable of the plugin:
+//
+//	var instances plugins.Instances[*state]
+//
+//	func setup4(args ...string) (handler.Handler4, error) {
+//		s, err := instances.Get(args[0], func() (*state, error) {
+//			return load(args[0])
+//		})
+//		...
+//	}
+type Instances[T any] struct {
+	mu        sync.Mutex
+	instances map[string]T
+}
+
+// Get returns the instance for key, calling create to make it if there is
+// none yet. The setup functions of a plugin are called one at a time, but Get
+// is safe for concurrent use anyway. Instances are not created again after
+// create succeeded, while errors are returned without being remembered.
+func (r *Instances[T]) Get(key string, create func() (T, error)) (T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if instance, ok := r.instances[key]; ok {
+		return instance, nil
+	}
+	instance, err := create()
+	if err != nil {
+		return instance, err
+	}
+	if r.instances == nil {
+		r.instances = make(map[string]T)
+	}
+	r.instances[key] = instance
+	return instance, nil
+}
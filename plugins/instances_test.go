@@ -0,0 +1,41 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstances(t *testing.T) {
+	var (
+		r       Instances[*int]
+		created int
+	)
+	create := func() (*int, error) {
+		created++
+		n := created
+		return &n, nil
+	}
+
+	a, err := r.Get("a", create)
+	require.NoError(t, err)
+	again, err := r.Get("a", create)
+	require.NoError(t, err)
+	assert.Same(t, a, again)
+	b, err := r.Get("b", create)
+	require.NoError(t, err)
+	assert.NotSame(t, a, b)
+	assert.Equal(t, 2, created)
+
+	_, err = r.Get("c", func() (*int, error) { return nil, errors.New("failed") })
+	assert.Error(t, err)
+	c, err := r.Get("c", create)
+	require.NoError(t, err, "errors must not be remembered")
+	assert.Equal(t, 3, *c)
+}
@@ -28,37 +28,49 @@ import (
 
 var log = logger.GetLogger("plugins/ipv6only")
 
-var v6only_wait time.Duration
-
 var Plugin = plugins.Plugin{
 	Name:   "ipv6only",
 	Setup4: setup4,
 }
 
+// v6onlyWait is the V6ONLY_WAIT duration sent by an instance of the plugin
+type v6onlyWait time.Duration
+
 func setup4(args ...string) (handler.Handler4, error) {
+	var wait v6onlyWait
 	if len(args) > 0 {
 		dur, err := time.ParseDuration(args[0])
 		if err != nil {
 			log.Errorf("invalid duration: %v", args[0])
 			return nil, errors.New("ipv6only failed to initialize")
 		}
-		v6only_wait = dur
+		wait = v6onlyWait(dur)
 	}
 	if len(args) > 1 {
 		return nil, errors.New("too many arguments")
 	}
-	return Handler4, nil
+	last.Set4(wait.Handler4)
+	return wait.Handler4, nil
 }
 
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+// Handler4 handles DHCPv4 packets for the ipv6only plugin
+func (wait v6onlyWait) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	v6pref := req.IsOptionRequested(dhcpv4.OptionIPv6OnlyPreferred)
 	log.WithFields(logrus.Fields{
 		"mac":      req.ClientHWAddr.String(),
 		"ipv6only": v6pref,
 	}).Debug("ipv6only status")
 	if v6pref {
-		resp.UpdateOption(dhcpv4.OptIPv6OnlyPreferred(v6only_wait))
+		resp.UpdateOption(dhcpv4.OptIPv6OnlyPreferred(time.Duration(wait)))
 		return resp, true
 	}
 	return resp, false
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
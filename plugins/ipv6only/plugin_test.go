@@ -24,9 +24,9 @@ func TestOptionRequested(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	v6only_wait = 0x1234 * time.Second
+	wait := v6onlyWait(0x1234 * time.Second)
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := wait.Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
@@ -52,7 +52,7 @@ func TestNotRequested(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler4(req, stub)
+	resp, stop := v6onlyWait(0).Handler4(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return a message")
 	}
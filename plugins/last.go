@@ -0,0 +1,74 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// LastInstance keeps the handlers of the last instance of a plugin set up, for
+// the package-level handlers of plugins whose settings moved into instances.
+// Its handlers pass responses through until an instance is set up. The zero
+// value is ready to use, and meant to be a package variable of the plugin.
+type LastInstance struct {
+	h4 atomic.Pointer[handler.Handler4]
+	h6 atomic.Pointer[handler.Handler6]
+}
+
+// Set4 makes h the DHCPv4 handler of the last instance
+func (l *LastInstance) Set4(h handler.Handler4) {
+	l.h4.Store(&h)
+}
+
+// Set6 makes h the DHCPv6 handler of the last instance
+func (l *LastInstance) Set6(h handler.Handler6) {
+	l.h6.Store(&h)
+}
+
+// Handler4 runs the DHCPv4 handler of the last instance
+func (l *LastInstance) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if h := l.h4.Load(); h != nil {
+		return (*h)(req, resp)
+	}
+	return resp, false
+}
+
+// Handler6 runs the DHCPv6 handler of the last instance
+func (l *LastInstance) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if h := l.h6.Load(); h != nil {
+		return (*h)(req, resp)
+	}
+	return resp, false
+}
+
+// Merged collects the values of the instances of a plugin set up from one
+// configuration, for plugins whose instances add to a single list, such as
+// DNS servers. The zero value is ready to use, and meant to be a package
+// variable of the plugin.
+type Merged[T any] struct {
+	mu     sync.Mutex
+	conf   *config.Config
+	values *[]T
+}
+
+// Add appends values to those of the previous instances set up from conf,
+// and returns the list shared by all of them. Setting up the instances of a
+// new configuration starts a new list. The list is complete once the plugins
+// are loaded, so it must only be read by handlers.
+func (m *Merged[T]) Add(conf *config.Config, values ...T) *[]T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil || m.conf != conf {
+		m.conf, m.values = conf, new([]T)
+	}
+	*m.values = append(*m.values, values...)
+	return m.values
+}
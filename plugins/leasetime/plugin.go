@@ -22,19 +22,19 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
-	log         = logger.GetLogger("plugins/lease_time")
-	v4LeaseTime time.Duration
-)
+var log = logger.GetLogger("plugins/lease_time")
+
+// v4LeaseTime is the lease time set by an instance of the plugin
+type v4LeaseTime time.Duration
 
 // Handler4 handles DHCPv4 packets for the lease_time plugin.
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (lt v4LeaseTime) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		return resp, false
 	}
 	// Set lease time unless it has already been set
 	if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
-		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(v4LeaseTime))
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(time.Duration(lt)))
 	}
 	return resp, false
 }
@@ -51,7 +51,16 @@ func setup4(args ...string) (handler.Handler4, error) {
 		log.Errorf("invalid duration: %v", args[0])
 		return nil, errors.New("lease_time failed to initialize")
 	}
-	v4LeaseTime = leaseTime
 
-	return Handler4, nil
+	lt := v4LeaseTime(leaseTime)
+	last.Set4(lt.Handler4)
+	return lt.Handler4, nil
+}
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
 }
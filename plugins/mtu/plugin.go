@@ -25,26 +25,35 @@ var Plugin = plugins.Plugin{
 	// No Setup6 since DHCPv6 does not have MTU-related options
 }
 
-var (
-	mtu int
-)
+// mtu is the interface MTU sent by an instance of the plugin
+type mtu int
 
 func setup4(args ...string) (handler.Handler4, error) {
 	if len(args) != 1 {
 		return nil, errors.New("need one mtu value")
 	}
-	var err error
-	if mtu, err = strconv.Atoi(args[0]); err != nil {
+	m, err := strconv.Atoi(args[0])
+	if err != nil {
 		return nil, fmt.Errorf("invalid mtu: %v", args[0])
 	}
-	log.Infof("loaded mtu %d.", mtu)
-	return Handler4, nil
+	log.Infof("loaded mtu %d.", m)
+	value := mtu(m)
+	last.Set4(value.Handler4)
+	return value.Handler4, nil
 }
 
 // Handler4 handles DHCPv4 packets for the mtu plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (m mtu) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.IsOptionRequested(dhcpv4.OptionInterfaceMTU) {
-		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(mtu)})
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(m)})
 	}
 	return resp, false
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package nak refuses every request reaching it, and stops the chain. Placed
+// after the plugins serving known clients, it tells the others they will not
+// get an address, where drop would leave them retrying.
+//
+// DHCPv4 requests are answered with a NAK, and discovers and informs dropped
+// as clients are only told no to requests (RFC 2131, section 4.3). DHCPv6
+// solicits, requests, renews and rebinds get a NoAddrsAvail status, or
+// NoPrefixAvail for prefix delegations, and other messages are dropped.
+//
+// The optional argument is a message explaining the refusal to the client:
+//
+//	server4:
+//	  plugins:
+//	    - server_id: 10.10.10.1
+//	    - file: "leases4.txt"
+//	    - nak: "unknown client, contact the helpdesk"
+package nak
+
+import (
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+var log = logger.GetLogger("plugins/nak")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "nak",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+type refusal struct {
+	message string
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	r := &refusal{message: strings.Join(args, " ")}
+	log.Printf("loaded plugin for DHCPv6 with message %q", r.message)
+	return r.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	r := &refusal{message: strings.Join(args, " ")}
+	log.Printf("loaded plugin for DHCPv4 with message %q", r.message)
+	return r.Handler4, nil
+}
+
+// Handler4 answers DHCPv4 requests with a NAK
+func (r *refusal) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.MessageType() != dhcpv4.MessageTypeRequest {
+		log.Debugf("dropping %s from %s", req.MessageType(), req.ClientHWAddr)
+		return nil, true
+	}
+	// Start over, earlier plugins may have filled in the lease
+	nak, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeNak))
+	if err != nil {
+		log.Errorf("cannot build NAK: %v", err)
+		return nil, true
+	}
+	if sid := resp.ServerIdentifier(); sid != nil {
+		nak.UpdateOption(dhcpv4.OptServerIdentifier(sid))
+	}
+	if r.message != "" {
+		nak.UpdateOption(dhcpv4.OptMessage(r.message))
+	}
+	log.Debugf("refusing request from %s", req.ClientHWAddr)
+	return nak, true
+}
+
+// Handler6 answers DHCPv6 requests for addresses and prefixes with a status
+// telling none are available
+func (r *refusal) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Error(err)
+		return nil, true
+	}
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		log.Errorf("response is a %s, not a client/server message", resp.Type())
+		return nil, true
+	}
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest,
+		dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		log.Debugf("dropping %s from %s", msg.Type(), msg.Options.ClientID())
+		return nil, true
+	}
+
+	// Take back what earlier plugins may have assigned
+	reply.Options.Del(dhcpv6.OptionIANA)
+	reply.Options.Del(dhcpv6.OptionIATA)
+	reply.Options.Del(dhcpv6.OptionIAPD)
+	status := iana.StatusNoAddrsAvail
+	if len(msg.Options.IANA()) == 0 && len(msg.Options.IAPD()) > 0 {
+		status = iana.StatusNoPrefixAvail
+	}
+	if msg.Type() == dhcpv6.MessageTypeSolicit {
+		// RFC 8415, section 18.3.9: the advertise only carries the status
+		reply.UpdateOption(&dhcpv6.OptStatusCode{StatusCode: status, StatusMessage: r.message})
+	} else {
+		// RFC 8415, section 18.3.2: each IA gets the status. Renewed and
+		// rebound IAs have no binding, see sections 18.3.4 and 18.3.5
+		addrStatus, prefixStatus := iana.StatusNoAddrsAvail, iana.StatusNoPrefixAvail
+		if msg.Type() != dhcpv6.MessageTypeRequest {
+			addrStatus, prefixStatus = iana.StatusNoBinding, iana.StatusNoBinding
+		}
+		for _, ia := range msg.Options.IANA() {
+			reply.AddOption(&dhcpv6.OptIANA{IaId: ia.IaId, Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+				&dhcpv6.OptStatusCode{StatusCode: addrStatus, StatusMessage: r.message},
+			}}})
+		}
+		for _, ia := range msg.Options.IAPD() {
+			reply.AddOption(&dhcpv6.OptIAPD{IaId: ia.IaId, Options: dhcpv6.PDOptions{Options: []dhcpv6.Option{
+				&dhcpv6.OptStatusCode{StatusCode: prefixStatus, StatusMessage: r.message},
+			}}})
+		}
+	}
+	log.Debugf("refusing %s from %s", msg.Type(), msg.Options.ClientID())
+	return reply, true
+}
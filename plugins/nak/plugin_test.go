@@ -0,0 +1,124 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nak
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var mac = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+func TestHandler4(t *testing.T) {
+	h, err := setup4("unknown", "client")
+	require.NoError(t, err)
+	serverID := net.IPv4(10, 10, 10, 1).To4()
+
+	exchange := func(mt dhcpv4.MessageType) (*dhcpv4.DHCPv4, bool) {
+		req, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(mt))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.WithYourIP(net.IPv4(10, 10, 10, 100)),
+			dhcpv4.WithServerIP(serverID),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)),
+			dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(time.Hour)),
+		)
+		require.NoError(t, err)
+		return h(req, resp)
+	}
+
+	resp, stop := exchange(dhcpv4.MessageTypeRequest)
+	assert.True(t, stop)
+	require.NotNil(t, resp)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	assert.False(t, resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime))
+	assert.Equal(t, serverID, resp.ServerIdentifier())
+	assert.Equal(t, "unknown client", resp.Message())
+
+	for _, mt := range []dhcpv4.MessageType{dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeInform} {
+		resp, stop = exchange(mt)
+		assert.True(t, stop)
+		assert.Nil(t, resp, "%s", mt)
+	}
+}
+
+func TestHandler6(t *testing.T) {
+	h, err := setup6("go", "away")
+	require.NoError(t, err)
+
+	exchange := func(mt dhcpv6.MessageType, ias ...dhcpv6.Option) *dhcpv6.Message {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = mt
+		req.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+		for _, ia := range ias {
+			req.AddOption(ia)
+		}
+		var resp *dhcpv6.Message
+		if mt == dhcpv6.MessageTypeSolicit {
+			resp, err = dhcpv6.NewAdvertiseFromSolicit(req)
+		} else {
+			resp, err = dhcpv6.NewReplyFromMessage(req)
+		}
+		require.NoError(t, err)
+		// an address assigned by an earlier plugin
+		resp.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{1}, Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10"), PreferredLifetime: time.Hour, ValidLifetime: time.Hour},
+		}}})
+		result, stop := h(req, resp)
+		assert.True(t, stop)
+		if result == nil {
+			return nil
+		}
+		return result.(*dhcpv6.Message)
+	}
+
+	resp := exchange(dhcpv6.MessageTypeSolicit, &dhcpv6.OptIANA{IaId: [4]byte{1}})
+	require.NotNil(t, resp)
+	assert.Empty(t, resp.Options.IANA())
+	if assert.NotNil(t, resp.Options.Status()) {
+		assert.Equal(t, iana.StatusNoAddrsAvail, resp.Options.Status().StatusCode)
+		assert.Equal(t, "go away", resp.Options.Status().StatusMessage)
+	}
+
+	resp = exchange(dhcpv6.MessageTypeSolicit, &dhcpv6.OptIAPD{IaId: [4]byte{2}})
+	require.NotNil(t, resp)
+	if assert.NotNil(t, resp.Options.Status()) {
+		assert.Equal(t, iana.StatusNoPrefixAvail, resp.Options.Status().StatusCode)
+	}
+
+	resp = exchange(dhcpv6.MessageTypeRequest, &dhcpv6.OptIANA{IaId: [4]byte{1}}, &dhcpv6.OptIAPD{IaId: [4]byte{2}})
+	require.NotNil(t, resp)
+	if ianas := resp.Options.IANA(); assert.Len(t, ianas, 1) {
+		assert.Empty(t, ianas[0].Options.Addresses())
+		assert.Equal(t, iana.StatusNoAddrsAvail, ianas[0].Options.Status().StatusCode)
+	}
+	if iapds := resp.Options.IAPD(); assert.Len(t, iapds, 1) {
+		assert.Equal(t, iana.StatusNoPrefixAvail, iapds[0].Options.Status().StatusCode)
+	}
+
+	for _, mt := range []dhcpv6.MessageType{dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind} {
+		resp = exchange(mt, &dhcpv6.OptIANA{IaId: [4]byte{1}}, &dhcpv6.OptIAPD{IaId: [4]byte{2}})
+		require.NotNil(t, resp)
+		if ianas := resp.Options.IANA(); assert.Len(t, ianas, 1) {
+			assert.Empty(t, ianas[0].Options.Addresses())
+			assert.Equal(t, iana.StatusNoBinding, ianas[0].Options.Status().StatusCode, mt)
+		}
+		if iapds := resp.Options.IAPD(); assert.Len(t, iapds, 1) {
+			assert.Equal(t, iana.StatusNoBinding, iapds[0].Options.Status().StatusCode, mt)
+		}
+	}
+
+	assert.Nil(t, exchange(dhcpv6.MessageTypeInformationRequest))
+}
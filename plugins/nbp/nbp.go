@@ -49,10 +49,15 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
+// nbp6 holds the options added by a DHCPv6 instance of the plugin
+type nbp6 struct {
 	opt59, opt60 dhcpv6.Option
+}
+
+// nbp4 holds the options added by a DHCPv4 instance of the plugin
+type nbp4 struct {
 	opt66, opt67 *dhcpv4.Option
-)
+}
 
 func parseArgs(args ...string) (*url.URL, error) {
 	if len(args) != 1 {
@@ -66,16 +71,16 @@ func setup6(args ...string) (handler.Handler6, error) {
 	if err != nil {
 		return nil, err
 	}
-	opt59 = dhcpv6.OptBootFileURL(u.String())
+	n := &nbp6{opt59: dhcpv6.OptBootFileURL(u.String())}
 	params := u.Query().Get("params")
 	if params != "" {
-		opt60 = &dhcpv6.OptionGeneric{
+		n.opt60 = &dhcpv6.OptionGeneric{
 			OptionCode: dhcpv6.OptionBootfileParam,
 			OptionData: []byte(params),
 		}
 	}
 	log.Printf("loaded NBP plugin for DHCPv6.")
-	return nbpHandler6, nil
+	return n.nbpHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
@@ -84,23 +89,26 @@ func setup4(args ...string) (handler.Handler4, error) {
 		return nil, err
 	}
 
-	var otsn, obfn dhcpv4.Option
+	var (
+		n          nbp4
+		otsn, obfn dhcpv4.Option
+	)
 	switch u.Scheme {
 	case "http", "https", "ftp":
 		obfn = dhcpv4.OptBootFileName(u.String())
 	default:
 		otsn = dhcpv4.OptTFTPServerName(u.Host)
 		obfn = dhcpv4.OptBootFileName(u.Path)
-		opt66 = &otsn
+		n.opt66 = &otsn
 	}
 
-	opt67 = &obfn
+	n.opt67 = &obfn
 	log.Printf("loaded NBP plugin for DHCPv4.")
-	return nbpHandler4, nil
+	return n.nbpHandler4, nil
 }
 
-func nbpHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	if opt59 == nil {
+func (n *nbp6) nbpHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if n.opt59 == nil {
 		// nothing to do
 		return resp, true
 	}
@@ -113,30 +121,30 @@ func nbpHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	for _, code := range decap.Options.RequestedOptions() {
 		if code == dhcpv6.OptionBootfileURL {
 			// bootfile URL is requested
-			resp.AddOption(opt59)
+			resp.AddOption(n.opt59)
 		} else if code == dhcpv6.OptionBootfileParam {
 			// optionally add opt60, bootfile params, if requested
-			if opt60 != nil {
-				resp.AddOption(opt60)
+			if n.opt60 != nil {
+				resp.AddOption(n.opt60)
 			}
 		}
 	}
-	log.Debugf("Added NBP %s to request", opt59)
+	log.Debugf("Added NBP %s to request", n.opt59)
 	return resp, true
 }
 
-func nbpHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if opt67 == nil {
+func (n *nbp4) nbpHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if n.opt67 == nil {
 		// nothing to do
 		return resp, true
 	}
-	if req.IsOptionRequested(dhcpv4.OptionTFTPServerName) && opt66 != nil {
-		resp.Options.Update(*opt66)
-		log.Debugf("Added NBP %s / %s to request", opt66, opt67)
+	if req.IsOptionRequested(dhcpv4.OptionTFTPServerName) && n.opt66 != nil {
+		resp.Options.Update(*n.opt66)
+		log.Debugf("Added NBP %s / %s to request", n.opt66, n.opt67)
 	}
 	if req.IsOptionRequested(dhcpv4.OptionBootfileName) {
-		resp.Options.Update(*opt67)
-		log.Debugf("Added NBP %s to request", opt67)
+		resp.Options.Update(*n.opt67)
+		log.Debugf("Added NBP %s to request", n.opt67)
 	}
 	return resp, true
 }
@@ -23,12 +23,12 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
-	netmask net.IPMask
-)
+// netmask is the subnet mask sent by an instance of the plugin
+type netmask net.IPMask
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
+	pool, args := plugins.PoolArg(args)
 	if len(args) != 1 {
 		return nil, errors.New("need at least one netmask IP address")
 	}
@@ -40,20 +40,33 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if netmaskIP == nil {
 		return nil, errors.New("expected an netmask address, got: " + args[0])
 	}
-	netmask = net.IPv4Mask(netmaskIP[0], netmaskIP[1], netmaskIP[2], netmaskIP[3])
-	if !checkValidNetmask(netmask) {
+	mask := net.IPv4Mask(netmaskIP[0], netmaskIP[1], netmaskIP[2], netmaskIP[3])
+	if !checkValidNetmask(mask) {
 		return nil, errors.New("netmask is not valid, got: " + args[0])
 	}
 	log.Printf("loaded client netmask")
-	return Handler4, nil
+	if pool != "" {
+		return handler.ForPool4(pool, netmask(mask).Handler4), nil
+	}
+	n := netmask(mask)
+	last.Set4(n.Handler4)
+	return n.Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the netmask plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptSubnetMask(netmask))
+func (n netmask) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.Options.Update(dhcpv4.OptSubnetMask(net.IPMask(n)))
 	return resp, false
 }
 
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
+
 func checkValidNetmask(netmask net.IPMask) bool {
 	netmaskInt := binary.BigEndian.Uint32(netmask)
 	x := ^netmaskInt
@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/stretchr/testify/assert"
 )
@@ -25,7 +26,7 @@ func TestCheckValidNetmask(t *testing.T) {
 
 func TestHandler4(t *testing.T) {
 	// set plugin netmask
-	netmask = net.IPv4Mask(255, 255, 255, 0)
+	mask := netmask(net.IPv4Mask(255, 255, 255, 0))
 
 	// prepare DHCPv4 request
 	req := &dhcpv4.DHCPv4{}
@@ -35,17 +36,19 @@ func TestHandler4(t *testing.T) {
 
 	// if we handle this DHCP request, the netmask should be one of the options
 	// of the result
-	result, stop := Handler4(req, resp)
+	result, stop := mask.Handler4(req, resp)
 	assert.Same(t, result, resp)
 	assert.False(t, stop)
-	assert.EqualValues(t, netmask, resp.Options.Get(dhcpv4.OptionSubnetMask))
+	assert.EqualValues(t, mask, resp.Options.Get(dhcpv4.OptionSubnetMask))
 }
 
 func TestSetup4(t *testing.T) {
 	// valid configuration
-	_, err := setup4("255.255.255.0")
+	h, err := setup4("255.255.255.0")
 	assert.NoError(t, err)
-	assert.EqualValues(t, netmask, net.IPv4Mask(255, 255, 255, 0))
+	resp := &dhcpv4.DHCPv4{Options: dhcpv4.Options{}}
+	h(&dhcpv4.DHCPv4{}, resp)
+	assert.EqualValues(t, net.IPv4Mask(255, 255, 255, 0), resp.Options.Get(dhcpv4.OptionSubnetMask))
 
 	// no configuration
 	_, err = setup4()
@@ -63,3 +66,23 @@ func TestSetup4(t *testing.T) {
 	_, err = setup4("0.0.0.255")
 	assert.Error(t, err)
 }
+
+func TestSetup4Pool(t *testing.T) {
+	h, err := setup4("pool=guests", "255.255.0.0")
+	assert.NoError(t, err)
+
+	for _, pool := range []string{"guests", "staff"} {
+		req := &dhcpv4.DHCPv4{}
+		resp := &dhcpv4.DHCPv4{Options: dhcpv4.Options{}}
+		handler.SetMetadata4(req, &handler.Metadata{Pool: pool})
+		result, stop := h(req, resp)
+		handler.ClearMetadata4(req)
+		assert.Same(t, result, resp)
+		assert.False(t, stop)
+		if pool == "guests" {
+			assert.EqualValues(t, net.IPv4Mask(255, 255, 0, 0), resp.Options.Get(dhcpv4.OptionSubnetMask))
+		} else {
+			assert.Nil(t, resp.Options.Get(dhcpv4.OptionSubnetMask), "netmask set for another pool")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package nextserver sets the next server address (siaddr) and server host
+// name (sname) fields of DHCPv4 offers and acknowledgements. Network boot
+// ROMs differ in whether they load their boot file from the server in these
+// fields or from the TFTP server name option (66), which the nbp plugin sets.
+//
+// The arguments are key=value pairs, at least one of siaddr and sname is
+// required:
+// - pool=<name>: only answer clients with an address from this pool, must be
+// the first argument
+// - siaddr=<IPv4 address>: next server address
+// - sname=<host name>: server host name, up to 63 characters
+// - vendor=<class>: only answer clients whose vendor class (option 60)
+// contains this string, for example PXEClient
+// - userclass=<class>: only answer clients sending this user class (option 77)
+// - vendorclass=<enterprise number>[:<class>]: only answer clients sending a
+// vendor-identifying vendor class (option 124) for this enterprise number, with
+// this class among its classes if given
+//
+// Several instances can be configured for different pools and classes:
+//
+//	server4:
+//	  plugins:
+//	    - server_id: 10.10.10.1
+//	    - nextserver: siaddr=10.10.10.5 sname=tftp.example.net vendor=PXEClient
+//	    - nextserver: pool=lab siaddr=10.20.0.5 userclass=iPXE
+package nextserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/nextserver")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "nextserver",
+	Setup4: setup4,
+}
+
+// maxSNameLen is the size of the sname field, minus the terminating NUL
+const maxSNameLen = 63
+
+type config struct {
+	siaddr net.IP
+	sname  string
+	class  handler.ClientClass
+}
+
+func parseArgs(args []string) (*config, error) {
+	var c config
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid argument %q, want key=value", arg)
+		}
+		switch key {
+		case "siaddr":
+			c.siaddr = net.ParseIP(value).To4()
+			if c.siaddr == nil {
+				return nil, fmt.Errorf("invalid siaddr %q, want an IPv4 address", value)
+			}
+		case "sname":
+			if value == "" || len(value) > maxSNameLen {
+				return nil, fmt.Errorf("invalid sname %q, want 1 to %d characters", value, maxSNameLen)
+			}
+			c.sname = value
+		case "vendor":
+			c.class.Vendor = value
+		case "userclass":
+			c.class.UserClass = value
+		case "vendorclass":
+			if err := c.class.SetEnterprise(value); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if c.siaddr == nil && c.sname == "" {
+		return nil, errors.New("need siaddr, sname or both")
+	}
+	return &c, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	pool, args := plugins.PoolArg(args)
+	c, err := parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded next server %s, server name %q", c.siaddr, c.sname)
+	if pool != "" {
+		return handler.ForPool4(pool, c.Handler4), nil
+	}
+	return c.Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the nextserver plugin
+func (c *config) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	// RFC 2131, table 3: siaddr and sname are not used in NAKs
+	if mt := resp.MessageType(); mt != dhcpv4.MessageTypeOffer && mt != dhcpv4.MessageTypeAck {
+		return resp, false
+	}
+	if !c.class.Matches(req) {
+		return resp, false
+	}
+	if c.siaddr != nil {
+		resp.ServerIPAddr = c.siaddr
+	}
+	if c.sname != "" {
+		resp.ServerHostName = c.sname
+	}
+	return resp, false
+}
@@ -0,0 +1,79 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nextserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exchange(t *testing.T, args []string, mt dhcpv4.MessageType, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	h, err := setup4(args...)
+	require.NoError(t, err)
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, mods...)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(mt))
+	require.NoError(t, err)
+	resp.ServerIPAddr = net.IPv4(10, 10, 10, 1).To4()
+	resp, stop := h(req, resp)
+	require.False(t, stop)
+	return resp
+}
+
+func TestHandler4(t *testing.T) {
+	resp := exchange(t, []string{"siaddr=10.10.10.5", "sname=tftp.example.net"}, dhcpv4.MessageTypeOffer)
+	assert.Equal(t, net.IPv4(10, 10, 10, 5).To4(), resp.ServerIPAddr)
+	assert.Equal(t, "tftp.example.net", resp.ServerHostName)
+
+	resp = exchange(t, []string{"sname=tftp.example.net"}, dhcpv4.MessageTypeAck)
+	assert.Equal(t, net.IPv4(10, 10, 10, 1).To4(), resp.ServerIPAddr, "siaddr left alone")
+	assert.Equal(t, "tftp.example.net", resp.ServerHostName)
+
+	resp = exchange(t, []string{"siaddr=10.10.10.5"}, dhcpv4.MessageTypeNak)
+	assert.Equal(t, net.IPv4(10, 10, 10, 1).To4(), resp.ServerIPAddr, "NAK")
+}
+
+func TestHandler4Classes(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		args  []string
+		mods  []dhcpv4.Modifier
+		match bool
+	}{
+		{"vendor", []string{"siaddr=10.10.10.5", "vendor=PXEClient"},
+			[]dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007"))}, true},
+		{"other vendor", []string{"siaddr=10.10.10.5", "vendor=PXEClient"},
+			[]dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("udhcp 1.36"))}, false},
+		{"no vendor", []string{"siaddr=10.10.10.5", "vendor=PXEClient"}, nil, false},
+		{"user class", []string{"siaddr=10.10.10.5", "userclass=iPXE"},
+			[]dhcpv4.Modifier{dhcpv4.WithUserClass("iPXE", false)}, true},
+		{"other user class", []string{"siaddr=10.10.10.5", "userclass=iPXE"},
+			[]dhcpv4.Modifier{dhcpv4.WithUserClass("gPXE", false)}, false},
+	} {
+		resp := exchange(t, tt.args, dhcpv4.MessageTypeOffer, tt.mods...)
+		assert.Equal(t, tt.match, resp.ServerIPAddr.Equal(net.IPv4(10, 10, 10, 5)), tt.name)
+	}
+}
+
+func TestSetup4Errors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"10.10.10.5"},
+		{"siaddr=2001:db8::5"},
+		{"siaddr=tftp"},
+		{"sname="},
+		{"sname=" + strings.Repeat("a", 64)},
+		{"vendor=PXEClient"},
+		{"file=pxelinux.0", "siaddr=10.10.10.5"},
+	} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
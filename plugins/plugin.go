@@ -6,10 +6,13 @@ package plugins
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
+	"github.com/sirupsen/logrus"
 )
 
 var log = logger.GetLogger("plugins")
@@ -17,10 +20,35 @@ var log = logger.GetLogger("plugins")
 // Plugin represents a plugin object.
 // Setup6 and Setup4 are the setup functions for DHCPv6 and DHCPv4 handlers
 // respectively. Both setup functions can be nil.
+// Setup6WithOptions and Setup4WithOptions are setup functions that also get
+// the whole plugin entry and the services of the core, see Deps. They are used
+// instead of Setup6 and Setup4 when set. Plugins using them need a
+// MinCoreAPI of at least 2.
+// DependsOn lists the names of the plugins whose setup must be complete before
+// this plugin is set up, when they are configured. Plugins are otherwise set
+// up in parallel.
+// Version is reported in the plugin inventory, see VersionOf, and MinCoreAPI
+// is the lowest CoreAPIVersion the plugin works with. Both are optional.
 type Plugin struct {
-	Name   string
-	Setup6 SetupFunc6
-	Setup4 SetupFunc4
+	Name       string
+	Setup6     SetupFunc6
+	Setup4     SetupFunc4
+	DependsOn  []string
+	Version    string
+	MinCoreAPI int
+
+	Setup6WithOptions SetupFunc6WithOptions
+	Setup4WithOptions SetupFunc4WithOptions
+}
+
+// has6 returns whether p handles DHCPv6
+func (p *Plugin) has6() bool {
+	return p.Setup6 != nil || p.Setup6WithOptions != nil
+}
+
+// has4 returns whether p handles DHCPv4
+func (p *Plugin) has4() bool {
+	return p.Setup4 != nil || p.Setup4WithOptions != nil
 }
 
 // RegisteredPlugins maps a plugin name to a Plugin instance.
@@ -32,12 +60,52 @@ type SetupFunc6 func(args ...string) (handler.Handler6, error)
 // SetupFunc4 defines a plugin setup function for DHCPv6
 type SetupFunc4 func(args ...string) (handler.Handler4, error)
 
+// SetupFunc6WithOptions defines a plugin setup function for DHCPv6, given
+// the plugin entry of the configuration and the services of the core
+type SetupFunc6WithOptions func(cfg config.PluginConfig, deps Deps) (handler.Handler6, error)
+
+// SetupFunc4WithOptions defines a plugin setup function for DHCPv4, given
+// the plugin entry of the configuration and the services of the core
+type SetupFunc4WithOptions func(cfg config.PluginConfig, deps Deps) (handler.Handler4, error)
+
+// Deps are the services the core offers to plugins during their setup. New
+// services are added as new fields along with an increment of CoreAPIVersion,
+// so plugins relying on one set MinCoreAPI to the version that introduced it.
+type Deps struct {
+	// Log is the logger of the plugin, prefixed with plugins/<name>
+	Log *logrus.Entry
+	// ReportPool registers the utilization of a pool for the status file of
+	// the server, see ReportPool
+	ReportPool func(status func() PoolStatus)
+	// Config is the whole configuration, such as the arguments shared by
+	// plugins. Plugins must not modify it.
+	Config *config.Config
+	// OnUnload registers a function stopping the background work of the
+	// instance, such as watching the system for changes. It is called when
+	// the server using the instance closes, see Unload.
+	OnUnload func(stop func())
+}
+
+// PoolArg splits a leading "pool=<name>" argument from the other arguments
+// of a plugin. Plugins that accept it only apply to requests whose address
+// was allocated from that pool, see handler.ForPool4.
+func PoolArg(args []string) (pool string, rest []string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "pool=") {
+		return strings.TrimPrefix(args[0], "pool="), args[1:]
+	}
+	return "", args
+}
+
 // RegisterPlugin registers a plugin.
 func RegisterPlugin(plugin *Plugin) error {
 	if plugin == nil {
 		return errors.New("cannot register nil plugin")
 	}
-	log.Printf("Registering plugin '%s'", plugin.Name)
+	if plugin.MinCoreAPI > CoreAPIVersion {
+		return fmt.Errorf("plugin '%s' needs core API version %d, this is version %d",
+			plugin.Name, plugin.MinCoreAPI, CoreAPIVersion)
+	}
+	log.Printf("Registering plugin '%s' version %s", plugin.Name, VersionOf(plugin))
 	if _, ok := RegisteredPlugins[plugin.Name]; ok {
 		// TODO this highlights that asking the plugins to register themselves
 		// is not the right approach. Need to register them in the main program.
@@ -64,49 +132,30 @@ func LoadPlugins(conf *config.Config) ([]handler.Handler4, []handler.Handler6, e
 
 	// now load the plugins. We need to call its setup function with
 	// the arguments extracted above. The setup function is mapped in
-	// plugins.RegisteredPlugins .
-
-	// Load DHCPv6 plugins.
-	if conf.Server6 != nil {
-		for _, pluginConf := range conf.Server6.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv6: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup6 == nil {
-					log.Warningf("DHCPv6: plugin `%s` has no setup function for DHCPv6", pluginConf.Name)
-					continue
-				}
-				h6, err := plugin.Setup6(pluginConf.Args...)
-				if err != nil {
-					return nil, nil, err
-				} else if h6 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv6 handler for plugin %s", pluginConf.Name)
-				}
-				handlers6 = append(handlers6, h6)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv6: unknown plugin `%s`", pluginConf.Name)
-			}
-		}
+	// plugins.RegisteredPlugins. Setups run in parallel, see setupJobs for
+	// the order they respect.
+	jobs, err := setupJobs(conf)
+	if err != nil {
+		return nil, nil, err
 	}
-	// Load DHCPv4 plugins. Yes, duplicated code, there's not really much that
-	// can be deduplicated here.
-	if conf.Server4 != nil {
-		for _, pluginConf := range conf.Server4.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv4: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup4 == nil {
-					log.Warningf("DHCPv4: plugin `%s` has no setup function for DHCPv4", pluginConf.Name)
-					continue
-				}
-				h4, err := plugin.Setup4(pluginConf.Args...)
-				if err != nil {
-					return nil, nil, err
-				} else if h4 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv4 handler for plugin %s", pluginConf.Name)
-				}
-				handlers4 = append(handlers4, h4)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv4: unknown plugin `%s`", pluginConf.Name)
+	resetPools()
+	if err := runSetup(jobs, conf.SetupTimeout); err != nil {
+		Unload(conf)
+		return nil, nil, err
+	}
+	for _, j := range jobs {
+		if j.v6 {
+			h6 := j.h6
+			if j.conf.Timeout != 0 {
+				h6 = withTimeout6(h6, j.conf)
+			}
+			handlers6 = append(handlers6, h6)
+		} else {
+			h4 := j.h4
+			if j.conf.Timeout != 0 {
+				h4 = withTimeout4(h4, j.conf)
 			}
+			handlers4 = append(handlers4, h4)
 		}
 	}
 
@@ -0,0 +1,69 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"sort"
+	"sync"
+)
+
+// PoolStatus is the utilization of an address pool of a plugin, as reported
+// in the status file of the server
+type PoolStatus struct {
+	Plugin string `json:"plugin"`
+	// Name is the name of the pool, if it has one
+	Name string `json:"name,omitempty"`
+	// Range describes the addresses of the pool, such as 10.0.0.10-10.0.0.200
+	Range string `json:"range"`
+	Size  uint64 `json:"size"`
+	Used  uint64 `json:"used"`
+	// Clients is the number of clients holding leases, for plugins leasing
+	// several addresses or prefixes to a client
+	Clients uint64 `json:"clients,omitempty"`
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   []func() PoolStatus
+)
+
+// ReportPool registers a function returning the utilization of a pool. Plugins
+// call it from their setup functions, once for every pool they allocate from,
+// and the function is called every time the server reports its status. It
+// must be safe for concurrent use with the handlers of the plugin.
+func ReportPool(status func() PoolStatus) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pools = append(pools, status)
+}
+
+// Pools returns the utilization of the pools reported by the plugins loaded
+// by the last call to LoadPlugins
+func Pools() []PoolStatus {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	statuses := make([]PoolStatus, 0, len(pools))
+	for _, status := range pools {
+		statuses = append(statuses, status())
+	}
+	// setups run in parallel, so pools are not registered in a stable order
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Plugin != statuses[j].Plugin {
+			return statuses[i].Plugin < statuses[j].Plugin
+		}
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].Range < statuses[j].Range
+	})
+	return statuses
+}
+
+// resetPools forgets the pools of previously loaded plugins
+func resetPools() {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pools = nil
+}
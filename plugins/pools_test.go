@@ -0,0 +1,32 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPools(t *testing.T) {
+	resetPools()
+	defer resetPools()
+	used := uint64(0)
+	ReportPool(func() PoolStatus {
+		return PoolStatus{Plugin: "range", Name: "staff", Range: "10.0.1.1-10.0.1.10", Size: 10, Used: used}
+	})
+	ReportPool(func() PoolStatus {
+		return PoolStatus{Plugin: "range", Name: "guests", Range: "10.0.2.1-10.0.2.10", Size: 10}
+	})
+
+	used = 4
+	pools := Pools()
+	if assert.Len(t, pools, 2) {
+		assert.Equal(t, "guests", pools[0].Name, "pools are not sorted")
+		assert.Equal(t, uint64(4), pools[1].Used, "utilization is not current")
+	}
+	resetPools()
+	assert.Empty(t, Pools())
+}
@@ -9,17 +9,47 @@
 // - prefix: The base prefix from which assigned prefixes are carved
 // - max: maximum size of the prefix delegated to clients. When a client requests a larger prefix
 // than this, this is the size of the offered prefix
+//
+// They can be followed by optional key=value arguments:
+// - min=<length>: minimum size of the prefix delegated to clients, as a prefix length. Defaults to
+// 128, ie any prefix smaller than max can be delegated
+// - policy=clamp|refuse: what to do when a client hints at a prefix length outside of [max, min].
+// clamp (the default) delegates a prefix of the closest allowed size, refuse answers with a
+// NoPrefixAvail status for that hint
+// - reservations=<file>: a file of static delegations, one per line, made of the client DUID in
+// hexadecimal (bytes optionally separated by colons) and a prefix within the pool. Reserved
+// prefixes are never delegated to other clients, and always delegated to their owner. They must
+// be of the allocation size. Lines starting with # are ignored, for example:
+//
+//	# business customer 42
+//	00:03:00:01:00:11:22:33:44:55 2001:db8:0:4200::/56
+//
+// - max_clients=<n>: maximum number of clients holding delegated prefixes. When a new client
+// would go over it, the prefixes of the least recently seen client are released. Clients only
+// holding reservations are not counted. Defaults to no limit
+//
+// Expired prefixes are released every few minutes, and clients left without prefixes forgotten.
+// The number of clients and prefixes is logged at debug level at that time.
+//
+// For example, to delegate prefixes between /56 and /64, and refuse any other size:
+//
+//	server6:
+//	  plugins:
+//	    - prefix: 2001:db8::/48 56 min=64 policy=refuse
 package prefix
 
-// FIXME: various settings will be hardcoded (default size, minimum size, lease times) pending a
-// better configuration system
+// FIXME: various settings will be hardcoded (default size, lease times) pending a better
+// configuration system
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,6 +74,12 @@ var Plugin = plugins.Plugin{
 
 const leaseDuration = 3600 * time.Second
 
+// Policies for hints outside of the configured window of prefix sizes
+const (
+	policyClamp  = "clamp"
+	policyRefuse = "refuse"
+)
+
 func setupPrefix(args ...string) (handler.Handler6, error) {
 	// - prefix: 2001:db8::/48 64
 	if len(args) < 2 {
@@ -60,21 +96,110 @@ func setupPrefix(args ...string) (handler.Handler6, error) {
 		return nil, fmt.Errorf("Invalid prefix length: %v", err)
 	}
 
+	h := &Handler{
+		Records: make(map[string][]lease),
+		pool:    prefix,
+		maxSize: allocSize,
+		minSize: 128,
+		policy:  policyClamp,
+		recent:  newRecency(),
+		lastGC:  time.Now(),
+	}
+	var reservations string
+	for _, arg := range args[2:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid argument %q, want key=value", arg)
+		}
+		switch key {
+		case "min":
+			h.minSize, err = strconv.Atoi(value)
+			if err != nil || h.minSize > 128 || h.minSize < allocSize {
+				return nil, fmt.Errorf("Invalid minimum prefix size %q, want a length between %d and 128", value, allocSize)
+			}
+		case "reservations":
+			reservations = value
+		case "max_clients":
+			h.maxClients, err = strconv.Atoi(value)
+			if err != nil || h.maxClients < 1 {
+				return nil, fmt.Errorf("Invalid max_clients %q, want a positive integer", value)
+			}
+		case "policy":
+			if value != policyClamp && value != policyRefuse {
+				return nil, fmt.Errorf("Invalid policy %q, want %s or %s", value, policyClamp, policyRefuse)
+			}
+			h.policy = value
+		default:
+			return nil, fmt.Errorf("Unknown argument %q", key)
+		}
+	}
+
 	// TODO: select allocators based on heuristics or user configuration
-	alloc, err := bitmap.NewBitmapAllocator(*prefix, allocSize)
+	h.allocator, err = bitmap.NewBitmapAllocator(*prefix, allocSize)
 	if err != nil {
 		return nil, fmt.Errorf("Could not initialize prefix allocator: %v", err)
 	}
 
-	return (&Handler{
-		Records:   make(map[string][]lease),
-		allocator: alloc,
-	}).Handle, nil
+	if reservations != "" {
+		if err := h.loadReservations(reservations); err != nil {
+			return nil, fmt.Errorf("Could not load reservations: %w", err)
+		}
+	}
+
+	plugins.ReportPool(h.poolStatus)
+	return h.Handle, nil
+}
+
+// loadReservations reads static delegations from a file, and records them as
+// leases of their owners after taking them out of the allocator
+func (h *Handler) loadReservations(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("malformed line, want 2 fields, got %d: %s", len(fields), line)
+		}
+		rawDUID, err := hex.DecodeString(strings.ReplaceAll(fields[0], ":", ""))
+		if err != nil {
+			return fmt.Errorf("malformed DUID %s: %w", fields[0], err)
+		}
+		duid, err := dhcpv6.DUIDFromBytes(rawDUID)
+		if err != nil {
+			return fmt.Errorf("malformed DUID %s: %w", fields[0], err)
+		}
+		_, prefix, err := net.ParseCIDR(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed prefix %s: %w", fields[1], err)
+		}
+		if size, bits := prefix.Mask.Size(); size != h.maxSize || bits != 128 {
+			return fmt.Errorf("reserved prefix %s must be an IPv6 /%d", prefix, h.maxSize)
+		}
+		allocated, err := h.allocator.Allocate(*prefix)
+		if err != nil || !samePrefix(&allocated, prefix) {
+			return fmt.Errorf("reserved prefix %s is outside of the pool or already reserved", prefix)
+		}
+		key := recordKey(duid)
+		h.Records[key] = append(h.Records[key], lease{Prefix: *prefix, Reserved: true})
+		count++
+	}
+	log.Infof("Loaded %d prefix reservations from %s", count, filename)
+	return nil
 }
 
 type lease struct {
 	Prefix net.IPNet
 	Expire time.Time
+	// Reserved leases are statically configured, and never go back to the
+	// allocator
+	Reserved bool
 }
 
 // Handler holds state of allocations for the plugin
@@ -85,7 +210,53 @@ type Handler struct {
 	// Records has a string'd []byte as key, because []byte can't be a key itself
 	// Since it's not valid utf-8 we can't use any other string function though
 	Records   map[string][]lease
+	pool      *net.IPNet
 	allocator allocators.Allocator
+	// maxSize and minSize are the lengths of the largest and smallest
+	// prefixes that can be delegated, and policy what to do with hints
+	// outside of that window
+	maxSize, minSize int
+	policy           string
+	// maxClients is the maximum number of clients with dynamic leases in
+	// Records, zero for no limit. recent orders them by last exchange.
+	maxClients int
+	recent     *recency
+	lastGC     time.Time
+}
+
+// applyWindow returns the hints to use for allocating prefixes, after
+// applying the window of allowed prefix sizes. Hints outside of the window are
+// either resized, or left out when they are refused.
+func (h *Handler) applyWindow(hints []*dhcpv6.OptIAPrefix) []*dhcpv6.OptIAPrefix {
+	ret := make([]*dhcpv6.OptIAPrefix, 0, len(hints))
+	for _, hint := range hints {
+		if hint.Prefix == nil {
+			ret = append(ret, hint)
+			continue
+		}
+		length, _ := hint.Prefix.Mask.Size()
+		if length == 0 || (length >= h.maxSize && length <= h.minSize) {
+			ret = append(ret, hint)
+			continue
+		}
+		if h.policy == policyRefuse {
+			log.Debugf("Refusing hint %s outside of the allowed sizes /%d-/%d", hint.Prefix, h.maxSize, h.minSize)
+			continue
+		}
+		if length < h.maxSize {
+			length = h.maxSize
+		} else {
+			length = h.minSize
+		}
+		// Don't modify the request, the hint belongs to it
+		clamped := *hint
+		clamped.Prefix = &net.IPNet{
+			IP:   hint.Prefix.IP.Mask(net.CIDRMask(length, 128)),
+			Mask: net.CIDRMask(length, 128),
+		}
+		ret = append(ret, &clamped)
+	}
+	return ret
 }
 
 // samePrefix returns true if both prefixes are defined and equal
@@ -115,6 +286,9 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		log.Error("Invalid packet received, no clientID")
 		return nil, true
 	}
+	// dry runs get the usual answer, without extending or keeping leases
+	md := handler.Metadata6(req)
+	dryRun := md != nil && md.DryRun
 
 	// Each request IA_PD requires an IA_PD response
 	for _, iapd := range msg.Options.IAPD() {
@@ -136,6 +310,7 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 			// which is equivalent to no hint
 			hints = []*dhcpv6.OptIAPrefix{{Prefix: &net.IPNet{}}}
 		}
+		hints = h.applyWindow(hints)
 
 		// Bitmap to track which requests are already satisfied or not
 		satisfied := bitset.New(uint(len(hints)))
@@ -143,6 +318,9 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// A possible simple optimization here would be to be able to lock single map values
 		// individually instead of the whole map, since we lock for some amount of time
 		h.Lock()
+		if now := time.Now(); now.Sub(h.lastGC) >= gcInterval {
+			h.collect(now)
+		}
 		knownLeases := h.Records[recordKey(client)]
 		// Bitmap to track which leases are already given in this exchange
 		givenOut := bitset.New(uint(len(knownLeases)))
@@ -156,13 +334,9 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		for hintIdx, h := range hints {
 			for leaseIdx := range knownLeases {
 				if samePrefix(h.Prefix, &knownLeases[leaseIdx].Prefix) {
-					expire := time.Now().Add(leaseDuration)
-					if knownLeases[leaseIdx].Expire.Before(expire) {
-						knownLeases[leaseIdx].Expire = expire
-					}
 					satisfied.Set(uint(hintIdx))
 					givenOut.Set(uint(leaseIdx))
-					addPrefix(iapdResp, knownLeases[leaseIdx])
+					addPrefix(iapdResp, extend(knownLeases, leaseIdx, dryRun))
 				}
 			}
 		}
@@ -171,7 +345,7 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// have already assigned to this client
 		for hintIdx, h := range hints {
 			if satisfied.Test(uint(hintIdx)) ||
-				(h.Prefix != nil && !h.Prefix.IP.Equal(net.IPv6zero)) {
+				(h.Prefix != nil && h.Prefix.IP != nil && !h.Prefix.IP.Equal(net.IPv6zero)) {
 				continue
 			}
 			for leaseIdx, l := range knownLeases {
@@ -187,13 +361,31 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 						continue
 					}
 				}
-				expire := time.Now().Add(leaseDuration)
-				if knownLeases[leaseIdx].Expire.Before(expire) {
-					knownLeases[leaseIdx].Expire = expire
+				satisfied.Set(uint(hintIdx))
+				givenOut.Set(uint(leaseIdx))
+				addPrefix(iapdResp, extend(knownLeases, leaseIdx, dryRun))
+			}
+		}
+
+		// Reservations are given out to their owner regardless of the hints,
+		// otherwise the client would be allocated another prefix instead.
+		// This includes requests whose hints were all refused by the policy.
+		slots := len(hints)
+		if slots == 0 {
+			slots = 1
+		}
+		for hintIdx := 0; hintIdx < slots; hintIdx++ {
+			if satisfied.Test(uint(hintIdx)) {
+				continue
+			}
+			for leaseIdx, l := range knownLeases {
+				if !l.Reserved || givenOut.Test(uint(leaseIdx)) {
+					continue
 				}
 				satisfied.Set(uint(hintIdx))
 				givenOut.Set(uint(leaseIdx))
-				addPrefix(iapdResp, knownLeases[leaseIdx])
+				addPrefix(iapdResp, extend(knownLeases, leaseIdx, dryRun))
+				break
 			}
 		}
 
@@ -228,13 +420,23 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 			}
 
 			addPrefix(iapdResp, l)
-			newLeases = append(knownLeases, l)
+			if dryRun {
+				// answer with the prefix, but leave it free
+				_ = h.allocator.Free(allocated)
+				continue
+			}
+			// Keep all the new leases, which must be released eventually
+			knownLeases = append(knownLeases, l)
+			newLeases = knownLeases
 			log.Debugf("Allocated %s to %s (IAID: %x)", &allocated, client, iapd.IaId)
 		}
 
 		if newLeases != nil {
 			h.Records[recordKey(client)] = newLeases
 		}
+		if !dryRun {
+			h.touch(recordKey(client))
+		}
 		h.Unlock()
 
 		if len(iapdResp.Options.Options) == 0 {
@@ -250,6 +452,19 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	return resp, false
 }
 
+// extend returns the lease at i given out again, expiring no sooner than a
+// full lease duration from now. The new expiry is only kept if not dryRun.
+func extend(leases []lease, i int, dryRun bool) lease {
+	l := leases[i]
+	if expire := time.Now().Add(leaseDuration); l.Expire.Before(expire) {
+		l.Expire = expire
+	}
+	if !dryRun {
+		leases[i] = l
+	}
+	return l
+}
+
 func addPrefix(resp *dhcpv6.OptIAPD, l lease) {
 	lifetime := time.Until(l.Expire)
 
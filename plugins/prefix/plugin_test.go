@@ -5,11 +5,19 @@
 package prefix
 
 import (
+	"encoding/hex"
 	"net"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	dhcpIana "github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRoundTrip(t *testing.T) {
@@ -91,3 +99,283 @@ func TestDup(t *testing.T) {
 		t.Fatalf("dup doesn't work: got %v expected %v", dupPrefix, prefix)
 	}
 }
+
+func TestSetupArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"2001:db8::/48", "56", "min=64", "policy=refuse"},
+		{"2001:db8::/48", "56", "policy=clamp"},
+		{"2001:db8::/48", "56", "max_clients=1000"},
+	} {
+		if _, err := setupPrefix(args...); err != nil {
+			t.Errorf("setup with %v failed: %v", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"2001:db8::/48", "56", "min=48"},
+		{"2001:db8::/48", "56", "min=129"},
+		{"2001:db8::/48", "56", "policy=round"},
+		{"2001:db8::/48", "56", "max=60"},
+		{"2001:db8::/48", "56", "64"},
+		{"2001:db8::/48", "56", "max_clients=0"},
+	} {
+		if _, err := setupPrefix(args...); err == nil {
+			t.Errorf("setup with %v did not fail", args)
+		}
+	}
+}
+
+// requestPrefix runs a request with a single hinted prefix length through
+// the handler, and returns the delegated prefix, or nil
+func requestPrefix(t *testing.T, h func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool), hintLen int) *net.IPNet {
+	req, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{
+		HWType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, byte(hintLen)},
+	}))
+	iapd := &dhcpv6.OptIAPD{IaId: [4]uint8{0, 0, 0, 1}}
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(hintLen, 128)}})
+	req.AddOption(iapd)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := h(req, resp)
+	iapds := result.(*dhcpv6.Message).Options.IAPD()
+	if len(iapds) != 1 {
+		t.Fatalf("expected exactly 1 IAPD, got %d", len(iapds))
+	}
+	prefixes := iapds[0].Options.Prefixes()
+	if len(prefixes) == 0 {
+		if status := iapds[0].Options.Status(); status == nil || status.StatusCode != dhcpIana.StatusNoPrefixAvail {
+			t.Errorf("no prefix delegated for /%d, but no NoPrefixAvail status either", hintLen)
+		}
+		return nil
+	}
+	return prefixes[0].Prefix
+}
+
+func TestWindow(t *testing.T) {
+	clamp, err := setupPrefix("2001:db8::/48", "56", "min=64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refuse, err := setupPrefix("2001:db8::/48", "56", "min=64", "policy=refuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		hint, clamped int
+		refused       bool
+	}{
+		{0, 56, false},
+		{48, 56, true},
+		{56, 56, false},
+		{60, 60, false},
+		{64, 64, false},
+		{72, 64, true},
+	} {
+		if p := requestPrefix(t, clamp, tc.hint); p == nil {
+			t.Errorf("clamp: nothing delegated for /%d", tc.hint)
+		} else if size, _ := p.Mask.Size(); size != tc.clamped {
+			t.Errorf("clamp: delegated %s for /%d, want a /%d", p, tc.hint, tc.clamped)
+		}
+		if p := requestPrefix(t, refuse, tc.hint); (p == nil) != tc.refused {
+			t.Errorf("refuse: delegated %v for /%d", p, tc.hint)
+		}
+	}
+}
+
+func TestReservations(t *testing.T) {
+	owner := &dhcpv6.DUIDLL{
+		HWType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}
+	reserved := "2001:db8:0:1::/64"
+	tmp, err := os.CreateTemp("", "test_plugin_prefix")
+	require.NoError(t, err)
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	_, err = tmp.WriteString("# comment\n" + hex.EncodeToString(owner.ToBytes()) + " " + reserved + "\n")
+	require.NoError(t, err)
+
+	// A pool with room for 2 prefixes only, one of them reserved
+	h, err := setupPrefix("2001:db8::/63", "64", "reservations="+tmp.Name())
+	require.NoError(t, err)
+	refuse, err := setupPrefix("2001:db8::/63", "64", "policy=refuse", "reservations="+tmp.Name())
+	require.NoError(t, err)
+
+	exchangeWith := func(h handler.Handler6, client dhcpv6.DUID, hint *net.IPNet) []*dhcpv6.OptIAPrefix {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.AddOption(dhcpv6.OptClientID(client))
+		iapd := &dhcpv6.OptIAPD{IaId: [4]uint8{0, 0, 0, 1}}
+		if hint != nil {
+			iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: hint})
+		}
+		req.AddOption(iapd)
+		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, err)
+		result, _ := h(req, resp)
+		return result.(*dhcpv6.Message).Options.IAPD()[0].Options.Prefixes()
+	}
+	exchange := func(client dhcpv6.DUID, hint *net.IPNet) []*dhcpv6.OptIAPrefix {
+		return exchangeWith(h, client, hint)
+	}
+
+	// The owner gets its reservation, even when hinting at another prefix
+	_, other, _ := net.ParseCIDR("2001:db8::/64")
+	for _, hint := range []*net.IPNet{nil, other} {
+		prefixes := exchange(owner, hint)
+		if assert.Len(t, prefixes, 1) {
+			assert.Equal(t, reserved, prefixes[0].Prefix.String())
+		}
+	}
+
+	// Even when the policy refuses its only hint
+	_, tooLarge, _ := net.ParseCIDR("2001:db8::/48")
+	prefixes := exchangeWith(refuse, owner, tooLarge)
+	if assert.Len(t, prefixes, 1) {
+		assert.Equal(t, reserved, prefixes[0].Prefix.String())
+	}
+
+	// Other clients never get it
+	for i := byte(0); i < 2; i++ {
+		client := &dhcpv6.DUIDLL{
+			HWType:        dhcpIana.HWTypeEthernet,
+			LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, i},
+		}
+		for _, p := range exchange(client, nil) {
+			assert.NotEqual(t, reserved, p.Prefix.String())
+		}
+	}
+}
+
+func TestReservationsErrors(t *testing.T) {
+	duid := "00030001001122334455"
+	for _, content := range []string{
+		duid + "\n",
+		"zz 2001:db8::/64\n",
+		"0003 2001:db8::/64\n",
+		duid + " 2001:db8::/56\n",
+		duid + " 2001:db9::/64\n",
+		duid + " 2001:db8::/64\n" + duid + " 2001:db8::/64\n",
+	} {
+		tmp, err := os.CreateTemp("", "test_plugin_prefix")
+		require.NoError(t, err)
+		_, err = tmp.WriteString(content)
+		require.NoError(t, err)
+		tmp.Close()
+		_, err = setupPrefix("2001:db8::/48", "64", "reservations="+tmp.Name())
+		assert.Error(t, err, "reservations %q", content)
+		os.Remove(tmp.Name())
+	}
+}
+
+func TestRecordsCleanup(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("2001:db8::/62")
+	allocator, err := bitmap.NewBitmapAllocator(*pool, 64)
+	require.NoError(t, err)
+	h := &Handler{
+		Records:    make(map[string][]lease),
+		pool:       pool,
+		allocator:  allocator,
+		maxSize:    64,
+		minSize:    128,
+		policy:     policyClamp,
+		maxClients: 2,
+		recent:     newRecency(),
+		lastGC:     time.Now(),
+	}
+
+	clients := make([]dhcpv6.DUID, 3)
+	for i := range clients {
+		clients[i] = &dhcpv6.DUIDLL{
+			HWType:        dhcpIana.HWTypeEthernet,
+			LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, byte(i)},
+		}
+	}
+	exchange := func(client dhcpv6.DUID) []*dhcpv6.OptIAPrefix {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.AddOption(dhcpv6.OptClientID(client))
+		req.AddOption(&dhcpv6.OptIAPD{IaId: [4]uint8{0, 0, 0, 1}})
+		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, err)
+		result, _ := h.Handle(req, resp)
+		return result.(*dhcpv6.Message).Options.IAPD()[0].Options.Prefixes()
+	}
+
+	// The least recently seen client is evicted by the third one
+	for _, c := range []dhcpv6.DUID{clients[0], clients[1], clients[0], clients[2]} {
+		require.Len(t, exchange(c), 1)
+	}
+	assert.Len(t, h.Records, 2)
+	assert.Contains(t, h.Records, recordKey(clients[0]))
+	assert.NotContains(t, h.Records, recordKey(clients[1]))
+	assert.Equal(t, plugins.PoolStatus{Plugin: "prefix", Range: "2001:db8::/62", Size: 4, Used: 2, Clients: 2}, h.poolStatus())
+
+	// Expired leases are released, and their clients forgotten
+	for key := range h.Records {
+		h.Records[key][0].Expire = time.Now().Add(-time.Second)
+	}
+	h.collect(time.Now())
+	assert.Empty(t, h.Records)
+	assert.Equal(t, 0, h.recent.len())
+	for i := 0; i < 4; i++ {
+		_, err := allocator.Allocate(net.IPNet{})
+		assert.NoError(t, err, "allocation %d after release", i)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("2001:db8::/62")
+	allocator, err := bitmap.NewBitmapAllocator(*pool, 64)
+	require.NoError(t, err)
+	h := &Handler{
+		Records:   make(map[string][]lease),
+		pool:      pool,
+		allocator: allocator,
+		maxSize:   64,
+		minSize:   128,
+		policy:    policyClamp,
+		recent:    newRecency(),
+		lastGC:    time.Now(),
+	}
+	client := &dhcpv6.DUIDLL{
+		HWType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}
+	exchange := func(md *handler.Metadata) []*dhcpv6.OptIAPrefix {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.AddOption(dhcpv6.OptClientID(client))
+		req.AddOption(&dhcpv6.OptIAPD{IaId: [4]uint8{0, 0, 0, 1}})
+		resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+		require.NoError(t, err)
+		handler.SetMetadata6(req, md)
+		defer handler.ClearMetadata6(req)
+		result, _ := h.Handle(req, resp)
+		return result.(*dhcpv6.Message).Options.IAPD()[0].Options.Prefixes()
+	}
+
+	// A dry run is answered, but keeps neither the prefix nor the client
+	for i := 0; i < 2; i++ {
+		prefixes := exchange(&handler.Metadata{DryRun: true})
+		require.Len(t, prefixes, 1)
+		assert.Equal(t, "2001:db8::/64", prefixes[0].Prefix.String())
+	}
+	assert.Empty(t, h.Records)
+	assert.Equal(t, 0, h.recent.len())
+
+	// Nor does it extend the lease of a known client
+	require.Len(t, exchange(&handler.Metadata{}), 1)
+	expire := time.Now().Add(time.Minute)
+	h.Records[recordKey(client)][0].Expire = expire
+	require.Len(t, exchange(&handler.Metadata{DryRun: true}), 1)
+	assert.Equal(t, expire, h.Records[recordKey(client)][0].Expire)
+}
@@ -0,0 +1,154 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"container/list"
+	"math"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// gcInterval is how often expired leases are released
+const gcInterval = 5 * time.Minute
+
+// recency orders record keys from the most to the least recently used
+type recency struct {
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newRecency() *recency {
+	return &recency{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+// use marks key as the most recently used
+func (r *recency) use(key string) {
+	if e, ok := r.elems[key]; ok {
+		r.order.MoveToFront(e)
+		return
+	}
+	r.elems[key] = r.order.PushFront(key)
+}
+
+func (r *recency) remove(key string) {
+	if e, ok := r.elems[key]; ok {
+		r.order.Remove(e)
+		delete(r.elems, key)
+	}
+}
+
+// oldest returns the least recently used key, other than except
+func (r *recency) oldest(except string) (string, bool) {
+	for e := r.order.Back(); e != nil; e = e.Prev() {
+		if key := e.Value.(string); key != except {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (r *recency) len() int {
+	return r.order.Len()
+}
+
+// dynamic returns whether some of leases come from the allocator
+func dynamic(leases []lease) bool {
+	for _, l := range leases {
+		if !l.Reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// touch records an exchange with the client of the given key, evicting the
+// least recently seen clients if that makes too many of them. Must be called
+// with the lock held.
+func (h *Handler) touch(key string) {
+	if !dynamic(h.Records[key]) {
+		return
+	}
+	h.recent.use(key)
+	for h.maxClients > 0 && h.recent.len() > h.maxClients {
+		oldest, ok := h.recent.oldest(key)
+		if !ok {
+			break
+		}
+		log.Infof("Releasing the prefixes of client %x, over the limit of %d clients", oldest, h.maxClients)
+		h.release(oldest, func(lease) bool { return true })
+	}
+}
+
+// collect releases the expired leases, and forgets clients without leases.
+// Must be called with the lock held.
+func (h *Handler) collect(now time.Time) {
+	released, forgotten := 0, len(h.Records)
+	for key := range h.Records {
+		released += h.release(key, func(l lease) bool { return !now.Before(l.Expire) })
+	}
+	forgotten -= len(h.Records)
+	h.lastGC = now
+
+	log.Debugf("%d clients holding %d prefixes, released %d expired prefixes and forgot %d clients",
+		len(h.Records), h.prefixes(), released, forgotten)
+}
+
+// prefixes returns the number of prefixes leased. Must be called with the lock
+// held.
+func (h *Handler) prefixes() int {
+	count := 0
+	for _, leases := range h.Records {
+		count += len(leases)
+	}
+	return count
+}
+
+// poolStatus returns the utilization of the pool. Size counts the prefixes of
+// the largest delegated length, while Used counts the leased prefixes of any
+// length, reserved ones included.
+func (h *Handler) poolStatus() plugins.PoolStatus {
+	h.Lock()
+	defer h.Unlock()
+	status := plugins.PoolStatus{
+		Plugin:  "prefix",
+		Range:   h.pool.String(),
+		Size:    math.MaxUint64,
+		Used:    uint64(h.prefixes()),
+		Clients: uint64(len(h.Records)),
+	}
+	if poolSize, _ := h.pool.Mask.Size(); h.maxSize-poolSize < 64 {
+		status.Size = 1 << (h.maxSize - poolSize)
+	}
+	return status
+}
+
+// release gives the dynamic leases of the client of the given key matching
+// expired back to the allocator, and returns how many there were. The client
+// is forgotten if it has no leases left. Must be called with the lock held.
+func (h *Handler) release(key string, expired func(lease) bool) int {
+	var kept []lease
+	released := 0
+	for _, l := range h.Records[key] {
+		if l.Reserved || !expired(l) {
+			kept = append(kept, l)
+			continue
+		}
+		if err := h.allocator.Free(l.Prefix); err != nil {
+			log.Warningf("Could not release prefix %s: %v", &l.Prefix, err)
+		}
+		released++
+	}
+	if !dynamic(kept) {
+		h.recent.remove(key)
+	}
+	if len(kept) == 0 {
+		delete(h.Records, key)
+	} else {
+		h.Records[key] = kept
+	}
+	return released
+}
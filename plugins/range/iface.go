@@ -0,0 +1,119 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+)
+
+// ifaceRange derives the range of a plugin instance from the address of an
+// interface, for appliances whose addressing is configured by their users.
+// The start and end of the range only give the host part of the addresses,
+// the network part comes from the interface.
+type ifaceRange struct {
+	name       string
+	start, end uint32
+	// addr returns the IPv4 address and prefix of the interface, or nil if
+	// it has none
+	addr func() (*net.IPNet, error)
+	// current is the address the range was last derived from
+	current *net.IPNet
+}
+
+// interfaceAddr returns the first IPv4 address of the interface name, or nil
+// if it has none
+func interfaceAddr(name string) (*net.IPNet, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return &net.IPNet{IP: ipnet.IP.To4(), Mask: ipnet.Mask[len(ipnet.Mask)-net.IPv4len:]}, nil
+		}
+	}
+	return nil, nil
+}
+
+// deriveRange returns the range of addresses made of the network part of
+// addr and the host parts of start and end
+func deriveRange(addr *net.IPNet, start, end uint32) (net.IP, net.IP, error) {
+	ones, _ := addr.Mask.Size()
+	hostMask := uint32(1)<<(32-ones) - 1
+	if start&hostMask != start || end&hostMask != end {
+		return nil, nil, fmt.Errorf("range .%d-.%d does not fit in %s", start, end, addr)
+	}
+	network := binary.BigEndian.Uint32(addr.IP.To4()) &^ hostMask
+	from, to := make(net.IP, net.IPv4len), make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(from, network|start)
+	binary.BigEndian.PutUint32(to, network|end)
+	return from, to, nil
+}
+
+// hostPart returns the host part of ip as a number, ip being given with a
+// zero network part such as 0.0.0.100
+func hostPart(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// refresh derives the range again from the address of the interface, and
+// starts serving it if it changed. Leases outside of the new range are
+// dropped, their clients get a new address on their next request.
+func (p *PluginState) refresh() {
+	addr, err := p.iface.addr()
+	if err != nil {
+		log.Errorf("Cannot get the address of interface %s: %v", p.iface.name, err)
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if addr.String() == p.iface.current.String() {
+		return
+	}
+	p.iface.current = addr
+	if addr == nil {
+		log.Warningf("Interface %s has no IPv4 address, not leasing addresses", p.iface.name)
+		p.allocator, p.start, p.end = nil, nil, nil
+		return
+	}
+	start, end, err := deriveRange(addr, p.iface.start, p.iface.end)
+	if err != nil {
+		log.Errorf("Not leasing addresses on interface %s: %v", p.iface.name, err)
+		p.allocator, p.start, p.end = nil, nil, nil
+		return
+	}
+	allocator, err := bitmap.NewIPv4Allocator(start, end)
+	if err != nil {
+		log.Errorf("Could not create an allocator for %s-%s: %v", start, end, err)
+		p.allocator, p.start, p.end = nil, nil, nil
+		return
+	}
+	// the server address is not for clients
+	_, _ = allocator.Allocate(net.IPNet{IP: addr.IP})
+	dropped := 0
+	for mac, record := range p.Recordsv4 {
+		if ip, err := allocator.Allocate(net.IPNet{IP: record.IP}); err == nil && ip.IP.Equal(record.IP) {
+			continue
+		} else if err == nil {
+			_ = allocator.Free(ip)
+		}
+		if err := p.deleteIPAddress(mac); err != nil {
+			log.Warningf("Could not delete lease of %s from storage: %v", mac, err)
+		}
+		delete(p.Recordsv4, mac)
+		dropped++
+	}
+	p.allocator, p.start, p.end = allocator, start, end
+	log.Infof("Leasing %s-%s from the address %s of interface %s, dropped %d leases outside of it",
+		start, end, addr, p.iface.name, dropped)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// rtmgrpIPv4IfAddr is the netlink multicast group of IPv4 address changes,
+// missing from the syscall package
+const rtmgrpIPv4IfAddr = 0x10
+
+// watchIdleCheck is how often the netlink socket is checked for subscribers
+// while no address changes
+var watchIdleCheck = syscall.Timeval{Sec: 1}
+
+// addressWatcher shares one netlink subscription to address changes between
+// the instances of the plugin. The socket is opened by the first subscriber,
+// and closed once there are none left.
+type addressWatcher struct {
+	mu sync.Mutex
+	// subscribers is nil while there is no socket
+	subscribers map[int]func()
+	next        int
+}
+
+var watcher addressWatcher
+
+// watchAddresses calls changed every time an IPv4 address is added or
+// removed on the system, until stop is called or the netlink socket fails
+func watchAddresses(changed func()) (stop func(), err error) {
+	w := &watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subscribers == nil {
+		fd, err := subscribeAddresses()
+		if err != nil {
+			return nil, err
+		}
+		w.subscribers = make(map[int]func())
+		go w.run(fd)
+	}
+	id := w.next
+	w.next++
+	w.subscribers[id] = changed
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers, id)
+	}, nil
+}
+
+// subscribeAddresses opens a netlink socket receiving the IPv4 address
+// changes
+func subscribeAddresses() (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return -1, fmt.Errorf("cannot open netlink socket: %w", err)
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpIPv4IfAddr}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("cannot subscribe to address changes: %w", err)
+	}
+	// wake up regularly, to notice when there are no subscribers left
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &watchIdleCheck); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("cannot set a timeout on netlink socket: %w", err)
+	}
+	return fd, nil
+}
+
+// run reads the address changes from fd and notifies the subscribers, until
+// there are none left
+func (w *addressWatcher) run(fd int) {
+	defer syscall.Close(fd)
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		} else if errors.Is(err, syscall.EAGAIN) {
+			if w.idle() {
+				return
+			}
+			continue
+		} else if err != nil {
+			log.Errorf("Stopped watching address changes: %v", err)
+			w.mu.Lock()
+			w.subscribers = nil
+			w.mu.Unlock()
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			if m.Header.Type == syscall.RTM_NEWADDR || m.Header.Type == syscall.RTM_DELADDR {
+				w.notify()
+				break
+			}
+		}
+		if w.idle() {
+			return
+		}
+	}
+}
+
+// idle returns whether there are no subscribers left, and if so forgets the
+// socket so that the next subscriber opens a new one
+func (w *addressWatcher) idle() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.subscribers) > 0 {
+		return false
+	}
+	w.subscribers = nil
+	return true
+}
+
+// notify calls the subscribers, without holding the lock as they may take
+// a while to derive their range again
+func (w *addressWatcher) notify() {
+	w.mu.Lock()
+	changed := make([]func(), 0, len(w.subscribers))
+	for _, f := range w.subscribers {
+		changed = append(changed, f)
+	}
+	w.mu.Unlock()
+	for _, f := range changed {
+		f()
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchAddressesShared(t *testing.T) {
+	saved := watchIdleCheck
+	watchIdleCheck = syscall.Timeval{Usec: 10000}
+	t.Cleanup(func() { watchIdleCheck = saved })
+
+	stop1, err := watchAddresses(func() {})
+	if err != nil {
+		t.Skipf("cannot subscribe to address changes: %v", err)
+	}
+	stop2, err := watchAddresses(func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both watches share the socket
+	watcher.mu.Lock()
+	if len(watcher.subscribers) != 2 {
+		t.Errorf("got %d subscribers, want 2", len(watcher.subscribers))
+	}
+	watcher.mu.Unlock()
+
+	stop1()
+	stop2()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		watcher.mu.Lock()
+		closed := watcher.subscribers == nil
+		watcher.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription still open without subscribers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !linux
+
+package rangeplugin
+
+import "errors"
+
+// watchAddresses is only implemented with netlink, on Linux
+func watchAddresses(changed func()) (stop func(), err error) {
+	return nil, errors.New("deriving the range from an interface is not supported on this system")
+}
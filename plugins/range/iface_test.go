@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ipNet(s string) *net.IPNet {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	ipnet.IP = ip.To4()
+	return ipnet
+}
+
+func TestDeriveRange(t *testing.T) {
+	start, end, err := deriveRange(ipNet("192.168.7.1/24"), 100, 200)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.7.100", start.String())
+	assert.Equal(t, "192.168.7.200", end.String())
+
+	start, end, err = deriveRange(ipNet("10.1.2.3/16"), 256, 511)
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.1.0", start.String())
+	assert.Equal(t, "10.1.1.255", end.String())
+
+	_, _, err = deriveRange(ipNet("192.168.7.1/25"), 100, 200)
+	assert.Error(t, err, "range larger than the subnet")
+}
+
+func TestRefresh(t *testing.T) {
+	addr := ipNet("192.168.7.1/24")
+	p := PluginState{
+		Recordsv4: make(map[string]*Record),
+		LeaseTime: time.Hour,
+		iface: &ifaceRange{
+			name:  "lan0",
+			start: hostPart(net.IPv4(0, 0, 0, 1)),
+			end:   hostPart(net.IPv4(0, 0, 0, 3)),
+			addr:  func() (*net.IPNet, error) { return addr, nil },
+		},
+	}
+	require.NoError(t, p.registerBackingDB(":memory:"))
+	request := func(mac byte) net.IP {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, mac})
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ = p.Handler4(req, resp)
+		if resp == nil {
+			return nil
+		}
+		return resp.YourIPAddr
+	}
+
+	p.refresh()
+	first := request(1)
+	assert.True(t, addr.Contains(first), "leased %s outside of %s", first, addr)
+	assert.False(t, first.Equal(addr.IP), "leased the address of the interface")
+	request(2)
+	assert.Nil(t, request(3), "range is full")
+
+	// The same subnet with another interface address keeps the leases that
+	// do not conflict with it
+	addr = ipNet("192.168.7.2/24")
+	p.refresh()
+	assert.Len(t, p.Recordsv4, 1)
+
+	addr = nil
+	p.refresh()
+	assert.Nil(t, request(1), "leased without an interface address")
+
+	addr = ipNet("10.0.0.254/24")
+	p.refresh()
+	assert.Empty(t, p.Recordsv4, "leases of the old subnet were kept")
+	assert.True(t, addr.Contains(request(1)), "did not lease in the new subnet")
+	stored, err := loadRecords(p.leasedb)
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+}
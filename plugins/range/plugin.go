@@ -7,12 +7,15 @@ package rangeplugin
 import (
 	"database/sql"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
@@ -25,8 +28,9 @@ var log = logger.GetLogger("plugins/range")
 
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "range",
-	Setup4: setupRange,
+	Name:              "range",
+	Setup4WithOptions: setup4,
+	MinCoreAPI:        2,
 }
 
 //Record holds an IP lease record
@@ -45,14 +49,160 @@ type PluginState struct {
 	LeaseTime time.Duration
 	leasedb   *sql.DB
 	allocator allocators.Allocator
+	// start and end are the bounds of the range of the allocator
+	start, end net.IP
+	// hostnameTemplate is used to synthesize the hostname of clients that
+	// don't send one, if not empty
+	hostnameTemplate string
+	// pool is recorded in the request metadata when allocating, if not empty
+	pool string
+	// clock is the time of the last request, with its monotonic reading
+	clock time.Time
+	// unsaved holds the leases that could not be written to storage, by MAC.
+	// The plugin is in degraded mode while it is not empty.
+	unsaved           map[string]*Record
+	degradedLeaseTime time.Duration
+	lastAlert         time.Time
+	// iface is set when the range is derived from the address of an
+	// interface. The allocator is nil while the interface has no usable
+	// address.
+	iface *ifaceRange
+}
+
+// defaultDegradedLeaseTime is the lease time given while storage is failing
+const defaultDegradedLeaseTime = 5 * time.Minute
+
+// clockJumpThreshold is how far the system clock may drift from the monotonic
+// clock before it is considered to have been stepped, for example by NTP at
+// boot on devices without a battery-backed clock
+const clockJumpThreshold = time.Minute
+
+// checkClock detects steps of the system clock since the last request, and
+// shifts the expiry of all leases accordingly. Must be called with the lock
+// held.
+func (p *PluginState) checkClock(now time.Time) {
+	if !p.clock.IsZero() {
+		// Round(0) strips the monotonic reading, to compare wall clocks
+		jump := now.Round(0).Sub(p.clock.Round(0)) - now.Sub(p.clock)
+		if jump > clockJumpThreshold || jump < -clockJumpThreshold {
+			log.Warningf("System clock jumped by %s, adjusting lease expiries", jump.Round(time.Second))
+			p.shiftExpiries(jump)
+		}
+	}
+	p.clock = now
+}
+
+// shiftExpiries moves the expiry of every lease by d, so that their remaining
+// duration is unchanged after the system clock moved by d
+func (p *PluginState) shiftExpiries(d time.Duration) {
+	for mac, record := range p.Recordsv4 {
+		record.expires += int(d / time.Second)
+		p.persist(mac, record)
+	}
+}
+
+// reconcileExpiries caps the expiry of leases that end further in the future
+// than a lease given now would, which happens after the clock was stepped
+// back while the server was not running. Leases in the past need no special
+// handling, as they are extended on the next request.
+func (p *PluginState) reconcileExpiries(now time.Time) int {
+	limit := now.Add(p.LeaseTime + clockJumpThreshold).Unix()
+	capped := 0
+	for mac, record := range p.Recordsv4 {
+		if int64(record.expires) > limit {
+			record.expires = int(now.Add(p.LeaseTime).Unix())
+			p.persist(mac, record)
+			capped++
+		}
+	}
+	return capped
+}
+
+// storageAlertInterval is how often failing storage is reported
+const storageAlertInterval = time.Minute
+
+// persist saves a record. When storage fails, the plugin switches to a
+// degraded mode: leases are served from memory with a shorter lease time, and
+// the unsaved ones are written again once storage works. Must be called with
+// the lock held.
+func (p *PluginState) persist(mac string, record *Record) {
+	err := p.save(mac, record)
+	if err != nil {
+		if len(p.unsaved) == 0 {
+			log.Errorf("Lease storage failed, serving leases from memory with a lease time of %s until it recovers: %v", p.leaseTimeDegraded(), err)
+			p.lastAlert = time.Now()
+		} else if time.Since(p.lastAlert) >= storageAlertInterval {
+			log.Errorf("Lease storage still failing, %d leases would be lost on restart: %v", len(p.unsaved)+1, err)
+			p.lastAlert = time.Now()
+		}
+		if p.unsaved == nil {
+			p.unsaved = make(map[string]*Record)
+		}
+		p.unsaved[mac] = record
+		return
+	}
+	if len(p.unsaved) == 0 {
+		return
+	}
+	delete(p.unsaved, mac)
+	for m, r := range p.unsaved {
+		if err := p.save(m, r); err != nil {
+			return
+		}
+		delete(p.unsaved, m)
+	}
+	log.Infof("Lease storage recovered, leaving degraded mode")
+}
+
+// save writes a record to storage
+func (p *PluginState) save(mac string, record *Record) error {
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	return p.saveIPAddress(hwaddr, record)
+}
+
+// leaseTime returns the lease time to give, which is shorter while storage is
+// failing so that clients come back soon after it recovers
+func (p *PluginState) leaseTime() time.Duration {
+	if len(p.unsaved) > 0 {
+		return p.leaseTimeDegraded()
+	}
+	return p.LeaseTime
+}
+
+func (p *PluginState) leaseTimeDegraded() time.Duration {
+	if p.degradedLeaseTime < p.LeaseTime {
+		return p.degradedLeaseTime
+	}
+	return p.LeaseTime
+}
+
+// leaseHostname returns the hostname to store on the lease of ip for req:
+// the one sent by the client, or the one derived from the template
+func (p *PluginState) leaseHostname(req *dhcpv4.DHCPv4, ip net.IP) string {
+	if hostname := req.HostName(); hostname != "" || p.hostnameTemplate == "" {
+		return hostname
+	}
+	return strings.NewReplacer(
+		"{ip}", strings.ReplaceAll(ip.String(), ".", "-"),
+		"{mac}", hex.EncodeToString(req.ClientHWAddr),
+	).Replace(p.hostnameTemplate)
 }
 
 // Handler4 handles DHCPv4 packets for the range plugin
 func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	p.Lock()
 	defer p.Unlock()
+	if p.allocator == nil {
+		log.Warningf("No address to lease on interface %s, dropping request from %s", p.iface.name, req.ClientHWAddr)
+		return nil, true
+	}
+	p.checkClock(time.Now())
+	leaseTime := p.leaseTime()
+	md := handler.Metadata4(req)
 	record, ok := p.Recordsv4[req.ClientHWAddr.String()]
-	hostname := req.HostName()
 	if !ok {
 		// Allocating new address since there isn't one allocated
 		log.Printf("MAC address %s is new, leasing new IPv4 address", req.ClientHWAddr.String())
@@ -62,35 +212,72 @@ func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
 			return nil, true
 		}
 		rec := Record{
-			IP:      ip.IP.To4(),
-			expires: int(time.Now().Add(p.LeaseTime).Unix()),
-			hostname: hostname,
+			IP:       ip.IP.To4(),
+			expires:  int(time.Now().Add(leaseTime).Unix()),
+			hostname: p.leaseHostname(req, ip.IP.To4()),
 		}
-		err = p.saveIPAddress(req.ClientHWAddr, &rec)
-		if err != nil {
-			log.Errorf("SaveIPAddress for MAC %s failed: %v", req.ClientHWAddr.String(), err)
+		if md != nil && md.DryRun {
+			// answer with the address, but leave it free
+			_ = p.allocator.Free(ip)
+		} else {
+			p.persist(req.ClientHWAddr.String(), &rec)
+			p.Recordsv4[req.ClientHWAddr.String()] = &rec
 		}
-		p.Recordsv4[req.ClientHWAddr.String()] = &rec
 		record = &rec
-	} else {
+	} else if md == nil || !md.DryRun {
 		// Ensure we extend the existing lease at least past when the one we're giving expires
 		expiry := time.Unix(int64(record.expires), 0)
-		if expiry.Before(time.Now().Add(p.LeaseTime)) {
-			record.expires = int(time.Now().Add(p.LeaseTime).Round(time.Second).Unix())
-			record.hostname = hostname
-			err := p.saveIPAddress(req.ClientHWAddr, record)
-			if err != nil {
-				log.Errorf("Could not persist lease for MAC %s: %v", req.ClientHWAddr.String(), err)
-			}
+		if expiry.Before(time.Now().Add(leaseTime)) {
+			record.expires = int(time.Now().Add(leaseTime).Round(time.Second).Unix())
+			record.hostname = p.leaseHostname(req, record.IP)
+			p.persist(req.ClientHWAddr.String(), record)
 		}
 	}
 	resp.YourIPAddr = record.IP
-	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.LeaseTime.Round(time.Second)))
+	if md != nil && p.pool != "" {
+		md.Pool = p.pool
+	}
+	// storage may have failed or recovered while saving this lease
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.leaseTime().Round(time.Second)))
 	log.Printf("found IP address %s for MAC %s", record.IP, req.ClientHWAddr.String())
 	return resp, false
 }
 
-func setupRange(args ...string) (handler.Handler4, error) {
+// poolStatus returns the utilization of the range. Every lease counts, as
+// they are kept after they expire for clients to get the same address again.
+func (p *PluginState) poolStatus() plugins.PoolStatus {
+	p.Lock()
+	defer p.Unlock()
+	status := plugins.PoolStatus{Plugin: "range", Name: p.pool, Used: uint64(len(p.Recordsv4))}
+	if p.allocator != nil {
+		status.Range = fmt.Sprintf("%s-%s", p.start, p.end)
+		status.Size = uint64(binary.BigEndian.Uint32(p.end) - binary.BigEndian.Uint32(p.start) + 1)
+	}
+	return status
+}
+
+// setup4 takes the lease file, start IP, end IP and lease time, followed
+// by optional key=value arguments:
+// - hostname=<template>: hostname stored on the leases of clients that don't
+// send one. {ip} is replaced by the leased address with dashes instead of
+// dots, and {mac} by the client hardware address in hexadecimal, so that
+// "dhcp-{ip}" gives "dhcp-10-0-0-5"
+// - pool=<name>: name of the pool, recorded in the request metadata so that
+// the plugins after this one can apply pool-specific settings
+// - degraded_lease_time=<duration>: lease time given while the lease file
+// cannot be written to, 5m by default
+// - interface=<name>: derive the range from the IPv4 address of the interface,
+// following its changes. The start and end IPs then only give the host part
+// of the range, so that "0.0.0.100 0.0.0.200" serves .100 to .200 of whatever
+// subnet the interface is in. Leases outside of a new range are dropped.
+func setup4(cfg config.PluginConfig, deps plugins.Deps) (handler.Handler4, error) {
+	return setupRange(deps, cfg.Args...)
+}
+
+// setupRange sets up an instance of the plugin with the arguments of setup4.
+// Without deps.OnUnload, an instance derived from an interface follows its
+// changes for as long as the process runs.
+func setupRange(deps plugins.Deps, args ...string) (handler.Handler4, error) {
 	var (
 		err error
 		p   PluginState
@@ -115,16 +302,56 @@ func setupRange(args ...string) (handler.Handler4, error) {
 		return nil, errors.New("start of IP range has to be lower than the end of an IP range")
 	}
 
-	p.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
-	if err != nil {
-		return nil, fmt.Errorf("could not create an allocator: %w", err)
-	}
-
 	p.LeaseTime, err = time.ParseDuration(args[3])
 	if err != nil {
 		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
 	}
 
+	p.degradedLeaseTime = defaultDegradedLeaseTime
+	for _, arg := range args[4:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid argument %q, want key=value", arg)
+		}
+		switch key {
+		case "hostname":
+			if value == "" {
+				return nil, errors.New("hostname template cannot be empty")
+			}
+			p.hostnameTemplate = value
+		case "pool":
+			if value == "" {
+				return nil, errors.New("pool name cannot be empty")
+			}
+			p.pool = value
+		case "degraded_lease_time":
+			p.degradedLeaseTime, err = time.ParseDuration(value)
+			if err != nil || p.degradedLeaseTime <= 0 {
+				return nil, fmt.Errorf("invalid degraded lease time: %v", value)
+			}
+		case "interface":
+			if value == "" {
+				return nil, errors.New("interface name cannot be empty")
+			}
+			p.iface = &ifaceRange{
+				name:  value,
+				start: hostPart(ipRangeStart),
+				end:   hostPart(ipRangeEnd),
+				addr:  func() (*net.IPNet, error) { return interfaceAddr(value) },
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+
+	if p.iface == nil {
+		p.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
+		if err != nil {
+			return nil, fmt.Errorf("could not create an allocator: %w", err)
+		}
+		p.start, p.end = ipRangeStart.To4(), ipRangeEnd.To4()
+	}
+
 	if err := p.registerBackingDB(filename); err != nil {
 		return nil, fmt.Errorf("could not setup lease storage: %w", err)
 	}
@@ -134,6 +361,25 @@ func setupRange(args ...string) (handler.Handler4, error) {
 	}
 
 	log.Printf("Loaded %d DHCPv4 leases from %s", len(p.Recordsv4), filename)
+	if n := p.reconcileExpiries(time.Now()); n > 0 {
+		log.Warningf("Shortened %d leases expiring further than the lease time, the system clock may have been stepped back", n)
+	}
+
+	if p.iface != nil {
+		stop, err := watchAddresses(p.refresh)
+		if err != nil {
+			return nil, err
+		}
+		if deps.OnUnload != nil {
+			deps.OnUnload(stop)
+		}
+		p.refresh()
+		if p.allocator == nil {
+			log.Warningf("No range to lease on interface %s yet, waiting for an IPv4 address", p.iface.name)
+		}
+		plugins.ReportPool(p.poolStatus)
+		return p.Handler4, nil
+	}
 
 	for _, v := range p.Recordsv4 {
 		ip, err := p.allocator.Allocate(net.IPNet{IP: v.IP})
@@ -145,5 +391,6 @@ func setupRange(args ...string) (handler.Handler4, error) {
 		}
 	}
 
+	plugins.ReportPool(p.poolStatus)
 	return p.Handler4, nil
 }
@@ -0,0 +1,210 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseHostname(t *testing.T) {
+	hwaddr := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x2a}
+	ip := net.IPv4(10, 0, 0, 5).To4()
+	anonymous, err := dhcpv4.NewDiscovery(hwaddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := dhcpv4.NewDiscovery(hwaddr, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := PluginState{}
+	assert.Equal(t, "", p.leaseHostname(anonymous, ip), "no template")
+	p.hostnameTemplate = "dhcp-{ip}"
+	assert.Equal(t, "dhcp-10-0-0-5", p.leaseHostname(anonymous, ip))
+	assert.Equal(t, "laptop", p.leaseHostname(named, ip), "client hostname takes precedence")
+	p.hostnameTemplate = "host-{mac}"
+	assert.Equal(t, "host-02000000002a", p.leaseHostname(anonymous, ip))
+}
+
+func TestSetupArgs(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	base := []string{leases, "10.0.0.1", "10.0.0.10", "1h"}
+
+	_, err := setupRange(plugins.Deps{}, append(base, "hostname=dhcp-{ip}", "pool=guests")...)
+	assert.NoError(t, err)
+	for _, arg := range []string{"hostname=", "hostname", "pool=", "interface=", "unknown=1"} {
+		_, err := setupRange(plugins.Deps{}, append(base, arg)...)
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestPoolMetadata(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	h, err := setupRange(plugins.Deps{}, leases, "10.0.0.1", "10.0.0.10", "1h", "pool=guests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := &handler.Metadata{}
+	handler.SetMetadata4(req, md)
+	defer handler.ClearMetadata4(req)
+	h(req, resp)
+	assert.Equal(t, "guests", md.Pool)
+}
+
+func TestDryRun(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	h, err := setupRange(plugins.Deps{}, leases, "10.0.0.1", "10.0.0.10", "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(md *handler.Metadata) net.IP {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.SetMetadata4(req, md)
+		defer handler.ClearMetadata4(req)
+		resp, _ = h(req, resp)
+		return resp.YourIPAddr
+	}
+
+	assert.Equal(t, "10.0.0.1", request(&handler.Metadata{DryRun: true}).String())
+	assert.Equal(t, "10.0.0.1", request(&handler.Metadata{DryRun: true}).String(), "dry run kept the address")
+	db, err := loadDB(leases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stored, err := loadRecords(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, stored, "dry run stored a lease")
+	assert.Equal(t, "10.0.0.1", request(&handler.Metadata{}).String())
+}
+
+func TestExpiries(t *testing.T) {
+	p := PluginState{LeaseTime: time.Hour}
+	if err := p.registerBackingDB(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	future := &Record{IP: net.IPv4(10, 0, 0, 1), expires: int(now.Add(48 * time.Hour).Unix())}
+	current := &Record{IP: net.IPv4(10, 0, 0, 2), expires: int(now.Add(30 * time.Minute).Unix())}
+	p.Recordsv4 = map[string]*Record{
+		"02:00:00:00:00:01": future,
+		"02:00:00:00:00:02": current,
+	}
+
+	assert.Equal(t, 1, p.reconcileExpiries(now))
+	assert.Equal(t, int(now.Add(time.Hour).Unix()), future.expires, "lease from the future was not capped")
+	assert.Equal(t, int(now.Add(30*time.Minute).Unix()), current.expires, "current lease was modified")
+
+	p.shiftExpiries(24 * time.Hour)
+	assert.Equal(t, int(now.Add(24*time.Hour+30*time.Minute).Unix()), current.expires)
+
+	stored, err := loadRecords(p.leasedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, current.expires, stored["02:00:00:00:00:02"].expires, "shifted expiry was not persisted")
+}
+
+func TestDegradedStorage(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	allocator, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := PluginState{
+		Recordsv4:         make(map[string]*Record),
+		LeaseTime:         time.Hour,
+		allocator:         allocator,
+		degradedLeaseTime: 2 * time.Minute,
+	}
+	if err := p.registerBackingDB(leases); err != nil {
+		t.Fatal(err)
+	}
+	request := func(mac byte) time.Duration {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, mac})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ = p.Handler4(req, resp)
+		return resp.IPAddressLeaseTime(0)
+	}
+
+	assert.Equal(t, time.Hour, request(1))
+
+	// Break storage
+	p.leasedb.Close()
+	assert.Equal(t, 2*time.Minute, request(2), "lease time not shortened while storage fails")
+	assert.Len(t, p.unsaved, 1)
+
+	// Repair storage: the unsaved lease is written with the next one
+	p.leasedb, err = loadDB(leases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Hour, request(3))
+	assert.Empty(t, p.unsaved)
+	stored, err := loadRecords(p.leasedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, stored, 3, "leases given while storage failed were not saved")
+}
+
+func TestPoolStatus(t *testing.T) {
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	h, err := setupRange(plugins.Deps{}, leases, "10.0.0.1", "10.0.0.10", "1h", "pool=guests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(req, resp)
+	var status *plugins.PoolStatus
+	for _, s := range plugins.Pools() {
+		if s.Name == "guests" {
+			status = &s
+		}
+	}
+	if assert.NotNil(t, status, "pool not reported") {
+		assert.Equal(t, plugins.PoolStatus{Plugin: "range", Name: "guests", Range: "10.0.0.1-10.0.0.10", Size: 10, Used: 1}, *status)
+	}
+}
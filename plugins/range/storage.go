@@ -89,3 +89,11 @@ func (p *PluginState) registerBackingDB(filename string) error {
 	p.leasedb = newLeaseDB
 	return nil
 }
+
+// deleteIPAddress removes the lease of mac from storage
+func (p *PluginState) deleteIPAddress(mac string) error {
+	if _, err := p.leasedb.Exec(`delete from leases4 where mac = ?`, mac); err != nil {
+		return fmt.Errorf("record deletion failed: %w", err)
+	}
+	return nil
+}
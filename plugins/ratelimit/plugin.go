@@ -0,0 +1,273 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ratelimit limits the requests and clients of each subscriber line,
+// identified by what relay agents add to the requests they forward, rather than
+// by client hardware address, which an attacker can change at will.
+//
+// The first argument selects the key requests are counted by:
+// - circuit: the agent circuit ID (DHCPv4 option 82, sub-option 1)
+// - remote: the agent remote ID (DHCPv4 option 82, sub-option 2, or DHCPv6
+// option 37)
+// - interface: the interface ID (DHCPv6 option 18)
+// For DHCPv6, the options of the relay closest to the client are used.
+// Requests without the key, for example requests that were not relayed, are
+// not limited.
+//
+// The second argument is the rate allowed for each key, as
+// <requests>/<duration>, such as 10/1m. It can be followed by optional
+// key=value arguments:
+// - burst=<n>: number of requests allowed at once, defaults to the number of
+// requests of the rate
+// - max_clients=<n>: maximum number of clients per key. A new client beyond it
+// is dropped until one of the known clients has been silent for
+// client_timeout. Clients are identified by hardware address for DHCPv4 and
+// by DUID for DHCPv6
+// - client_timeout=<duration>: how long a client counts towards max_clients
+// after its last request, 1h by default. It should be at least the lease time
+//
+// Requests over the limits are dropped. Example usage, as first plugin:
+//
+//	server4:
+//	  plugins:
+//	    - ratelimit: circuit 20/1m burst=5 max_clients=4 client_timeout=2h
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/ratelimit")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "ratelimit",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+const (
+	defaultClientTimeout = time.Hour
+	// pruneInterval is how often idle keys are forgotten
+	pruneInterval = time.Minute
+)
+
+// limiter holds the token buckets and clients of every key
+type limiter struct {
+	rate          float64 // tokens per second
+	burst         float64
+	maxClients    int
+	clientTimeout time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*keyState
+	lastPrune time.Time
+}
+
+type keyState struct {
+	tokens float64
+	last   time.Time
+	// clients holds the time of the last request of each client
+	clients map[string]time.Time
+}
+
+func parseArgs(args []string) (key string, l *limiter, err error) {
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("want a key and a rate, got %d arguments", len(args))
+	}
+	key = args[0]
+	l = &limiter{
+		clientTimeout: defaultClientTimeout,
+		keys:          make(map[string]*keyState),
+		lastPrune:     time.Now(),
+	}
+	count, per, ok := strings.Cut(args[1], "/")
+	n, err := strconv.ParseUint(count, 10, 32)
+	if !ok || err != nil || n == 0 {
+		return "", nil, fmt.Errorf("invalid rate %q, want <requests>/<duration>", args[1])
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return "", nil, fmt.Errorf("invalid rate %q, want <requests>/<duration>", args[1])
+	}
+	l.rate = float64(n) / d.Seconds()
+	l.burst = float64(n)
+
+	for _, arg := range args[2:] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid argument %q, want key=value", arg)
+		}
+		switch k {
+		case "burst":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n == 0 {
+				return "", nil, fmt.Errorf("invalid burst %q", v)
+			}
+			l.burst = float64(n)
+		case "max_clients":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n == 0 {
+				return "", nil, fmt.Errorf("invalid max_clients %q", v)
+			}
+			l.maxClients = int(n)
+		case "client_timeout":
+			l.clientTimeout, err = time.ParseDuration(v)
+			if err != nil || l.clientTimeout <= 0 {
+				return "", nil, fmt.Errorf("invalid client_timeout %q", v)
+			}
+		default:
+			return "", nil, fmt.Errorf("unknown argument %q", k)
+		}
+	}
+	return key, l, nil
+}
+
+// allow returns whether a request from client with the given key can be
+// handled at now, and records it if so
+func (l *limiter) allow(key, client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) >= pruneInterval {
+		l.prune(now)
+	}
+	s, ok := l.keys[key]
+	if !ok {
+		s = &keyState{tokens: l.burst, last: now, clients: make(map[string]time.Time)}
+		l.keys[key] = s
+	}
+	s.tokens += now.Sub(s.last).Seconds() * l.rate
+	if s.tokens > l.burst {
+		s.tokens = l.burst
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	if l.maxClients > 0 {
+		if _, known := s.clients[client]; !known {
+			s.expireClients(now, l.clientTimeout)
+			if len(s.clients) >= l.maxClients {
+				return false
+			}
+		}
+		s.clients[client] = now
+	}
+	s.tokens--
+	return true
+}
+
+func (s *keyState) expireClients(now time.Time, timeout time.Duration) {
+	for client, last := range s.clients {
+		if now.Sub(last) >= timeout {
+			delete(s.clients, client)
+		}
+	}
+}
+
+// prune forgets the keys whose bucket is full and which have no client left.
+// Must be called with mu held.
+func (l *limiter) prune(now time.Time) {
+	for key, s := range l.keys {
+		s.expireClients(now, l.clientTimeout)
+		if len(s.clients) == 0 && s.tokens+now.Sub(s.last).Seconds()*l.rate >= l.burst {
+			delete(l.keys, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	key, l, err := parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var code dhcpv4.OptionCode
+	switch key {
+	case "circuit":
+		code = dhcpv4.AgentCircuitIDSubOption
+	case "remote":
+		code = dhcpv4.AgentRemoteIDSubOption
+	default:
+		return nil, fmt.Errorf("invalid key %q for DHCPv4, want circuit or remote", key)
+	}
+	log.Printf("loaded DHCPv4 rate limit by %s", key)
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		// dry runs do not count against the limit
+		if md := handler.Metadata4(req); md != nil && md.DryRun {
+			return resp, false
+		}
+		rai := req.RelayAgentInfo()
+		if rai == nil {
+			return resp, false
+		}
+		id := rai.Get(code)
+		if len(id) == 0 {
+			return resp, false
+		}
+		if !l.allow(string(id), req.ClientHWAddr.String(), time.Now()) {
+			log.Debugf("dropping request from %s over the limit of %s %x", req.ClientHWAddr, key, id)
+			return nil, true
+		}
+		return resp, false
+	}, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	key, l, err := parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var keyOf func(*dhcpv6.RelayMessage) []byte
+	switch key {
+	case "interface":
+		keyOf = func(r *dhcpv6.RelayMessage) []byte { return r.Options.InterfaceID() }
+	case "remote":
+		keyOf = func(r *dhcpv6.RelayMessage) []byte {
+			if rid := r.Options.RemoteID(); rid != nil {
+				return rid.RemoteID
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid key %q for DHCPv6, want interface or remote", key)
+	}
+	log.Printf("loaded DHCPv6 rate limit by %s", key)
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		if md := handler.Metadata6(req); !req.IsRelay() || md != nil && md.DryRun {
+			return resp, false
+		}
+		d, err := dhcpv6.DecapsulateRelayIndex(req, -1)
+		if err != nil {
+			return resp, false
+		}
+		id := keyOf(d.(*dhcpv6.RelayMessage))
+		if len(id) == 0 {
+			return resp, false
+		}
+		msg, err := req.GetInnerMessage()
+		if err != nil {
+			return resp, false
+		}
+		var client string
+		if duid := msg.Options.ClientID(); duid != nil {
+			client = string(duid.ToBytes())
+		}
+		if !l.allow(string(id), client, time.Now()) {
+			log.Debugf("dropping request over the limit of %s %x", key, id)
+			return nil, true
+		}
+		return resp, false
+	}, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	key, l, err := parseArgs([]string{"circuit", "10/1m", "burst=3", "max_clients=2", "client_timeout=2h"})
+	require.NoError(t, err)
+	assert.Equal(t, "circuit", key)
+	assert.InDelta(t, 10.0/60, l.rate, 1e-9)
+	assert.Equal(t, 3.0, l.burst)
+	assert.Equal(t, 2, l.maxClients)
+	assert.Equal(t, 2*time.Hour, l.clientTimeout)
+
+	_, l, err = parseArgs([]string{"remote", "5/1s"})
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, l.burst)
+	assert.Equal(t, defaultClientTimeout, l.clientTimeout)
+
+	for _, args := range [][]string{
+		{},
+		{"circuit"},
+		{"circuit", "10"},
+		{"circuit", "0/1m"},
+		{"circuit", "10/soon"},
+		{"circuit", "10/0s"},
+		{"circuit", "10/1m", "burst=0"},
+		{"circuit", "10/1m", "max_clients=-1"},
+		{"circuit", "10/1m", "client_timeout=never"},
+		{"circuit", "10/1m", "rate=5"},
+		{"circuit", "10/1m", "burst"},
+	} {
+		_, _, err := parseArgs(args)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	_, l, err := parseArgs([]string{"circuit", "1/1s", "burst=2"})
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, l.allow("a", "c1", now))
+	assert.True(t, l.allow("a", "c1", now))
+	assert.False(t, l.allow("a", "c1", now), "over the burst")
+	assert.True(t, l.allow("b", "c1", now), "keys have separate buckets")
+	assert.False(t, l.allow("a", "c1", now.Add(500*time.Millisecond)))
+	assert.True(t, l.allow("a", "c1", now.Add(time.Second)), "bucket refilled")
+	assert.True(t, l.allow("a", "c1", now.Add(10*time.Second)))
+	assert.True(t, l.allow("a", "c1", now.Add(10*time.Second)))
+	assert.False(t, l.allow("a", "c1", now.Add(10*time.Second)), "refill capped at the burst")
+}
+
+func TestAllowMaxClients(t *testing.T) {
+	_, l, err := parseArgs([]string{"circuit", "100/1s", "max_clients=2", "client_timeout=1m"})
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, l.allow("a", "c1", now))
+	assert.True(t, l.allow("a", "c2", now))
+	assert.False(t, l.allow("a", "c3", now), "third client")
+	assert.True(t, l.allow("b", "c3", now), "clients are counted per key")
+	assert.True(t, l.allow("a", "c1", now.Add(30*time.Second)), "known client")
+	// c2 timed out, c1 did not
+	assert.True(t, l.allow("a", "c3", now.Add(time.Minute)))
+	assert.False(t, l.allow("a", "c2", now.Add(time.Minute)))
+}
+
+func TestPrune(t *testing.T) {
+	_, l, err := parseArgs([]string{"circuit", "1/1s", "max_clients=1", "client_timeout=1m"})
+	require.NoError(t, err)
+	now := time.Now()
+	require.True(t, l.allow("a", "c1", now))
+	l.prune(now.Add(30 * time.Second))
+	assert.Contains(t, l.keys, "a", "key with an active client")
+	l.prune(now.Add(time.Minute))
+	assert.NotContains(t, l.keys, "a")
+}
+
+func request4(t *testing.T, mac net.HardwareAddr, circuit string) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+	var mods []dhcpv4.Modifier
+	if circuit != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit)),
+		)))
+	}
+	req, err := dhcpv4.NewDiscovery(mac, mods...)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	return req, resp
+}
+
+func TestHandler4(t *testing.T) {
+	h, err := setup4("circuit", "1/1h")
+	require.NoError(t, err)
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+	req, resp := request4(t, mac, "eth0/1")
+	result, stop := h(req, resp)
+	assert.False(t, stop)
+	assert.Equal(t, resp, result)
+	req, resp = request4(t, mac, "eth0/1")
+	result, stop = h(req, resp)
+	assert.True(t, stop)
+	assert.Nil(t, result)
+
+	req, resp = request4(t, mac, "eth0/2")
+	_, stop = h(req, resp)
+	assert.False(t, stop, "other circuit")
+	for i := 0; i < 3; i++ {
+		req, resp = request4(t, mac, "")
+		_, stop = h(req, resp)
+		assert.False(t, stop, "request without option 82")
+	}
+
+	_, err = setup4("interface", "1/1h")
+	assert.Error(t, err)
+}
+
+func request6(t *testing.T, mac net.HardwareAddr, ifaceID string) (dhcpv6.DHCPv6, dhcpv6.DHCPv6) {
+	msg, err := dhcpv6.NewSolicit(mac)
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(msg)
+	require.NoError(t, err)
+	relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	if ifaceID != "" {
+		relay.AddOption(dhcpv6.OptInterfaceID([]byte(ifaceID)))
+	}
+	// An outer relay whose interface ID must be ignored
+	outer, err := dhcpv6.EncapsulateRelay(relay, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	outer.AddOption(dhcpv6.OptInterfaceID([]byte("uplink")))
+	return outer, resp
+}
+
+func TestHandler6(t *testing.T) {
+	h, err := setup6("interface", "10/1h", "max_clients=1")
+	require.NoError(t, err)
+
+	req, resp := request6(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, "port1")
+	_, stop := h(req, resp)
+	assert.False(t, stop)
+	req, resp = request6(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "port1")
+	_, stop = h(req, resp)
+	assert.True(t, stop, "second client on the interface")
+	req, resp = request6(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "port2")
+	_, stop = h(req, resp)
+	assert.False(t, stop, "other interface")
+	req, resp = request6(t, net.HardwareAddr{0x02, 0, 0, 0, 0, 3}, "")
+	_, stop = h(req, resp)
+	assert.False(t, stop, "no interface ID in the innermost relay")
+
+	_, err = setup6("circuit", "1/1h")
+	assert.Error(t, err)
+}
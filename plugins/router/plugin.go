@@ -7,7 +7,9 @@ package router
 import (
 	"errors"
 	"net"
+	"sync"
 
+	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
@@ -18,32 +20,60 @@ var log = logger.GetLogger("plugins/router")
 
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "router",
-	Setup4: setup4,
+	Name:              "router",
+	Setup4WithOptions: setup4,
+	MinCoreAPI:        2,
 }
 
-var (
-	routers []net.IP
-)
+// routers holds the routers sent by an instance of the plugin. Their option
+// is encoded once, by the first response, rather than for every response.
+type routers struct {
+	list   *[]net.IP
+	once   sync.Once
+	option dhcpv4.Option
+}
+
+// merged collects the routers of the instances set up without a pool, which
+// are sent together
+var merged plugins.Merged[net.IP]
 
-func setup4(args ...string) (handler.Handler4, error) {
+func setup4(cfg config.PluginConfig, deps plugins.Deps) (handler.Handler4, error) {
 	log.Printf("Loaded plugin for DHCPv4.")
+	pool, args := plugins.PoolArg(cfg.Args)
 	if len(args) < 1 {
 		return nil, errors.New("need at least one router IP address")
 	}
+	var parsed []net.IP
 	for _, arg := range args {
 		router := net.ParseIP(arg)
 		if router.To4() == nil {
-			return Handler4, errors.New("expected an router IP address, got: " + arg)
+			return nil, errors.New("expected an router IP address, got: " + arg)
 		}
-		routers = append(routers, router)
+		parsed = append(parsed, router)
 	}
-	log.Infof("loaded %d router IP addresses.", len(routers))
-	return Handler4, nil
+	log.Infof("loaded %d router IP addresses.", len(parsed))
+	if pool != "" {
+		r := &routers{list: &parsed}
+		return handler.ForPool4(pool, r.Handler4), nil
+	}
+	r := &routers{list: merged.Add(deps.Config, parsed...)}
+	last.Set4(r.Handler4)
+	return r.Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the router plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptRouter(routers...))
+func (r *routers) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	r.once.Do(func() {
+		r.option = dhcpv4.OptGeneric(dhcpv4.OptionRouter, dhcpv4.OptRouter(*r.list...).Value.ToBytes())
+	})
+	resp.Options.Update(r.option)
 	return resp, false
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
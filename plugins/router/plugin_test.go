@@ -0,0 +1,54 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package router
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestMergeInstances4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := plugins.Deps{Config: &config.Config{}}
+	var handlers []func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
+	for _, args := range [][]string{{"192.0.2.1"}, {"pool=guests", "192.0.2.3"}, {"192.0.2.2"}} {
+		h, err := setup4(config.PluginConfig{Args: args}, deps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers = append(handlers, h)
+	}
+
+	// the instances without a pool send the routers of both, the pooled one
+	// keeps its own and only answers requests of its pool
+	want := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)}
+	for _, i := range []int{0, 2} {
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := handlers[i](req, stub)
+		got := resp.Router()
+		if len(got) != len(want) || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+			t.Errorf("instance %d sent routers %v, want %v", i, got, want)
+		}
+	}
+
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := Handler4(req, stub)
+	if got := resp.Router(); len(got) != 2 {
+		t.Errorf("package handler sent routers %v, want those of the instances without a pool", got)
+	}
+}
@@ -34,12 +34,18 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-// These are the DNS search domains that are set by the plugin.
+// These are the DNS search domains that are set by an instance of the plugin.
 // Note that DHCPv4 and DHCPv6 options are totally independent.
 // If you need the same settings for both, you'll need to configure
 // this plugin once for the v4 and once for the v6 server.
-var v4SearchList []string
-var v6SearchList []string
+type v6SearchList []string
+
+// v4SearchOption is the DHCPv4 option, encoded once at setup. Options are
+// stored as bytes in DHCPv4 messages, so this avoids encoding the labels again
+// for every response.
+type v4SearchOption struct {
+	option dhcpv4.Option
+}
 
 // copySlice creates a new copy of a string slice in memory.
 // This helps to ensure that downstream plugins can't corrupt
@@ -51,27 +57,26 @@ func copySlice(original []string) []string {
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
-	v6SearchList = args
-	log.Printf("Registered domain search list (DHCPv6) %s", v6SearchList)
-	return domainSearchListHandler6, nil
+	searchList := v6SearchList(copySlice(args))
+	log.Printf("Registered domain search list (DHCPv6) %s", searchList)
+	return searchList.domainSearchListHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	v4SearchList = args
-	log.Printf("Registered domain search list (DHCPv4) %s", v4SearchList)
-	return domainSearchListHandler4, nil
+	searchOption := &v4SearchOption{option: dhcpv4.OptGeneric(dhcpv4.OptionDNSDomainSearchList,
+		dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: copySlice(args)}).Value.ToBytes())}
+	log.Printf("Registered domain search list (DHCPv4) %s", args)
+	return searchOption.domainSearchListHandler4, nil
 }
 
-func domainSearchListHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func (searchList v6SearchList) domainSearchListHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{
-		Labels: copySlice(v6SearchList),
+		Labels: copySlice(searchList),
 	}))
 	return resp, false
 }
 
-func domainSearchListHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{
-		Labels: copySlice(v4SearchList),
-	}))
+func (searchOption *v4SearchOption) domainSearchListHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.UpdateOption(searchOption.option)
 	return resp, false
 }
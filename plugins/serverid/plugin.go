@@ -26,19 +26,18 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-// v6ServerID is the DUID of the v6 server
-var (
-	v6ServerID dhcpv6.DUID
-	v4ServerID net.IP
-)
+// v6ServerID is the DUID of a DHCPv6 instance of the plugin
+type v6ServerID struct {
+	duid dhcpv6.DUID
+}
 
-// Handler6 handles DHCPv6 packets for the server_id plugin.
-func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
-	if v6ServerID == nil {
-		log.Fatal("BUG: Plugin is running uninitialized!")
-		return nil, true
-	}
+// v4ServerID is the address of a DHCPv4 instance of the plugin
+type v4ServerID struct {
+	ip net.IP
+}
 
+// Handler6 handles DHCPv6 packets for the server_id plugin.
+func (s *v6ServerID) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	msg, err := req.GetInnerMessage()
 	if err != nil {
 		// BUG: this should already have failed in the main handler. Abort
@@ -56,8 +55,8 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		}
 
 		// Approximately all others MUST be discarded if the ServerID doesn't match
-		if !sid.Equal(v6ServerID) {
-			log.Infof("requested server ID does not match this server's ID. Got %v, want %v", sid, v6ServerID)
+		if !sid.Equal(s.duid) {
+			log.Infof("requested server ID does not match this server's ID. Got %v, want %v", sid, s.duid)
 			return nil, true
 		}
 	} else if msg.MessageType == dhcpv6.MessageTypeRequest ||
@@ -68,30 +67,26 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// These message types MUST be discarded if they *don't* contain a ServerID option
 		return nil, true
 	}
-	dhcpv6.WithServerID(v6ServerID)(resp)
+	dhcpv6.WithServerID(s.duid)(resp)
 	return resp, false
 }
 
 // Handler4 handles DHCPv4 packets for the server_id plugin.
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if v4ServerID == nil {
-		log.Fatal("BUG: Plugin is running uninitialized!")
-		return nil, true
-	}
+func (s *v4ServerID) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		log.Warningf("not a BootRequest, ignoring")
 		return resp, false
 	}
 	if req.ServerIPAddr != nil &&
 		!req.ServerIPAddr.Equal(net.IPv4zero) &&
-		!req.ServerIPAddr.Equal(v4ServerID) {
+		!req.ServerIPAddr.Equal(s.ip) {
 		// This request is not for us, drop it.
-		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, v4ServerID)
+		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, s.ip)
 		return nil, true
 	}
 	resp.ServerIPAddr = make(net.IP, net.IPv4len)
-	copy(resp.ServerIPAddr[:], v4ServerID)
-	resp.UpdateOption(dhcpv4.OptServerIdentifier(v4ServerID))
+	copy(resp.ServerIPAddr[:], s.ip)
+	resp.UpdateOption(dhcpv4.OptServerIdentifier(s.ip))
 	return resp, false
 }
 
@@ -107,8 +102,9 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if serverID.To4() == nil {
 		return nil, errors.New("not a valid IPv4 address")
 	}
-	v4ServerID = serverID.To4()
-	return Handler4, nil
+	s := &v4ServerID{ip: serverID.To4()}
+	last.Set4(s.Handler4)
+	return s.Handler4, nil
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
@@ -129,15 +125,16 @@ func setup6(args ...string) (handler.Handler6, error) {
 	if err != nil {
 		return nil, err
 	}
+	var s v6ServerID
 	switch duidType {
 	case "ll", "duid-ll", "duid_ll":
-		v6ServerID = &dhcpv6.DUIDLL{
+		s.duid = &dhcpv6.DUIDLL{
 			// sorry, only ethernet for now
 			HWType:        iana.HWTypeEthernet,
 			LinkLayerAddr: hwaddr,
 		}
 	case "llt", "duid-llt", "duid_llt":
-		v6ServerID = &dhcpv6.DUIDLLT{
+		s.duid = &dhcpv6.DUIDLLT{
 			// sorry, zero-time for now
 			Time: 0,
 			// sorry, only ethernet for now
@@ -151,5 +148,19 @@ func setup6(args ...string) (handler.Handler6, error) {
 	}
 	log.Printf("using %s %s", duidType, duidValue)
 
-	return Handler6, nil
+	last.Set6(s.Handler6)
+	return s.Handler6, nil
+}
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
+
+// Deprecated: use the handler returned by Plugin.Setup6.
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	return last.Handler6(req, resp)
 }
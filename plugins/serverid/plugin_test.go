@@ -24,7 +24,7 @@ func TestRejectBadServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	s := v6ServerID{duid: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeRenew
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -35,7 +35,7 @@ func TestRejectBadServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := s.Handler6(req, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a request with mismatched ServerID")
 	}
@@ -49,7 +49,7 @@ func TestRejectUnexpectedServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	s := v6ServerID{duid: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeSolicit
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -60,7 +60,7 @@ func TestRejectUnexpectedServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(req, stub)
+	resp, stop := s.Handler6(req, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a solicit with a ServerID")
 	}
@@ -74,7 +74,7 @@ func TestAddServerIDV6(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	s := v6ServerID{duid: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeRebind
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -84,15 +84,15 @@ func TestAddServerIDV6(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, _ := Handler6(req, stub)
+	resp, _ := s.Handler6(req, stub)
 	if resp == nil {
 		t.Fatal("plugin did not return an answer")
 	}
 
 	if opt := resp.(*dhcpv6.Message).Options.ServerID(); opt == nil {
 		t.Fatal("plugin did not add a ServerID option")
-	} else if !opt.Equal(v6ServerID) {
-		t.Fatalf("Got unexpected DUID: expected %v, got %v", v6ServerID, opt)
+	} else if !opt.Equal(s.duid) {
+		t.Fatalf("Got unexpected DUID: expected %v, got %v", s.duid, opt)
 	}
 }
 
@@ -101,7 +101,7 @@ func TestRejectInnerMessageServerID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	v6ServerID = makeTestDUID("0000000000000000")
+	s := v6ServerID{duid: makeTestDUID("0000000000000000")}
 
 	req.MessageType = dhcpv6.MessageTypeSolicit
 	dhcpv6.WithClientID(makeTestDUID("1000000000000000"))(req)
@@ -117,7 +117,7 @@ func TestRejectInnerMessageServerID(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, stop := Handler6(relayedRequest, stub)
+	resp, stop := s.Handler6(relayedRequest, stub)
 	if resp != nil {
 		t.Error("server_id is sending a response message to a relayed solicit with a ServerID")
 	}
@@ -0,0 +1,220 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+// errDependencyFailed is the error of a setup that was not run because a setup
+// it depends on failed
+var errDependencyFailed = errors.New("dependency failed")
+
+// setupJob is the setup of one plugin entry of a server configuration
+type setupJob struct {
+	v6     bool
+	proto  string
+	conf   config.PluginConfig
+	plugin *Plugin
+	deps   Deps
+	after  []*setupJob
+
+	started chan struct{}
+	done    chan struct{}
+	h4      handler.Handler4
+	h6      handler.Handler6
+	err     error
+}
+
+func (j *setupJob) String() string {
+	return fmt.Sprintf("%s plugin `%s`", j.proto, j.conf.Name)
+}
+
+func (j *setupJob) run() {
+	defer close(j.done)
+	for _, dep := range j.after {
+		<-dep.done
+		if dep.err != nil {
+			j.err = errDependencyFailed
+			return
+		}
+	}
+	close(j.started)
+	log.Printf("%s: loading plugin `%s`", j.proto, j.conf.Name)
+	start := time.Now()
+	if j.v6 {
+		if j.plugin.Setup6WithOptions != nil {
+			j.h6, j.err = j.plugin.Setup6WithOptions(j.conf, j.deps)
+		} else {
+			j.h6, j.err = j.plugin.Setup6(j.conf.Args...)
+		}
+		if j.err == nil && j.h6 == nil {
+			j.err = config.ConfigErrorFromString("no DHCPv6 handler for plugin %s", j.conf.Name)
+		}
+	} else {
+		if j.plugin.Setup4WithOptions != nil {
+			j.h4, j.err = j.plugin.Setup4WithOptions(j.conf, j.deps)
+		} else {
+			j.h4, j.err = j.plugin.Setup4(j.conf.Args...)
+		}
+		if j.err == nil && j.h4 == nil {
+			j.err = config.ConfigErrorFromString("no DHCPv4 handler for plugin %s", j.conf.Name)
+		}
+	}
+	log.Debugf("%s: plugin `%s` set up in %s", j.proto, j.conf.Name, time.Since(start))
+}
+
+// setupJobs returns the setup jobs of the plugins of both servers, DHCPv6
+// first, in configuration order. Each job runs after the previous jobs of the
+// same plugin, which may share package state with it, and after all the jobs
+// of the plugins it depends on.
+func setupJobs(conf *config.Config) ([]*setupJob, error) {
+	var jobs []*setupJob
+	byName := make(map[string][]*setupJob)
+	add := func(proto string, server *config.ServerConfig, v6 bool) error {
+		if server == nil {
+			return nil
+		}
+		for _, pluginConf := range server.Plugins {
+			plugin, ok := RegisteredPlugins[pluginConf.Name]
+			if !ok {
+				return config.ConfigErrorFromString("%s: unknown plugin `%s`", proto, pluginConf.Name)
+			}
+			if (v6 && !plugin.has6()) || (!v6 && !plugin.has4()) {
+				log.Warningf("%s: plugin `%s` has no setup function for %s", proto, pluginConf.Name, proto)
+				continue
+			}
+			j := &setupJob{
+				v6:     v6,
+				proto:  proto,
+				conf:   pluginConf,
+				plugin: plugin,
+				deps: Deps{
+					Log:        logger.GetLogger("plugins/" + plugin.Name),
+					ReportPool: ReportPool,
+					Config:     conf,
+					OnUnload:   func(stop func()) { onUnload(conf, stop) },
+				},
+				started: make(chan struct{}),
+				done:    make(chan struct{}),
+			}
+			if prev := byName[plugin.Name]; len(prev) > 0 {
+				j.after = append(j.after, prev[len(prev)-1])
+			}
+			byName[plugin.Name] = append(byName[plugin.Name], j)
+			jobs = append(jobs, j)
+		}
+		return nil
+	}
+	if err := add("DHCPv6", conf.Server6, true); err != nil {
+		return nil, err
+	}
+	if err := add("DHCPv4", conf.Server4, false); err != nil {
+		return nil, err
+	}
+	if err := checkDependencies(byName); err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		for _, dep := range j.plugin.DependsOn {
+			j.after = append(j.after, byName[dep]...)
+		}
+	}
+	return jobs, nil
+}
+
+// checkDependencies returns an error if the dependencies of the configured
+// plugins form a cycle, as their setups would wait for each other forever.
+// Dependencies on plugins that are not configured are ignored.
+func checkDependencies(byName map[string][]*setupJob) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return config.ConfigErrorFromString("plugin dependency cycle: %s -> %s", strings.Join(path, " -> "), name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range RegisteredPlugins[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for name := range byName {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSetup runs the jobs in parallel, as their dependencies allow, and waits
+// for all of them or for timeout if not zero. It returns the first error in
+// configuration order. On timeout, the error names the plugins whose setup
+// was still running, and the setups are left to finish in the background as
+// they cannot be interrupted.
+func runSetup(jobs []*setupJob, timeout time.Duration) error {
+	for _, j := range jobs {
+		go j.run()
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for _, j := range jobs {
+		select {
+		case <-j.done:
+		case <-expired:
+			return setupTimeoutError(jobs, timeout)
+		}
+	}
+	for _, j := range jobs {
+		if j.err != nil && !errors.Is(j.err, errDependencyFailed) {
+			return j.err
+		}
+	}
+	return nil
+}
+
+func setupTimeoutError(jobs []*setupJob, timeout time.Duration) error {
+	var blocking []string
+	for _, j := range jobs {
+		select {
+		case <-j.done:
+			continue
+		default:
+		}
+		select {
+		case <-j.started:
+			blocking = append(blocking, j.String())
+		default:
+		}
+	}
+	return fmt.Errorf("plugin setup did not complete within %s, blocked by %s", timeout, strings.Join(blocking, ", "))
+}
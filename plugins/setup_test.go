@@ -0,0 +1,238 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// setupRecorder records the order setups complete in
+type setupRecorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *setupRecorder) plugin(name string, delay time.Duration, err error, deps ...string) *Plugin {
+	record := func(label string) error {
+		time.Sleep(delay)
+		r.mu.Lock()
+		r.order = append(r.order, label)
+		r.mu.Unlock()
+		return err
+	}
+	return &Plugin{
+		Name: name,
+		Setup6: func(args ...string) (handler.Handler6, error) {
+			if err := record(name + "/6 " + strings.Join(args, " ")); err != nil {
+				return nil, err
+			}
+			return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) { return resp, false }, nil
+		},
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			if err := record(name + "/4 " + strings.Join(args, " ")); err != nil {
+				return nil, err
+			}
+			return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) { return resp, false }, nil
+		},
+		DependsOn: deps,
+	}
+}
+
+// withPlugins registers plugins for the duration of a test
+func withPlugins(t *testing.T, plugins ...*Plugin) {
+	saved := RegisteredPlugins
+	RegisteredPlugins = make(map[string]*Plugin)
+	for _, p := range plugins {
+		RegisteredPlugins[p.Name] = p
+	}
+	t.Cleanup(func() { RegisteredPlugins = saved })
+}
+
+func testConfig(plugins6, plugins4 []config.PluginConfig) *config.Config {
+	c := config.New()
+	if plugins6 != nil {
+		c.Server6 = &config.ServerConfig{Plugins: plugins6}
+	}
+	if plugins4 != nil {
+		c.Server4 = &config.ServerConfig{Plugins: plugins4}
+	}
+	return c
+}
+
+func indexOf(order []string, label string) int {
+	for i, l := range order {
+		if l == label {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestLoadPluginsParallel(t *testing.T) {
+	r := &setupRecorder{}
+	withPlugins(t,
+		r.plugin("slow", 50*time.Millisecond, nil),
+		r.plugin("fast", 0, nil),
+		r.plugin("dependent", 0, nil, "slow"),
+	)
+	conf := testConfig(
+		[]config.PluginConfig{{Name: "slow", Args: []string{"a"}}, {Name: "fast"}, {Name: "dependent"}},
+		[]config.PluginConfig{{Name: "slow", Args: []string{"b"}}, {Name: "fast"}},
+	)
+	h4, h6, err := LoadPlugins(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h6) != 3 || len(h4) != 2 {
+		t.Fatalf("got %d DHCPv6 and %d DHCPv4 handlers, want 3 and 2", len(h6), len(h4))
+	}
+	if indexOf(r.order, "fast/6 ") > indexOf(r.order, "slow/6 a") {
+		t.Errorf("fast plugin waited for slow plugin: %v", r.order)
+	}
+	if indexOf(r.order, "slow/6 a") > indexOf(r.order, "slow/4 b") {
+		t.Errorf("instances of a plugin were not set up in order: %v", r.order)
+	}
+	if indexOf(r.order, "dependent/6 ") < indexOf(r.order, "slow/4 b") {
+		t.Errorf("plugin was set up before all instances of its dependency: %v", r.order)
+	}
+}
+
+func TestLoadPluginsError(t *testing.T) {
+	r := &setupRecorder{}
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	withPlugins(t,
+		r.plugin("first", 20*time.Millisecond, errFirst),
+		r.plugin("second", 0, errSecond),
+		r.plugin("dependent", 0, nil, "first"),
+	)
+	conf := testConfig([]config.PluginConfig{{Name: "first"}, {Name: "second"}, {Name: "dependent"}}, nil)
+	if _, _, err := LoadPlugins(conf); err != errFirst {
+		t.Errorf("got error %v, want the error of the first plugin in configuration order", err)
+	}
+	if indexOf(r.order, "dependent/6 ") != -1 {
+		t.Error("plugin was set up after its dependency failed")
+	}
+
+	conf = testConfig(nil, []config.PluginConfig{{Name: "unknown"}})
+	if _, _, err := LoadPlugins(conf); err == nil {
+		t.Error("no error for an unknown plugin")
+	}
+}
+
+func TestLoadPluginsCycle(t *testing.T) {
+	r := &setupRecorder{}
+	withPlugins(t,
+		r.plugin("a", 0, nil, "b"),
+		r.plugin("b", 0, nil, "c"),
+		r.plugin("c", 0, nil, "a"),
+		r.plugin("d", 0, nil, "unconfigured"),
+	)
+	_, _, err := LoadPlugins(testConfig([]config.PluginConfig{{Name: "a"}, {Name: "b"}, {Name: "c"}}, nil))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("got error %v, want a dependency cycle", err)
+	}
+	if _, _, err := LoadPlugins(testConfig([]config.PluginConfig{{Name: "a"}, {Name: "b"}, {Name: "d"}}, nil)); err != nil {
+		t.Errorf("dependencies on unconfigured plugins: %v", err)
+	}
+}
+
+func TestLoadPluginsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blocking := &Plugin{
+		Name: "blocking",
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			<-release
+			return nil, errors.New("released")
+		},
+	}
+	r := &setupRecorder{}
+	withPlugins(t, blocking, r.plugin("fast", 0, nil), r.plugin("waiting", 0, nil, "blocking"))
+	conf := testConfig(nil, []config.PluginConfig{{Name: "fast"}, {Name: "blocking"}, {Name: "waiting"}})
+	conf.SetupTimeout = 20 * time.Millisecond
+	_, _, err := LoadPlugins(conf)
+	if err == nil {
+		t.Fatal("no error when setup timed out")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "DHCPv4 plugin `blocking`") || strings.Contains(msg, "waiting") || strings.Contains(msg, "fast") {
+		t.Errorf("got error %q, want it to name only the blocking plugin", msg)
+	}
+}
+
+func TestLoadPluginsWithOptions(t *testing.T) {
+	var (
+		got4 config.PluginConfig
+		deps Deps
+	)
+	withPlugins(t, &Plugin{
+		Name: "both",
+		Setup6: func(args ...string) (handler.Handler6, error) {
+			return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) { return resp, false }, nil
+		},
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			return nil, errors.New("variadic setup used despite the one with options")
+		},
+		Setup4WithOptions: func(cfg config.PluginConfig, d Deps) (handler.Handler4, error) {
+			got4, deps = cfg, d
+			return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) { return resp, false }, nil
+		},
+	})
+	entry := config.PluginConfig{Name: "both", Args: []string{"a", "b"}, Timeout: time.Second, OnTimeout: config.TimeoutDrop}
+	conf := testConfig([]config.PluginConfig{{Name: "both"}}, []config.PluginConfig{entry})
+	h4, h6, err := LoadPlugins(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h6) != 1 || len(h4) != 1 {
+		t.Fatalf("got %d DHCPv6 and %d DHCPv4 handlers, want 1 and 1", len(h6), len(h4))
+	}
+	if got4.Name != entry.Name || strings.Join(got4.Args, " ") != "a b" || got4.Timeout != time.Second {
+		t.Errorf("got plugin entry %+v, want %+v", got4, entry)
+	}
+	if deps.Log == nil || deps.Log.Data["prefix"] != "plugins/both" {
+		t.Errorf("got logger %v, want one prefixed with the plugin name", deps.Log)
+	}
+	if deps.Config != conf || deps.ReportPool == nil {
+		t.Errorf("got deps %+v, want the configuration and pool reporting", deps)
+	}
+}
+
+func TestUnload(t *testing.T) {
+	var stopped []string
+	withPlugins(t, &Plugin{
+		Name: "watcher",
+		Setup4WithOptions: func(cfg config.PluginConfig, deps Deps) (handler.Handler4, error) {
+			deps.OnUnload(func() { stopped = append(stopped, cfg.Args[0]) })
+			return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) { return resp, false }, nil
+		},
+		MinCoreAPI: 2,
+	})
+	conf := testConfig(nil, []config.PluginConfig{{Name: "watcher", Args: []string{"a"}}, {Name: "watcher", Args: []string{"b"}}})
+	other := testConfig(nil, []config.PluginConfig{{Name: "watcher", Args: []string{"c"}}})
+	for _, c := range []*config.Config{conf, other} {
+		if _, _, err := LoadPlugins(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Unload(conf)
+	Unload(conf)
+	if strings.Join(stopped, " ") != "b a" {
+		t.Errorf("stopped instances %v, want those of the configuration once, in reverse order", stopped)
+	}
+	Unload(other)
+	if strings.Join(stopped, " ") != "b a c" {
+		t.Errorf("stopped instances %v, want the instance of the other configuration last", stopped)
+	}
+}
@@ -23,51 +23,67 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var routes dhcpv4.Routes
+// staticRoutes are the routes sent by an instance of the plugin
+type staticRoutes dhcpv4.Routes
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
-	routes = make(dhcpv4.Routes, 0)
+	routes, err := parseRoutes(args)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded %d static routes.", len(routes))
+	last.Set4(routes.Handler4)
+	return routes.Handler4, nil
+}
 
+// parseRoutes parses destination,gateway pairs
+func parseRoutes(args []string) (staticRoutes, error) {
 	if len(args) < 1 {
 		return nil, errors.New("need at least one static route")
 	}
 
 	var err error
+	routes := make(staticRoutes, 0, len(args))
 	for _, arg := range args {
 		fields := strings.Split(arg, ",")
 		if len(fields) != 2 {
-			return Handler4, errors.New("expected a destination/gateway pair, got: " + arg)
+			return nil, errors.New("expected a destination/gateway pair, got: " + arg)
 		}
 
 		route := &dhcpv4.Route{}
 		_, route.Dest, err = net.ParseCIDR(fields[0])
 		if err != nil {
-			return Handler4, errors.New("expected a destination subnet, got: " + fields[0])
+			return nil, errors.New("expected a destination subnet, got: " + fields[0])
 		}
 
 		route.Router = net.ParseIP(fields[1])
 		if route.Router == nil {
-			return Handler4, errors.New("expected a gateway address, got: " + fields[1])
+			return nil, errors.New("expected a gateway address, got: " + fields[1])
 		}
 
 		routes = append(routes, route)
 		log.Debugf("adding static route %s", route)
 	}
-
-	log.Printf("loaded %d static routes.", len(routes))
-
-	return Handler4, nil
+	return routes, nil
 }
 
 // Handler4 handles DHCPv4 packets for the static routes plugin
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (routes staticRoutes) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if len(routes) > 0 {
 		resp.Options.Update(dhcpv4.Option{
 			Code:  dhcpv4.OptionCode(dhcpv4.OptionClasslessStaticRoute),
-			Value: routes,
+			Value: dhcpv4.Routes(routes),
 		})
 	}
 
 	return resp, false
 }
+
+// last is the last instance set up, for the package-level handlers
+var last plugins.LastInstance
+
+// Deprecated: use the handler returned by Plugin.Setup4.
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	return last.Handler4(req, resp)
+}
@@ -0,0 +1,160 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"slices"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// The handlers below run the wrapped handler on a copy of the response: a
+// handler that timed out keeps running in the background, and must not modify
+// the response that the rest of the chain is working on.
+//
+// The timeout is derived from the request context, so it never extends past
+// the request deadline, and plugins honouring handler.Context4 and
+// handler.Context6 stop working on requests the client gave up on.
+
+// defaultMaxInFlight is the maximum number of requests a plugin with a timeout
+// may be working on, unless its max_in_flight key says otherwise. A hung
+// plugin would otherwise get a new goroutine for every request; once the limit
+// is reached, the timeout action is applied right away until some of the
+// calls return.
+const defaultMaxInFlight = 64
+
+// copy4 returns a copy of resp that can be modified without affecting it.
+// Options are copied one by one rather than serialized and parsed again.
+func copy4(resp *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	c := *resp
+	c.ClientHWAddr = slices.Clone(resp.ClientHWAddr)
+	c.ClientIPAddr = slices.Clone(resp.ClientIPAddr)
+	c.YourIPAddr = slices.Clone(resp.YourIPAddr)
+	c.ServerIPAddr = slices.Clone(resp.ServerIPAddr)
+	c.GatewayIPAddr = slices.Clone(resp.GatewayIPAddr)
+	c.Options = make(dhcpv4.Options, len(resp.Options))
+	for code, value := range resp.Options {
+		c.Options[code] = slices.Clone(value)
+	}
+	return &c
+}
+
+// copy6 returns a copy of resp that can be modified without affecting it. The
+// responses of the chain are messages whose options are added or replaced by
+// handlers, so copying the list of options is enough. Other messages are
+// serialized and parsed again.
+func copy6(resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, error) {
+	if m, ok := resp.(*dhcpv6.Message); ok {
+		c := *m
+		c.Options.Options = slices.Clone(m.Options.Options)
+		return &c, nil
+	}
+	return dhcpv6.FromBytes(resp.ToBytes())
+}
+
+func withTimeout4(h handler.Handler4, c config.PluginConfig) handler.Handler4 {
+	name, timeout, action := c.Name, c.Timeout, c.OnTimeout
+	maxInFlight := c.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	type result struct {
+		resp *dhcpv4.DHCPv4
+		stop bool
+	}
+	slots := make(chan struct{}, maxInFlight)
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			log.Warningf("DHCPv4: plugin `%s` has %d requests in flight, applying `%s`", name, maxInFlight, action)
+			return onTimeout4(resp, action)
+		}
+		work := copy4(resp)
+		ctx, cancel := context.WithTimeout(handler.Context4(req), timeout)
+		defer cancel()
+		done := make(chan result, 1)
+		go func() {
+			defer func() { <-slots }()
+			r, stop := h(req, work)
+			done <- result{r, stop}
+		}()
+		select {
+		case r := <-done:
+			return r.resp, r.stop
+		case <-ctx.Done():
+		}
+		log.Warningf("DHCPv4: plugin `%s` timed out after %s, applying `%s`", name, timeout, action)
+		return onTimeout4(resp, action)
+	}
+}
+
+func onTimeout4(resp *dhcpv4.DHCPv4, action config.TimeoutAction) (*dhcpv4.DHCPv4, bool) {
+	switch action {
+	case config.TimeoutDrop:
+		return nil, true
+	case config.TimeoutPartial:
+		return resp, true
+	default:
+		return resp, false
+	}
+}
+
+func withTimeout6(h handler.Handler6, c config.PluginConfig) handler.Handler6 {
+	name, timeout, action := c.Name, c.Timeout, c.OnTimeout
+	maxInFlight := c.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	type result struct {
+		resp dhcpv6.DHCPv6
+		stop bool
+	}
+	slots := make(chan struct{}, maxInFlight)
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			log.Warningf("DHCPv6: plugin `%s` has %d requests in flight, applying `%s`", name, maxInFlight, action)
+			return onTimeout6(resp, action)
+		}
+		work, err := copy6(resp)
+		if err != nil {
+			<-slots
+			log.Errorf("DHCPv6: plugin `%s`: cannot copy response: %v", name, err)
+			return nil, true
+		}
+		ctx, cancel := context.WithTimeout(handler.Context6(req), timeout)
+		defer cancel()
+		done := make(chan result, 1)
+		go func() {
+			defer func() { <-slots }()
+			r, stop := h(req, work)
+			done <- result{r, stop}
+		}()
+		select {
+		case r := <-done:
+			return r.resp, r.stop
+		case <-ctx.Done():
+		}
+		log.Warningf("DHCPv6: plugin `%s` timed out after %s, applying `%s`", name, timeout, action)
+		return onTimeout6(resp, action)
+	}
+}
+
+func onTimeout6(resp dhcpv6.DHCPv6, action config.TimeoutAction) (dhcpv6.DHCPv6, bool) {
+	switch action {
+	case config.TimeoutDrop:
+		return nil, true
+	case config.TimeoutPartial:
+		return resp, true
+	default:
+		return resp, false
+	}
+}
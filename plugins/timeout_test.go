@@ -0,0 +1,161 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestWithTimeout4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	slow := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		resp.YourIPAddr = net.IPv4(192, 0, 2, 1)
+		<-release
+		return resp, false
+	}
+	fast := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		resp.YourIPAddr = net.IPv4(192, 0, 2, 2)
+		return resp, true
+	}
+
+	testcases := []struct {
+		action        config.TimeoutAction
+		nilResp, stop bool
+	}{
+		{config.TimeoutSkip, false, false},
+		{config.TimeoutDrop, true, true},
+		{config.TimeoutPartial, false, true},
+	}
+	for _, tc := range testcases {
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, stop := withTimeout4(slow, config.PluginConfig{Name: "slow", Timeout: 10 * time.Millisecond, OnTimeout: tc.action})(req, stub)
+		if (resp == nil) != tc.nilResp || stop != tc.stop {
+			t.Errorf("%s: got response %v and stop %v", tc.action, resp, stop)
+		}
+		if resp != nil && !resp.YourIPAddr.IsUnspecified() {
+			t.Errorf("%s: timed out handler modified the response", tc.action)
+		}
+	}
+
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, stop := withTimeout4(fast, config.PluginConfig{Name: "fast", Timeout: time.Second, OnTimeout: config.TimeoutDrop})(req, stub)
+	if resp == nil || !stop || !resp.YourIPAddr.Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Errorf("handler result not passed through: got %v, %v", resp, stop)
+	}
+}
+
+func TestWithTimeout4Bounds(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	hung := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		<-release
+		return resp, false
+	}
+	call := func(h func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)) time.Duration {
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if resp, stop := h(req, stub); resp != nil || !stop {
+			t.Errorf("timeout action not applied: got %v, %v", resp, stop)
+		}
+		return time.Since(start)
+	}
+
+	// The request deadline cuts the plugin timeout short
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	handler.SetMetadata4(req, &handler.Metadata{Context: ctx})
+	if d := call(withTimeout4(hung, config.PluginConfig{Name: "hung", Timeout: time.Hour, OnTimeout: config.TimeoutDrop})); d > time.Minute {
+		t.Errorf("waited %s past the request deadline", d)
+	}
+	handler.ClearMetadata4(req)
+
+	// Once max_in_flight calls are hung, the action is applied without
+	// calling the plugin
+	const maxInFlight = 4
+	started := make(chan struct{}, maxInFlight+1)
+	h := withTimeout4(func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		started <- struct{}{}
+		return hung(req, resp)
+	}, config.PluginConfig{Name: "hung", Timeout: time.Millisecond, OnTimeout: config.TimeoutDrop, MaxInFlight: maxInFlight})
+	for i := 0; i < maxInFlight; i++ {
+		call(h)
+		<-started
+	}
+	call(h)
+	select {
+	case <-started:
+		t.Error("plugin called past max_in_flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCopy(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp4, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 1)),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IPv4(192, 0, 2, 254))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want4 := resp4.ToBytes()
+	c4 := copy4(resp4)
+	if !bytes.Equal(c4.ToBytes(), want4) {
+		t.Errorf("copy differs from the response:\ngot  %x\nwant %x", c4.ToBytes(), want4)
+	}
+	c4.YourIPAddr[3] = 2
+	c4.Options.Get(dhcpv4.OptionRouter)[3] = 1
+	c4.Options.Update(dhcpv4.OptDNS(net.IPv4(192, 0, 2, 53)))
+	if !bytes.Equal(resp4.ToBytes(), want4) {
+		t.Error("modifying the copy modified the DHCPv4 response")
+	}
+
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp6, err := dhcpv6.NewAdvertiseFromSolicit(solicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want6 := resp6.ToBytes()
+	c6, err := copy6(resp6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c6.AddOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+	c6.UpdateOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}))
+	if !bytes.Equal(resp6.ToBytes(), want6) {
+		t.Error("modifying the copy modified the DHCPv6 response")
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"sync"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+var (
+	unloadMu sync.Mutex
+	// unloads holds the functions registered with Deps.OnUnload, by the
+	// configuration the plugins were loaded from
+	unloads = make(map[*config.Config][]func())
+)
+
+func onUnload(conf *config.Config, stop func()) {
+	unloadMu.Lock()
+	defer unloadMu.Unlock()
+	unloads[conf] = append(unloads[conf], stop)
+}
+
+// Unload stops the background work of the plugin instances loaded from conf,
+// by calling the functions they registered with Deps.OnUnload, in reverse
+// order. The server calls it when it closes. Later calls for the same conf do
+// nothing.
+func Unload(conf *config.Config) {
+	unloadMu.Lock()
+	stops := unloads[conf]
+	delete(unloads, conf)
+	unloadMu.Unlock()
+	for i := len(stops) - 1; i >= 0; i-- {
+		stops[i]()
+	}
+}
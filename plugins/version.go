@@ -0,0 +1,130 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// CoreAPIVersion is the version of the interface the core offers to plugins.
+// It is incremented when plugins can rely on new features of it, and checked
+// against Plugin.MinCoreAPI at registration. Version 2 added the setup
+// functions with options and Deps.
+const CoreAPIVersion = 2
+
+// coreModule is the path of the module of the core
+const coreModule = "github.com/coredhcp/coredhcp"
+
+// unknownVersion is reported when no version is available, typically for
+// binaries built without module support
+const unknownVersion = "unknown"
+
+// CoreVersion returns the version of coredhcp in the running binary
+func CoreVersion() string {
+	return moduleVersion(coreModule)
+}
+
+// VersionOf returns the version of a plugin: its Version if set, otherwise
+// the version of the Go module providing it in the running binary
+func VersionOf(p *Plugin) string {
+	if p.Version != "" {
+		return p.Version
+	}
+	pkg := pluginPackage(p)
+	if pkg == "" {
+		return unknownVersion
+	}
+	return moduleVersion(pkg)
+}
+
+// pluginPackage returns the import path of the package defining the setup
+// functions of p, or "" if it has none
+func pluginPackage(p *Plugin) string {
+	var v reflect.Value
+	for _, setup := range []interface{}{p.Setup6WithOptions, p.Setup6, p.Setup4WithOptions, p.Setup4} {
+		if v = reflect.ValueOf(setup); !v.IsNil() {
+			break
+		}
+	}
+	if v.IsNil() {
+		return ""
+	}
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return ""
+	}
+	// Function names are the package path followed by a dot and the name,
+	// eg. github.com/coredhcp/coredhcp/plugins/file.setup6
+	name := fn.Name()
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+// moduleVersion returns the version of the module providing pkg, from the
+// build information of the running binary
+func moduleVersion(pkg string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknownVersion
+	}
+	if inModule(pkg, info.Main.Path) {
+		return mainVersion(info)
+	}
+	// the longest matching module path provides the package
+	var found *debug.Module
+	for _, dep := range info.Deps {
+		if inModule(pkg, dep.Path) && (found == nil || len(dep.Path) > len(found.Path)) {
+			found = dep
+		}
+	}
+	if found == nil {
+		return unknownVersion
+	}
+	if found.Replace != nil {
+		return found.Version + " => " + found.Replace.Path + " " + found.Replace.Version
+	}
+	return found.Version
+}
+
+func inModule(pkg, module string) bool {
+	return module != "" && (pkg == module || strings.HasPrefix(pkg, module+"/"))
+}
+
+// mainVersion returns the version of the main module, along with the commit
+// it was built from when the version does not name it
+func mainVersion(info *debug.BuildInfo) string {
+	version := info.Main.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "+dirty"
+			}
+		}
+	}
+	if revision == "" {
+		return version
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if strings.Contains(version, revision) {
+		// pseudo-versions already name the commit
+		return version
+	}
+	return version + " " + revision + modified
+}
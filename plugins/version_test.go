@@ -0,0 +1,75 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+func testSetup4(args ...string) (handler.Handler4, error) { return nil, nil }
+
+func TestPluginPackage(t *testing.T) {
+	p := &Plugin{Name: "test", Setup4: testSetup4}
+	if got, want := pluginPackage(p), "github.com/coredhcp/coredhcp/plugins"; got != want {
+		t.Errorf("got package %q, want %q", got, want)
+	}
+	p.Setup6 = func(args ...string) (handler.Handler6, error) { return nil, nil }
+	if got, want := pluginPackage(p), "github.com/coredhcp/coredhcp/plugins"; got != want {
+		t.Errorf("got package %q for a closure, want %q", got, want)
+	}
+	p = &Plugin{Name: "options", Setup4WithOptions: func(config.PluginConfig, Deps) (handler.Handler4, error) { return nil, nil }}
+	if got, want := pluginPackage(p), "github.com/coredhcp/coredhcp/plugins"; got != want {
+		t.Errorf("got package %q for a setup with options, want %q", got, want)
+	}
+	if got := pluginPackage(&Plugin{Name: "empty"}); got != "" {
+		t.Errorf("got package %q for a plugin without setup functions", got)
+	}
+}
+
+func TestVersionOf(t *testing.T) {
+	p := &Plugin{Name: "test", Setup4: testSetup4, Version: "v1.2.3"}
+	if got := VersionOf(p); got != "v1.2.3" {
+		t.Errorf("got version %q, want the declared v1.2.3", got)
+	}
+	p.Version = ""
+	if got := VersionOf(p); got != CoreVersion() {
+		t.Errorf("got version %q for a core plugin, want the core version %q", got, CoreVersion())
+	}
+	if got := VersionOf(&Plugin{Name: "empty"}); got != unknownVersion {
+		t.Errorf("got version %q for a plugin without setup functions", got)
+	}
+}
+
+func TestMainVersion(t *testing.T) {
+	info := &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}
+	if got := mainVersion(info); got != "(devel)" {
+		t.Errorf("got %q without VCS information", got)
+	}
+	info.Settings = []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+		{Key: "vcs.modified", Value: "true"},
+	}
+	if got, want := mainVersion(info), "(devel) 0123456789ab+dirty"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	info.Main.Version = "v0.0.0-20240101000000-0123456789ab"
+	if got := mainVersion(info); got != info.Main.Version {
+		t.Errorf("got %q, want the pseudo-version alone", got)
+	}
+}
+
+func TestRegisterMinCoreAPI(t *testing.T) {
+	withPlugins(t)
+	if err := RegisterPlugin(&Plugin{Name: "future", Setup4: testSetup4, MinCoreAPI: CoreAPIVersion + 1}); err == nil {
+		t.Error("registered a plugin needing a later core API")
+	}
+	if err := RegisterPlugin(&Plugin{Name: "current", Setup4: testSetup4, MinCoreAPI: CoreAPIVersion}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// newAddrRegReply returns an empty ADDR-REG-REPLY to an ADDR-REG-INFORM. The
+// plugin handling registrations fills it in, otherwise it is not sent, see
+// validate6.
+func newAddrRegReply(msg *dhcpv6.Message) (*dhcpv6.Message, error) {
+	cid := msg.GetOneOption(dhcpv6.OptionClientID)
+	if cid == nil {
+		return nil, errors.New("Client ID cannot be nil when building ADDR-REG-REPLY")
+	}
+	rep := &dhcpv6.Message{
+		MessageType:   handler.MessageTypeAddrRegReply,
+		TransactionID: msg.TransactionID,
+	}
+	rep.AddOption(cid)
+	return rep, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestProcessAddrReg(t *testing.T) {
+	inform, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: benchHWAddr}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inform.MessageType = handler.MessageTypeAddrRegInform
+	ia := &dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::1"), ValidLifetime: time.Hour}
+	inform.AddOption(ia)
+
+	var got dhcpv6.MessageType
+	register := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		got = resp.Type()
+		resp.AddOption(ia)
+		return resp, true
+	}
+	resp := process6([]handler.Handler6{register}, inform, &handler.Metadata{})
+	if got != handler.MessageTypeAddrRegReply {
+		t.Errorf("handler got a response of type %s, want ADDR-REG-REPLY", got)
+	}
+	if resp == nil {
+		t.Fatal("no reply to the registration")
+	}
+	if msg := resp.(*dhcpv6.Message); msg.TransactionID != inform.TransactionID || msg.Options.ClientID() == nil {
+		t.Errorf("reply does not match the registration: %s", msg.Summary())
+	}
+
+	ignore := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) { return resp, false }
+	if resp := process6([]handler.Handler6{ignore}, inform, &handler.Metadata{}); resp != nil {
+		t.Errorf("got a reply to a registration no plugin handled: %s", resp.Summary())
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+)
+
+// offerObserver defers the OFFERs of a listener, and suppresses those for
+// which another server's OFFER is seen in the meantime. Other servers' OFFERs
+// are observed by listening on the client port, which only sees broadcast
+// ones. This lets coredhcp run next to an incumbent server, only answering the
+// clients it doesn't.
+type offerObserver struct {
+	net.PacketConn
+	delay time.Duration
+
+	mu sync.Mutex
+	// pending holds the OFFERs being deferred, by transaction ID
+	pending map[dhcpv4.TransactionID]*deferredOffer
+	// seen holds the OFFERs observed recently with no deferred OFFER for
+	// their transaction ID, in case they arrive before ours is deferred
+	seen      map[dhcpv4.TransactionID]observedOffer
+	lastPrune time.Time
+}
+
+type deferredOffer struct {
+	serverID   net.IP
+	superseded chan struct{}
+}
+
+type observedOffer struct {
+	serverID net.IP
+	at       time.Time
+}
+
+func listenOffers(iface string, delay time.Duration) (*offerObserver, error) {
+	conn, err := server4.NewIPv4UDPConn(iface, &net.UDPAddr{Port: dhcpv4.ClientPort})
+	if err != nil {
+		return nil, err
+	}
+	return newOfferObserver(conn, delay), nil
+}
+
+func newOfferObserver(conn net.PacketConn, delay time.Duration) *offerObserver {
+	return &offerObserver{
+		PacketConn: conn,
+		delay:      delay,
+		pending:    make(map[dhcpv4.TransactionID]*deferredOffer),
+		seen:       make(map[dhcpv4.TransactionID]observedOffer),
+		lastPrune:  time.Now(),
+	}
+}
+
+// Serve reads the OFFERs sent to clients until the connection is closed
+func (o *offerObserver) Serve() error {
+	log.Printf("Watching for other servers' OFFERs on %s", o.LocalAddr())
+	buf := make([]byte, MaxDatagram)
+	for {
+		n, _, err := o.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			log.Printf("Error reading from connection: %v", err)
+			return err
+		}
+		msg, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || msg.OpCode != dhcpv4.OpcodeBootReply || msg.MessageType() != dhcpv4.MessageTypeOffer {
+			continue
+		}
+		o.observe(msg.TransactionID, msg.ServerIdentifier(), time.Now())
+	}
+}
+
+// observe records an OFFER sent by the server serverID
+func (o *offerObserver) observe(xid dhcpv4.TransactionID, serverID net.IP, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if p, ok := o.pending[xid]; ok {
+		if !p.serverID.Equal(serverID) {
+			close(p.superseded)
+			delete(o.pending, xid)
+		}
+		return
+	}
+	o.seen[xid] = observedOffer{serverID: serverID, at: now}
+	if now.Sub(o.lastPrune) >= o.delay {
+		for id, s := range o.seen {
+			if now.Sub(s.at) >= o.delay {
+				delete(o.seen, id)
+			}
+		}
+		o.lastPrune = now
+	}
+}
+
+// superseded waits for the deferral delay of an OFFER of the server serverID,
+// and returns whether another server sent an OFFER for the same transaction
+// meanwhile. It returns early when ctx is done.
+func (o *offerObserver) superseded(ctx context.Context, xid dhcpv4.TransactionID, serverID net.IP) bool {
+	o.mu.Lock()
+	if s, ok := o.seen[xid]; ok && time.Since(s.at) < o.delay && !s.serverID.Equal(serverID) {
+		o.mu.Unlock()
+		return true
+	}
+	p := &deferredOffer{serverID: serverID, superseded: make(chan struct{})}
+	o.pending[xid] = p
+	o.mu.Unlock()
+
+	timer := time.NewTimer(o.delay)
+	defer timer.Stop()
+	select {
+	case <-p.superseded:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	select {
+	case <-p.superseded:
+		return true
+	default:
+	}
+	if o.pending[xid] == p {
+		delete(o.pending, xid)
+	}
+	return false
+}
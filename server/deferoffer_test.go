@@ -0,0 +1,106 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestOfferObserver(t *testing.T) {
+	ours := net.IPv4(192, 0, 2, 1)
+	other := net.IPv4(192, 0, 2, 2)
+	conn, _ := NewPipe(&net.UDPAddr{}, &net.UDPAddr{})
+	o := newOfferObserver(conn, 50*time.Millisecond)
+	ctx := context.Background()
+
+	xid := dhcpv4.TransactionID{1}
+	if o.superseded(ctx, xid, ours) {
+		t.Error("OFFER superseded without other OFFERs")
+	}
+
+	// another server's OFFER seen while ours is deferred
+	xid = dhcpv4.TransactionID{2}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		o.observe(xid, other, time.Now())
+	}()
+	start := time.Now()
+	if !o.superseded(ctx, xid, ours) {
+		t.Error("OFFER not superseded by another server's OFFER")
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("suppression took %s, want it as soon as the other OFFER is seen", elapsed)
+	}
+
+	// another server's OFFER seen before ours was deferred
+	xid = dhcpv4.TransactionID{3}
+	o.observe(xid, other, time.Now())
+	if !o.superseded(ctx, xid, ours) {
+		t.Error("OFFER not superseded by an earlier OFFER of another server")
+	}
+
+	// our own OFFER, for example to a client retransmitting its DISCOVER
+	xid = dhcpv4.TransactionID{4}
+	o.observe(xid, ours, time.Now())
+	if o.superseded(ctx, xid, ours) {
+		t.Error("OFFER superseded by our own OFFER")
+	}
+
+	// the context ends the deferral early
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if o.superseded(ctx, dhcpv4.TransactionID{5}, ours) {
+		t.Error("OFFER superseded after the context was cancelled")
+	}
+	if len(o.pending) != 0 {
+		t.Errorf("%d deferred OFFERs left", len(o.pending))
+	}
+}
+
+func TestDeferOffers4(t *testing.T) {
+	client, server := NewPipe(&net.UDPAddr{}, &net.UDPAddr{})
+	defer client.Close()
+	observed, _ := NewPipe(&net.UDPAddr{}, &net.UDPAddr{})
+	l := &listener4{
+		conn4:    memConn4{server},
+		handlers: []handler.Handler4{benchHandler4},
+		deadline: time.Minute,
+		offers:   newOfferObserver(observed, 20*time.Millisecond),
+		inMemory: true,
+	}
+	handle := func(req *dhcpv4.DHCPv4) {
+		buf := bufpool.Get().(*[]byte)
+		*buf = append((*buf)[:0], req.ToBytes()...)
+		l.HandleMsg4(buf, nil, client.LocalAddr(), time.Now())
+	}
+	received := func() bool {
+		if err := client.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := client.ReadFrom(make([]byte, MaxDatagram))
+		return err == nil
+	}
+
+	req, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle(req)
+	if !received() {
+		t.Error("deferred OFFER was not sent")
+	}
+
+	l.offers.observe(req.TransactionID, net.IPv4(192, 0, 2, 2), time.Now())
+	handle(req)
+	if received() {
+		t.Error("OFFER sent after another server's")
+	}
+}
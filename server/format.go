@@ -0,0 +1,53 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/u-root/uio/uio"
+)
+
+// responseFormat returns the format of the response to req, or nil to use the
+// default one
+func (l *listener4) responseFormat(req *dhcpv4.DHCPv4) *config.ResponseFormat {
+	for i := range l.formats {
+		f := &l.formats[i]
+		if (handler.ClientClass{Vendor: f.Vendor, UserClass: f.UserClass}).Matches(req) {
+			return f
+		}
+	}
+	return nil
+}
+
+// marshalOptions4 writes the options of a response in the order of f: the
+// options it lists first, then the others in the default order
+func marshalOptions4(b *uio.Lexer, options dhcpv4.Options, f *config.ResponseFormat) {
+	if f == nil || len(f.OptionOrder) == 0 {
+		options.Marshal(b)
+		return
+	}
+	rest := make(dhcpv4.Options, len(options))
+	for code, data := range options {
+		rest[code] = data
+	}
+	for _, code := range f.OptionOrder {
+		if data, ok := rest[code.Code()]; ok {
+			// Marshal splits long options, as required by RFC 3396
+			dhcpv4.Options{code.Code(): data}.Marshal(b)
+			delete(rest, code.Code())
+		}
+	}
+	rest.Marshal(b)
+}
+
+// minSize4 returns the size a response in format f is padded to
+func minSize4(f *config.ResponseFormat) int {
+	if f != nil && f.MinSize > bootpMinLen {
+		return f.MinSize
+	}
+	return bootpMinLen
+}
@@ -0,0 +1,107 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// optionCodes returns the codes of the options of a serialized DHCPv4
+// message, in order
+func optionCodes(t *testing.T, b []byte) []uint8 {
+	t.Helper()
+	var codes []uint8
+	for i := 240; i < len(b); {
+		code := b[i]
+		if code == dhcpv4.OptionEnd.Code() {
+			return codes
+		}
+		codes = append(codes, code)
+		i += 2 + int(b[i+1])
+	}
+	t.Fatal("no End option")
+	return nil
+}
+
+func TestResponseFormat(t *testing.T) {
+	l := &listener4{formats: []config.ResponseFormat{
+		{Vendor: "MSFT", MinSize: 548},
+		{UserClass: "iPXE", OptionOrder: []dhcpv4.OptionCode{dhcpv4.OptionServerIdentifier}},
+	}}
+	windows, err := dhcpv4.NewDiscovery(benchHWAddr, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipxe, err := dhcpv4.NewDiscovery(benchHWAddr, dhcpv4.WithOption(dhcpv4.OptUserClass("iPXE")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := l.responseFormat(windows); f != &l.formats[0] {
+		t.Errorf("got format %+v for a Windows client, want the first one", f)
+	}
+	if f := l.responseFormat(ipxe); f != &l.formats[1] {
+		t.Errorf("got format %+v for an iPXE client, want the second one", f)
+	}
+	if f := l.responseFormat(other); f != nil {
+		t.Errorf("got format %+v for another client, want none", f)
+	}
+}
+
+func TestMarshalFormat4(t *testing.T) {
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.NewReplyFromRequest(discover,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithServerIP(net.IPv4(192, 0, 2, 1)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 0, 2, 1))),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IPv4(192, 0, 2, 254))),
+		dhcpv4.WithOption(dhcpv4.OptSubnetMask(net.IPv4Mask(255, 255, 255, 0))),
+		dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(time.Hour)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &config.ResponseFormat{
+		OptionOrder: []dhcpv4.OptionCode{
+			dhcpv4.OptionDHCPMessageType, dhcpv4.OptionServerIdentifier,
+			dhcpv4.OptionDomainNameServer, dhcpv4.OptionIPAddressLeaseTime,
+		},
+		MinSize: 548,
+	}
+	b := marshal4(nil, offer, f)
+	want := []uint8{53, 54, 51, 1, 3}
+	if got := optionCodes(t, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got options %v, want %v", got, want)
+	}
+	if len(b) != 548 {
+		t.Errorf("got a %d bytes response, want it padded to 548", len(b))
+	}
+
+	// the format only changes the serialization
+	parsed, err := dhcpv4.FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.ToBytes(), offer.ToBytes()) {
+		t.Errorf("response changed by its format:\ngot  %s\nwant %s", parsed.Summary(), offer.Summary())
+	}
+	if got, want := marshal4(nil, offer, &config.ResponseFormat{MinSize: 200}), offer.ToBytes(); !bytes.Equal(got, want) {
+		t.Errorf("min_size below the BOOTP minimum changed the response")
+	}
+}
@@ -5,14 +5,17 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 
+	"github.com/coredhcp/coredhcp/handler"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -20,19 +23,77 @@ import (
 // HandleMsg6 runs for every received DHCPv6 packet. It will run every
 // registered handler in sequence, and reply with the resulting response.
 // It will not reply if the resulting response is `nil`.
-func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.UDPAddr) {
-	d, err := dhcpv6.FromBytes(buf)
-	bufpool.Put(&buf)
+func (l *listener6) HandleMsg6(buf *[]byte, oob *ipv6.ControlMessage, peer net.Addr, received time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), received.Add(l.deadline))
+	defer cancel()
+	if ctx.Err() != nil {
+		bufpool.Put(buf)
+		log.Debugf("MainHandler6: dropping request from %s that waited past its deadline", peer)
+		return
+	}
+	d, err := dhcpv6.FromBytes(*buf)
+	bufpool.Put(buf)
 	if err != nil {
 		log.Printf("Error parsing DHCPv6 request: %v", err)
 		return
 	}
+	if l.relays != nil {
+		if msg, err := d.GetInnerMessage(); err == nil {
+			l.relays.count(relay6(d), msg.Type().String(), received)
+		}
+	}
 
+	var ifIndex int
+	if oob != nil {
+		ifIndex = oob.IfIndex
+	}
+	md := requestMetadata(l.Interface, ifIndex)
+	controlMetadata6(md, oob)
+	md.Context = ctx
+	md.Peer = peer
+	if up, ok := peer.(*unixPeer); ok && up.ifName != "" {
+		md.IfName = up.ifName
+	}
+	resp := process6(l.handlers, d, md)
+	if resp == nil {
+		return
+	}
+	finalize6(resp, l.preferredRatio)
+
+	var woob *ipv6.ControlMessage
+	if udp, ok := peer.(*net.UDPAddr); ok && udp.IP.IsLinkLocalUnicast() && !l.inMemory {
+		// LL need to be directed to the correct interface. Globally reachable
+		// addresses should use the default route, in case of asymetric routing.
+		switch {
+		case l.Interface.Index != 0:
+			woob = &ipv6.ControlMessage{IfIndex: l.Interface.Index}
+		case oob != nil && oob.IfIndex != 0:
+			woob = &ipv6.ControlMessage{IfIndex: oob.IfIndex}
+		default:
+			log.Errorf("HandleMsg6: Did not receive interface information")
+		}
+	}
+	if md.ResponseDSCP != 0 {
+		if woob == nil {
+			woob = &ipv6.ControlMessage{}
+		}
+		woob.TrafficClass = md.ResponseDSCP << 2
+	}
+	if _, err := l.WriteTo(resp.ToBytes(), woob, peer); err != nil {
+		log.Printf("MainHandler6: conn.Write to %v failed: %v", peer, err)
+	}
+}
+
+// process6 builds a response to the DHCPv6 message d and runs it through the
+// given handler chain, with md attached to d. Relayed messages are
+// decapsulated before being handled, and the response re-encapsulated. It
+// returns nil if no response should be sent.
+func process6(handlers []handler.Handler6, d dhcpv6.DHCPv6, md *handler.Metadata) dhcpv6.DHCPv6 {
 	// decapsulate the relay message
 	msg, err := d.GetInnerMessage()
 	if err != nil {
 		log.Warningf("DHCPv6: cannot get inner message: %v", err)
-		return
+		return nil
 	}
 
 	// Create a suitable basic response packet
@@ -47,24 +108,32 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
 		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeInformationRequest:
 		resp, err = dhcpv6.NewReplyFromMessage(msg)
+	case handler.MessageTypeAddrRegInform:
+		resp, err = newAddrRegReply(msg)
 	default:
 		err = fmt.Errorf("MainHandler6: message type %d not supported", msg.Type())
 	}
 	if err != nil {
 		log.Printf("MainHandler6: NewReplyFromDHCPv6Message failed: %v", err)
-		return
+		return nil
 	}
 
+	handler.SetMetadata6(d, md)
+	defer handler.ClearMetadata6(d)
 	var stop bool
-	for _, handler := range l.handlers {
-		resp, stop = handler(d, resp)
+	for _, h := range handlers {
+		resp, stop = h(d, resp)
 		if stop {
 			break
 		}
 	}
 	if resp == nil {
-		log.Print("MainHandler6: dropping request because response is nil")
-		return
+		log.WithField("iface", md.IfName).Print("MainHandler6: dropping request because response is nil")
+		return nil
+	}
+	if err := validate6(resp); err != nil {
+		log.WithField("iface", md.IfName).Errorf("MainHandler6: dropping invalid response to %s: %v", msg.Type(), err)
+		return nil
 	}
 
 	// if the request was relayed, re-encapsulate the response
@@ -75,124 +144,110 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 			tmp, err := dhcpv6.NewRelayReplFromRelayForw(d.(*dhcpv6.RelayMessage), rmsg)
 			if err != nil {
 				log.Warningf("DHCPv6: cannot create relay-repl from relay-forw: %v", err)
-				return
+				return nil
 			}
 			resp = tmp
 		}
 	}
-
-	var woob *ipv6.ControlMessage
-	if peer.IP.IsLinkLocalUnicast() {
-		// LL need to be directed to the correct interface. Globally reachable
-		// addresses should use the default route, in case of asymetric routing.
-		switch {
-		case l.Interface.Index != 0:
-			woob = &ipv6.ControlMessage{IfIndex: l.Interface.Index}
-		case oob != nil && oob.IfIndex != 0:
-			woob = &ipv6.ControlMessage{IfIndex: oob.IfIndex}
-		default:
-			log.Errorf("HandleMsg6: Did not receive interface information")
-		}
-	}
-	if _, err := l.WriteTo(resp.ToBytes(), woob, peer); err != nil {
-		log.Printf("MainHandler6: conn.Write to %v failed: %v", peer, err)
-	}
+	return resp
 }
 
-func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.Addr) {
-	var (
-		resp, tmp *dhcpv4.DHCPv4
-		err       error
-		stop      bool
-	)
-
-	req, err := dhcpv4.FromBytes(buf)
-	bufpool.Put(&buf)
+func (l *listener4) HandleMsg4(buf *[]byte, oob *ipv4.ControlMessage, src net.Addr, received time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), received.Add(l.deadline))
+	defer cancel()
+	if ctx.Err() != nil {
+		bufpool.Put(buf)
+		log.Debugf("MainHandler4: dropping request from %s that waited past its deadline", src)
+		return
+	}
+	req, err := dhcpv4.FromBytes(*buf)
+	bufpool.Put(buf)
 	if err != nil {
 		log.Printf("Error parsing DHCPv4 request: %v", err)
 		return
 	}
+	l.relays.count(relay4(req), req.MessageType().String(), received)
+
+	var ifIndex int
+	if oob != nil {
+		ifIndex = oob.IfIndex
+	}
+	md := requestMetadata(l.Interface, ifIndex)
+	controlMetadata4(md, oob)
+	md.Context = ctx
+	md.Peer = src
+	if up, ok := src.(*unixPeer); ok && up.ifName != "" {
+		md.IfName = up.ifName
+	}
+	resp := process4(l.handlers, req, md)
+	if resp == nil {
+		return
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeNak && !md.DryRun && !l.naks.allow(req.ClientHWAddr, time.Now()) {
+		log.Debugf("MainHandler4: suppressing NAK to %s", req.ClientHWAddr)
+		return
+	}
+	if l.offers != nil && resp.MessageType() == dhcpv4.MessageTypeOffer &&
+		l.offers.superseded(ctx, req.TransactionID, resp.ServerIdentifier()) {
+		log.Debugf("MainHandler4: another server made an offer to %s, dropping ours", req.ClientHWAddr)
+		return
+	}
 
+	dscp := md.ResponseDSCP
+	if dscp == 0 {
+		dscp = l.dscp
+	}
+	t := selectTransmit4(l, req, resp, src)
+	if err := t.send(l, resp, oob, dscp<<2, l.responseFormat(req)); err != nil {
+		log.Errorf("MainHandler4: sending response to %v failed: %v", t, err)
+	}
+}
+
+// process4 builds a reply to req and runs it through the given handler chain,
+// with md attached to req. It returns nil if no response should be sent.
+func process4(handlers []handler.Handler4, req *dhcpv4.DHCPv4, md *handler.Metadata) *dhcpv4.DHCPv4 {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		log.Printf("MainHandler4: unsupported opcode %d. Only BootRequest (%d) is supported", req.OpCode, dhcpv4.OpcodeBootRequest)
-		return
+		return nil
 	}
-	tmp, err = dhcpv4.NewReplyFromRequest(req)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
 	if err != nil {
 		log.Printf("MainHandler4: failed to build reply: %v", err)
-		return
+		return nil
 	}
 	switch mt := req.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
-		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
 	case dhcpv4.MessageTypeRequest:
-		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
 	default:
 		log.Printf("plugins/server: Unhandled message type: %v", mt)
-		return
+		return nil
 	}
 
-	resp = tmp
-	for _, handler := range l.handlers {
-		resp, stop = handler(req, resp)
+	handler.SetMetadata4(req, md)
+	defer handler.ClearMetadata4(req)
+	var stop bool
+	for _, h := range handlers {
+		resp, stop = h(req, resp)
 		if stop {
 			break
 		}
 	}
-
-	if resp != nil {
-		useEthernet := false
-		var peer *net.UDPAddr
-		if !req.GatewayIPAddr.IsUnspecified() {
-			// TODO: make RFC8357 compliant
-			peer = &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
-		} else if resp.MessageType() == dhcpv4.MessageTypeNak {
-			peer = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
-		} else if !req.ClientIPAddr.IsUnspecified() {
-			peer = &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
-		} else if req.IsBroadcast() {
-			peer = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
-		} else {
-			//sends a layer2 frame so that we can define the destination MAC address
-			peer = &net.UDPAddr{IP: resp.YourIPAddr, Port: dhcpv4.ClientPort}
-			useEthernet = true
-		}
-
-		var woob *ipv4.ControlMessage
-		if peer.IP.Equal(net.IPv4bcast) || peer.IP.IsLinkLocalUnicast() || useEthernet {
-			// Direct broadcasts, link-local and layer2 unicasts to the interface the request was
-			// received on. Other packets should use the normal routing table in
-			// case of asymetric routing
-			switch {
-			case l.Interface.Index != 0:
-				woob = &ipv4.ControlMessage{IfIndex: l.Interface.Index}
-			case oob != nil && oob.IfIndex != 0:
-				woob = &ipv4.ControlMessage{IfIndex: oob.IfIndex}
-			default:
-				log.Errorf("HandleMsg4: Did not receive interface information")
-			}
-		}
-
-		if useEthernet {
-			intf, err := net.InterfaceByIndex(woob.IfIndex)
-			if err != nil {
-				log.Errorf("MainHandler4: Can not get Interface for index %d %v", woob.IfIndex, err)
-				return
-			}
-			err = sendEthernet(*intf, resp)
-			if err != nil {
-				log.Errorf("MainHandler4: Cannot send Ethernet packet: %v", err)
-			}
-		} else {
-			if _, err := l.WriteTo(resp.ToBytes(), woob, peer); err != nil {
-				log.Errorf("MainHandler4: conn.Write to %v failed: %v", peer, err)
-			}
-		}
-	} else {
-		log.Print("MainHandler4: dropping request because response is nil")
+	if resp == nil {
+		log.WithField("iface", md.IfName).Print("MainHandler4: dropping request because response is nil")
+		return nil
+	}
+	if err := validate4(req, resp); err != nil {
+		log.WithField("iface", md.IfName).Errorf("MainHandler4: dropping invalid response to %s from %s: %v", req.MessageType(), req.ClientHWAddr, err)
+		return nil
 	}
+	return resp
 }
 
+// bufpool holds buffers for received datagrams, and for serializing DHCPv4
+// responses. Pointers to slices are stored rather than slices, so that putting
+// a buffer back into the pool does not allocate.
 // XXX: performance-wise, Pool may or may not be good (see https://github.com/golang/go/issues/23199)
 // Interface is good for what we want. Maybe "just" trust the GC and we'll be fine ?
 var bufpool = sync.Pool{New: func() interface{} { r := make([]byte, MaxDatagram); return &r }}
@@ -205,11 +260,12 @@ const MaxDatagram = 1 << 16
 // Serve6 handles datagrams received on conn and passes them to the pluginchain
 func (l *listener6) Serve() error {
 	log.Printf("Listen %s", l.LocalAddr())
+	defer l.workers.stop()
 	for {
-		b := *bufpool.Get().(*[]byte)
-		b = b[:MaxDatagram] //Reslice to max capacity in case the buffer in pool was resliced smaller
+		b := bufpool.Get().(*[]byte)
+		*b = (*b)[:MaxDatagram] //Reslice to max capacity in case the buffer in pool was resliced smaller
 
-		n, oob, peer, err := l.ReadFrom(b)
+		n, oob, peer, err := l.ReadFrom(*b)
 		if errors.Is(err, net.ErrClosed) {
 			// Server is quitting
 			return nil
@@ -217,18 +273,21 @@ func (l *listener6) Serve() error {
 			log.Printf("Error reading from connection: %v", err)
 			return err
 		}
-		go l.HandleMsg6(b[:n], oob, peer.(*net.UDPAddr))
+		*b = (*b)[:n]
+		received := time.Now()
+		l.workers.run(func() { l.HandleMsg6(b, oob, peer, received) })
 	}
 }
 
 // Serve6 handles datagrams received on conn and passes them to the pluginchain
 func (l *listener4) Serve() error {
 	log.Printf("Listen %s", l.LocalAddr())
+	defer l.workers.stop()
 	for {
-		b := *bufpool.Get().(*[]byte)
-		b = b[:MaxDatagram] //Reslice to max capacity in case the buffer in pool was resliced smaller
+		b := bufpool.Get().(*[]byte)
+		*b = (*b)[:MaxDatagram] //Reslice to max capacity in case the buffer in pool was resliced smaller
 
-		n, oob, peer, err := l.ReadFrom(b)
+		n, oob, peer, err := l.ReadFrom(*b)
 		if errors.Is(err, net.ErrClosed) {
 			// Server is quitting
 			return nil
@@ -236,6 +295,8 @@ func (l *listener4) Serve() error {
 			log.Printf("Error reading from connection: %v", err)
 			return err
 		}
-		go l.HandleMsg4(b[:n], oob, peer.(*net.UDPAddr))
+		*b = (*b)[:n]
+		received := time.Now()
+		l.workers.run(func() { l.HandleMsg4(b, oob, peer, received) })
 	}
 }
@@ -0,0 +1,219 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var benchHWAddr = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func benchHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	resp.YourIPAddr = net.IPv4(192, 0, 2, 1)
+	resp.UpdateOption(dhcpv4.OptRouter(net.IPv4(192, 0, 2, 254)))
+	return resp, false
+}
+
+func benchHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	resp.UpdateOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+	return resp, false
+}
+
+// BenchmarkProcess4 measures the datapath of a DHCPv4 request, from parsing
+// the received datagram to serializing the response, excluding socket I/O
+func BenchmarkProcess4(b *testing.B) {
+	req, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := req.ToBytes()
+	handlers := []handler.Handler4{benchHandler4}
+	out := make([]byte, MaxDatagram)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := dhcpv4.FromBytes(buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp := process4(handlers, req, &handler.Metadata{})
+		if resp == nil {
+			b.Fatal("no response")
+		}
+		out = marshal4(out, resp, nil)
+	}
+}
+
+// BenchmarkProcess6 is the DHCPv6 equivalent of BenchmarkProcess4
+func BenchmarkProcess6(b *testing.B) {
+	req, err := dhcpv6.NewSolicit(benchHWAddr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := req.ToBytes()
+	handlers := []handler.Handler6{benchHandler6}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := dhcpv6.FromBytes(buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp := process6(handlers, req, &handler.Metadata{})
+		if resp == nil {
+			b.Fatal("no response")
+		}
+		_ = resp.ToBytes()
+	}
+}
+
+// serveWindow is the number of requests BenchmarkServe4 keeps in flight. It
+// stays below pipeQueueLen so that no datagram is dropped.
+const serveWindow = 32
+
+// BenchmarkServe4 measures the throughput of a DHCPv4 listener, from reading
+// requests to writing responses on an in-memory connection, for several
+// worker pool sizes. The plugin waits 100µs, like one querying a backend.
+func BenchmarkServe4(b *testing.B) {
+	req, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := req.ToBytes()
+	backend := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		time.Sleep(100 * time.Microsecond)
+		return benchHandler4(req, resp)
+	}
+	for _, workers := range []int{0, 1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			client, serverConn := NewPipe(
+				&net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort},
+				&net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort},
+			)
+			l := &listener4{
+				conn4:    memConn4{serverConn},
+				handlers: []handler.Handler4{backend},
+				workers:  newWorkers(workers),
+				deadline: defaultDeadline4,
+				inMemory: true,
+			}
+			done := make(chan error, 1)
+			go func() { done <- l.Serve() }()
+			defer func() {
+				l.Close()
+				<-done
+			}()
+			out := make([]byte, MaxDatagram)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for sent := 0; sent < b.N; {
+				window := min(serveWindow, b.N-sent)
+				for i := 0; i < window; i++ {
+					if _, err := client.WriteTo(buf, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+					b.Fatal(err)
+				}
+				for i := 0; i < window; i++ {
+					if _, _, err := client.ReadFrom(out); err != nil {
+						b.Fatal(err)
+					}
+				}
+				sent += window
+			}
+		})
+	}
+}
+
+func TestProcessMetadata(t *testing.T) {
+	md := &handler.Metadata{IfIndex: 2, IfName: "eth0"}
+
+	var got4 *handler.Metadata
+	h4 := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		got4 = handler.Metadata4(req)
+		return resp, false
+	}
+	req4, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process4([]handler.Handler4{h4}, req4, md)
+	if got4 != md {
+		t.Errorf("DHCPv4 handler got metadata %v, want %v", got4, md)
+	}
+	if handler.Metadata4(req4) != nil {
+		t.Error("DHCPv4 metadata was not cleared after processing")
+	}
+
+	var got6 *handler.Metadata
+	h6 := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		got6 = handler.Metadata6(req)
+		return resp, false
+	}
+	req6, err := dhcpv6.NewSolicit(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process6([]handler.Handler6{h6}, req6, md)
+	if got6 != md {
+		t.Errorf("DHCPv6 handler got metadata %v, want %v", got6, md)
+	}
+	if handler.Metadata6(req6) != nil {
+		t.Error("DHCPv6 metadata was not cleared after processing")
+	}
+}
+
+func TestDeadline4(t *testing.T) {
+	client, server := NewPipe(&net.UDPAddr{}, &net.UDPAddr{})
+	defer client.Close()
+	var (
+		called   bool
+		deadline time.Time
+	)
+	l := &listener4{
+		conn4: memConn4{server},
+		handlers: []handler.Handler4{func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			called = true
+			deadline, _ = handler.Context4(req).Deadline()
+			return resp, false
+		}},
+		deadline: time.Minute,
+		inMemory: true,
+	}
+	req, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := func(received time.Time) {
+		// HandleMsg4 puts the buffer back in the pool
+		buf := bufpool.Get().(*[]byte)
+		*buf = append((*buf)[:0], req.ToBytes()...)
+		l.HandleMsg4(buf, nil, client.LocalAddr(), received)
+	}
+
+	received := time.Now()
+	handle(received)
+	if !called {
+		t.Fatal("handler was not called")
+	}
+	if want := received.Add(time.Minute); !deadline.Equal(want) {
+		t.Errorf("got deadline %s, want %s", deadline, want)
+	}
+
+	called = false
+	handle(time.Now().Add(-2 * time.Minute))
+	if called {
+		t.Error("handler was called for a request past its deadline")
+	}
+}
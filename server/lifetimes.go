@@ -0,0 +1,82 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// infiniteLifetime is the lifetime value meaning infinity, RFC 8415 section 7.7
+const infiniteLifetime = math.MaxUint32 * time.Second
+
+// finalize6 applies the server policies to the response resp, once the
+// handler chain is done with it
+func finalize6(resp dhcpv6.DHCPv6, preferredRatio float64) {
+	if preferredRatio == 0 || preferredRatio == 1 {
+		return
+	}
+	msg, err := resp.GetInnerMessage()
+	if err != nil {
+		return
+	}
+	applyPreferredRatio(msg, preferredRatio)
+}
+
+// applyPreferredRatio shortens the preferred lifetimes of the addresses and
+// prefixes of msg that were given the same preferred and valid lifetimes, to
+// ratio times the valid lifetime. Clients then stop using them for new
+// connections before they expire, which is what makes renumbering smooth.
+// Renewal times beyond the new preferred lifetimes are brought back to the
+// values recommended by RFC 8415 section 21.4.
+func applyPreferredRatio(msg *dhcpv6.Message, ratio float64) {
+	for _, iana := range msg.Options.IANA() {
+		var shortest time.Duration
+		for _, addr := range iana.Options.Addresses() {
+			if preferred, ok := ratioLifetime(addr.PreferredLifetime, addr.ValidLifetime, ratio); ok {
+				addr.PreferredLifetime = preferred
+				shortest = shorterLifetime(shortest, preferred)
+			}
+		}
+		iana.T1, iana.T2 = renewalTimes(iana.T1, iana.T2, shortest)
+	}
+	for _, iapd := range msg.Options.IAPD() {
+		var shortest time.Duration
+		for _, prefix := range iapd.Options.Prefixes() {
+			if preferred, ok := ratioLifetime(prefix.PreferredLifetime, prefix.ValidLifetime, ratio); ok {
+				prefix.PreferredLifetime = preferred
+				shortest = shorterLifetime(shortest, preferred)
+			}
+		}
+		iapd.T1, iapd.T2 = renewalTimes(iapd.T1, iapd.T2, shortest)
+	}
+}
+
+// ratioLifetime returns the preferred lifetime to use instead of preferred,
+// and false if it is left alone. Deprecated and infinite lifetimes are kept.
+func ratioLifetime(preferred, valid time.Duration, ratio float64) (time.Duration, bool) {
+	if preferred != valid || valid == 0 || valid >= infiniteLifetime {
+		return 0, false
+	}
+	return time.Duration(float64(valid) * ratio).Truncate(time.Second), true
+}
+
+func shorterLifetime(a, b time.Duration) time.Duration {
+	if a == 0 || b < a {
+		return b
+	}
+	return a
+}
+
+// renewalTimes returns T1 and T2 so that clients renew before preferred ends.
+// A zero preferred lifetime means no lifetime was changed.
+func renewalTimes(t1, t2, preferred time.Duration) (time.Duration, time.Duration) {
+	if preferred == 0 || (t1 <= preferred && t2 <= preferred) {
+		return t1, t2
+	}
+	return preferred / 2, preferred * 4 / 5
+}
@@ -0,0 +1,106 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestApplyPreferredRatio(t *testing.T) {
+	testcases := []struct {
+		name             string
+		preferred, valid time.Duration
+		t1, t2           time.Duration
+		wantPreferred    time.Duration
+		wantT1, wantT2   time.Duration
+	}{
+		{"equal", time.Hour, time.Hour, 0, 0, 30 * time.Minute, 0, 0},
+		{"renewal kept", time.Hour, time.Hour, 10 * time.Minute, 20 * time.Minute, 30 * time.Minute, 10 * time.Minute, 20 * time.Minute},
+		{"renewal shortened", time.Hour, time.Hour, 30 * time.Minute, 48 * time.Minute, 30 * time.Minute, 15 * time.Minute, 24 * time.Minute},
+		{"already shorter", 20 * time.Minute, time.Hour, 45 * time.Minute, 50 * time.Minute, 20 * time.Minute, 45 * time.Minute, 50 * time.Minute},
+		{"deprecated", 0, time.Hour, 0, 0, 0, 0, 0},
+		{"infinite", infiniteLifetime, infiniteLifetime, 0, 0, infiniteLifetime, 0, 0},
+	}
+	for _, tc := range testcases {
+		msg, err := dhcpv6.NewMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.MessageType = dhcpv6.MessageTypeReply
+		msg.AddOption(&dhcpv6.OptIANA{
+			T1: tc.t1,
+			T2: tc.t2,
+			Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{&dhcpv6.OptIAAddress{
+				IPv6Addr:          net.ParseIP("2001:db8::10"),
+				PreferredLifetime: tc.preferred,
+				ValidLifetime:     tc.valid,
+			}}},
+		})
+		msg.AddOption(&dhcpv6.OptIAPD{
+			T1: tc.t1,
+			T2: tc.t2,
+			Options: dhcpv6.PDOptions{Options: []dhcpv6.Option{&dhcpv6.OptIAPrefix{
+				Prefix:            &net.IPNet{IP: net.ParseIP("2001:db8:1::"), Mask: net.CIDRMask(56, 128)},
+				PreferredLifetime: tc.preferred,
+				ValidLifetime:     tc.valid,
+			}}},
+		})
+
+		applyPreferredRatio(msg, 0.5)
+
+		iana := msg.Options.OneIANA()
+		addr := iana.Options.OneAddress()
+		if addr.PreferredLifetime != tc.wantPreferred || addr.ValidLifetime != tc.valid {
+			t.Errorf("%s: got address lifetimes %s/%s, want %s/%s", tc.name,
+				addr.PreferredLifetime, addr.ValidLifetime, tc.wantPreferred, tc.valid)
+		}
+		if iana.T1 != tc.wantT1 || iana.T2 != tc.wantT2 {
+			t.Errorf("%s: got IA_NA T1/T2 %s/%s, want %s/%s", tc.name, iana.T1, iana.T2, tc.wantT1, tc.wantT2)
+		}
+		iapd := msg.Options.OneIAPD()
+		prefix := iapd.Options.Prefixes()[0]
+		if prefix.PreferredLifetime != tc.wantPreferred || prefix.ValidLifetime != tc.valid {
+			t.Errorf("%s: got prefix lifetimes %s/%s, want %s/%s", tc.name,
+				prefix.PreferredLifetime, prefix.ValidLifetime, tc.wantPreferred, tc.valid)
+		}
+		if iapd.T1 != tc.wantT1 || iapd.T2 != tc.wantT2 {
+			t.Errorf("%s: got IA_PD T1/T2 %s/%s, want %s/%s", tc.name, iapd.T1, iapd.T2, tc.wantT1, tc.wantT2)
+		}
+	}
+}
+
+func TestFinalize6Relayed(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageType = dhcpv6.MessageTypeReply
+	msg.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{&dhcpv6.OptIAAddress{
+		IPv6Addr:          net.ParseIP("2001:db8::10"),
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     time.Hour,
+	}}}})
+	relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayReply, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finalize6(relay, 0.75)
+	got, err := dhcpv6.FromBytes(relay.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := got.GetInnerMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl := inner.Options.OneIANA().Options.OneAddress().PreferredLifetime; pl != 45*time.Minute {
+		t.Errorf("got preferred lifetime %s in the relayed reply, want 45m", pl)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// controlMetadata4 adds what the control message of a received DHCPv4 packet
+// tells about it to md
+func controlMetadata4(md *handler.Metadata, oob *ipv4.ControlMessage) {
+	if oob == nil {
+		return
+	}
+	md.Dst = oob.Dst
+	md.HopLimit = oob.TTL
+}
+
+// controlMetadata6 adds what the control message of a received DHCPv6 packet
+// tells about it to md
+func controlMetadata6(md *handler.Metadata, oob *ipv6.ControlMessage) {
+	if oob == nil {
+		return
+	}
+	md.Dst = oob.Dst
+	md.HopLimit = oob.HopLimit
+	md.DSCP = oob.TrafficClass >> 2
+}
+
+// tosWriter is implemented by DHCPv4 connections that can set the type of
+// service byte of single datagrams. The ipv4 package only lets it be set for
+// the whole socket.
+type tosWriter interface {
+	writeToTOS(b []byte, cm *ipv4.ControlMessage, dst net.Addr, tos int) (int, error)
+}
+
+// udpConn4 is a DHCPv4 UDP socket
+type udpConn4 struct {
+	*ipv4.PacketConn
+	udp *net.UDPConn
+}
+
+func (c udpConn4) writeToTOS(b []byte, cm *ipv4.ControlMessage, dst net.Addr, tos int) (int, error) {
+	addr, ok := dst.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("cannot mark datagrams to %s", dst)
+	}
+	n, _, err := c.udp.WriteMsgUDP(b, append(cm.Marshal(), tosControlMessage(tos)...), addr)
+	return n, err
+}
+
+// tosControlMessage returns the control message setting the type of service
+// byte of a datagram to tos
+func tosControlMessage(tos int) []byte {
+	b := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = syscall.IPPROTO_IP
+	h.Type = syscall.IP_TOS
+	h.SetLen(syscall.CmsgLen(4))
+	binary.NativeEndian.PutUint32(b[syscall.CmsgLen(0):], uint32(tos))
+	return b
+}
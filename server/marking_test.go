@@ -0,0 +1,73 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestControlMetadata(t *testing.T) {
+	var md handler.Metadata
+	controlMetadata4(&md, nil)
+	assert.Equal(t, handler.Metadata{}, md)
+
+	controlMetadata4(&md, &ipv4.ControlMessage{TTL: 61, Dst: net.IPv4(192, 0, 2, 1)})
+	assert.Equal(t, 61, md.HopLimit)
+	assert.True(t, md.Dst.Equal(net.IPv4(192, 0, 2, 1)))
+
+	md = handler.Metadata{}
+	controlMetadata6(&md, &ipv6.ControlMessage{HopLimit: 255, TrafficClass: 46<<2 | 1, Dst: net.ParseIP("ff02::1:2")})
+	assert.Equal(t, 255, md.HopLimit)
+	assert.Equal(t, 46, md.DSCP)
+	assert.True(t, md.Dst.Equal(net.ParseIP("ff02::1:2")))
+}
+
+func TestTOSControlMessage(t *testing.T) {
+	msgs, err := syscall.ParseSocketControlMessage(tosControlMessage(0xb8))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, int32(syscall.IPPROTO_IP), msgs[0].Header.Level)
+	assert.Equal(t, int32(syscall.IP_TOS), msgs[0].Header.Type)
+	assert.Equal(t, uint32(0xb8), binary.NativeEndian.Uint32(msgs[0].Data))
+}
+
+func TestWriteToTOS(t *testing.T) {
+	dst, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer dst.Close()
+	raw, err := dst.SyscallConn()
+	require.NoError(t, err)
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+	}))
+	require.NoError(t, err)
+	src, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer src.Close()
+
+	c := udpConn4{PacketConn: ipv4.NewPacketConn(src), udp: src}
+	_, err = c.writeToTOS([]byte("marked"), nil, dst.LocalAddr(), 0xb8)
+	require.NoError(t, err)
+
+	require.NoError(t, dst.SetReadDeadline(time.Now().Add(time.Second)))
+	b, oob := make([]byte, 16), make([]byte, 64)
+	n, oobn, _, _, err := dst.ReadMsgUDP(b, oob)
+	require.NoError(t, err)
+	assert.Equal(t, "marked", string(b[:n]))
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, byte(0xb8), msgs[0].Data[0], "received TOS")
+}
@@ -0,0 +1,64 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/u-root/uio/uio"
+)
+
+const (
+	// bootpMinLen is the minimum length of a BOOTP message, see RFC951
+	bootpMinLen = 300
+)
+
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+// marshal4 serializes d into the storage of buf, which is grown if it is
+// too small, and returns the serialized message. With a nil format, it
+// produces the same output as d.ToBytes(), which allocates a new buffer for
+// every message; this is checked by TestMarshal4 and FuzzMarshal4. Only
+// DHCPv4 responses are serialized this way, DHCPv6 ones still use ToBytes.
+func marshal4(buf []byte, d *dhcpv4.DHCPv4, f *config.ResponseFormat) []byte {
+	b := uio.NewBigEndianBuffer(buf[:0])
+	b.Write8(uint8(d.OpCode))
+	b.Write8(uint8(d.HWType))
+	b.Write8(uint8(len(d.ClientHWAddr)))
+	b.Write8(d.HopCount)
+	b.WriteBytes(d.TransactionID[:])
+	b.Write16(d.NumSeconds)
+	b.Write16(d.Flags)
+
+	writeIP4(b, d.ClientIPAddr)
+	writeIP4(b, d.YourIPAddr)
+	writeIP4(b, d.ServerIPAddr)
+	writeIP4(b, d.GatewayIPAddr)
+	copy(b.WriteN(16), d.ClientHWAddr)
+	copy(b.WriteN(64)[:63], d.ServerHostName)
+	copy(b.WriteN(128)[:127], d.BootFileName)
+	b.WriteBytes(magicCookie[:])
+
+	marshalOptions4(b, d.Options, f)
+	b.Write8(dhcpv4.OptionEnd.Code())
+	// Some relays and servers drop messages shorter than a BOOTP message
+	if n := minSize4(f) - b.Len(); n > 0 {
+		pad := b.WriteN(n)
+		for i := range pad {
+			pad[i] = dhcpv4.OptionPad.Code()
+		}
+	}
+	return b.Data()
+}
+
+func writeIP4(b *uio.Lexer, ip net.IP) {
+	dst := b.WriteN(net.IPv4len)
+	if ip == nil {
+		return
+	}
+	copy(dst, ip.To4())
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestMarshal4(t *testing.T) {
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := dhcpv4.NewReplyFromRequest(discover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := dhcpv4.NewReplyFromRequest(discover,
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 10)),
+		dhcpv4.WithServerIP(net.IPv4(192, 0, 2, 1)),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.IPv4(192, 0, 2, 254))),
+		dhcpv4.WithOption(dhcpv4.OptDNS(net.IPv4(192, 0, 2, 53), net.IPv4(192, 0, 2, 54))),
+		dhcpv4.WithOption(dhcpv4.OptHostName(strings.Repeat("h", 255))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large.ServerHostName = strings.Repeat("s", 100)
+	large.BootFileName = strings.Repeat("f", 200)
+	nilIPs := &dhcpv4.DHCPv4{OpCode: dhcpv4.OpcodeBootReply, Options: dhcpv4.Options{}}
+
+	// Start from a dirty buffer, as it would come from the pool
+	buf := bytes.Repeat([]byte{0xff}, MaxDatagram)
+	for _, m := range []*dhcpv4.DHCPv4{small, large, nilIPs} {
+		got := marshal4(buf, m, nil)
+		if want := m.ToBytes(); !bytes.Equal(got, want) {
+			t.Errorf("marshal4 differs from ToBytes for %s:\ngot  %x\nwant %x", m.Summary(), got, want)
+		}
+	}
+}
+
+func FuzzMarshal4(f *testing.F) {
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(discover.ToBytes())
+	buf := make([]byte, MaxDatagram)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := dhcpv4.FromBytes(data)
+		if err != nil {
+			return
+		}
+		if got, want := marshal4(buf, m, nil), m.ToBytes(); !bytes.Equal(got, want) {
+			t.Errorf("marshal4 differs from ToBytes for %x:\ngot  %x\nwant %x", data, got, want)
+		}
+	})
+}
+
+func TestMarshal4Allocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are not reliable under the race detector")
+	}
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(discover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxDatagram)
+	// Options.Marshal sorts the option codes in a new slice, nothing else
+	// should allocate
+	allocs := testing.AllocsPerRun(100, func() { buf = marshal4(buf, resp, nil) })
+	if allocs > 1 {
+		t.Errorf("marshal4 made %v allocations, want at most 1", allocs)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// pipeQueueLen is the number of datagrams an end of a pipe can hold before
+// it starts dropping them
+const pipeQueueLen = 64
+
+type datagram struct {
+	b    []byte
+	from net.Addr
+}
+
+// pipeConn is one end of an in-memory datagram pipe
+type pipeConn struct {
+	local net.Addr
+	in    chan datagram
+	peer  *pipeConn
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// NewPipe returns the two ends of an in-memory datagram connection, with
+// local addresses a and b. Whatever is written to one end is read from the
+// other, regardless of the destination address. Like with UDP, datagrams are
+// dropped when the reading end is not keeping up.
+func NewPipe(a, b net.Addr) (net.PacketConn, net.PacketConn) {
+	ca := &pipeConn{local: a, in: make(chan datagram, pipeQueueLen), done: make(chan struct{})}
+	cb := &pipeConn{local: b, in: make(chan datagram, pipeQueueLen), done: make(chan struct{})}
+	ca.peer, cb.peer = cb, ca
+	return ca, cb
+}
+
+// ReadFrom implements net.PacketConn. Changing the read deadline does not
+// affect a read that is already blocked.
+func (c *pipeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case d := <-c.in:
+		return copy(b, d.b), d.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo implements net.PacketConn
+func (c *pipeConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	d := datagram{b: append([]byte(nil), b...), from: c.local}
+	select {
+	case c.peer.in <- d:
+	default:
+		// queue full, drop
+	}
+	return len(b), nil
+}
+
+// Close implements net.PacketConn
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+// LocalAddr implements net.PacketConn
+func (c *pipeConn) LocalAddr() net.Addr { return c.local }
+
+// SetDeadline implements net.PacketConn
+func (c *pipeConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+// SetReadDeadline implements net.PacketConn
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn. Writes never block, so it is a
+// no-op.
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
+
+// memConn4 and memConn6 adapt a net.PacketConn to the listeners, without
+// control messages
+type memConn4 struct{ net.PacketConn }
+
+func (c memConn4) ReadFrom(b []byte) (int, *ipv4.ControlMessage, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	return n, nil, addr, err
+}
+
+func (c memConn4) WriteTo(b []byte, _ *ipv4.ControlMessage, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, dst)
+}
+
+type memConn6 struct{ net.PacketConn }
+
+func (c memConn6) ReadFrom(b []byte) (int, *ipv6.ControlMessage, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	return n, nil, addr, err
+}
+
+func (c memConn6) WriteTo(b []byte, _ *ipv6.ControlMessage, dst net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(b, dst)
+}
+
+// StartInMemory is like Start, but serves over in-memory connections instead
+// of sockets, so that a configured server can be tested against synthetic
+// clients without privileges. One listener is started for each of Server4 and
+// Server6 that is set in config, ignoring the configured addresses. It returns
+// the client ends of the DHCPv4 and DHCPv6 connections, which are nil when the
+// corresponding server is not configured. The server sees the client ends as
+// 0.0.0.0:68 and [fe80::1]:546.
+func StartInMemory(config *config.Config) (srv *Servers, client4, client6 net.PacketConn, err error) {
+	handlers4, handlers6, err := loadPlugins(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	srv = &Servers{
+		errors: make(chan error),
+		config: config,
+	}
+
+	if config.Server6 != nil {
+		var serverConn net.PacketConn
+		client6, serverConn = NewPipe(
+			&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort},
+			&net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultServerPort},
+		)
+		l6 := &listener6{
+			conn6:          memConn6{serverConn},
+			handlers:       handlers6,
+			workers:        newWorkers(config.Server6.Workers),
+			deadline:       deadline(config.Server6.Deadline, defaultDeadline6),
+			relays:         newRelayStats(config.Server6.RelayStatsInterval),
+			inMemory:       true,
+			preferredRatio: config.Server6.PreferredRatio,
+		}
+		srv.listeners = append(srv.listeners, l6)
+		go func() {
+			srv.errors <- l6.Serve()
+		}()
+	}
+
+	if config.Server4 != nil {
+		var serverConn net.PacketConn
+		client4, serverConn = NewPipe(
+			&net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort},
+			&net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort},
+		)
+		l4 := &listener4{
+			conn4:    memConn4{serverConn},
+			handlers: handlers4,
+			workers:  newWorkers(config.Server4.Workers),
+			naks:     newNakLimiter(config.Server4.NakInterval),
+			deadline: deadline(config.Server4.Deadline, defaultDeadline4),
+			relays:   newRelayStats(config.Server4.RelayStatsInterval),
+			formats:  config.Server4.ResponseFormats,
+			inMemory: true,
+		}
+		srv.listeners = append(srv.listeners, l4)
+		go func() {
+			srv.errors <- l4.Serve()
+		}()
+	}
+
+	return srv, client4, client6, nil
+}
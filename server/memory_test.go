@@ -0,0 +1,100 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := NewPipe(&net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2})
+	if _, err := a.WriteTo([]byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" || from != a.LocalAddr() {
+		t.Errorf("read %q from %v, want %q from %v", buf[:n], from, "hello", a.LocalAddr())
+	}
+
+	if err := b.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.ReadFrom(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read past deadline returned %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	b.Close()
+	if _, _, err := b.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read on closed pipe returned %v, want %v", err, net.ErrClosed)
+	}
+}
+
+// exchange sends req on conn and returns the next datagram received
+func exchange(t *testing.T, conn net.PacketConn, req []byte) []byte {
+	t.Helper()
+	if _, err := conn.WriteTo(req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxDatagram)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestStartInMemory(t *testing.T) {
+	srv, client4, client6, err := StartInMemory(&config.Config{
+		Server4: &config.ServerConfig{},
+		Server6: &config.ServerConfig{Workers: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.FromBytes(exchange(t, client4, discover.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.MessageType() != dhcpv4.MessageTypeOffer || offer.TransactionID != discover.TransactionID {
+		t.Errorf("got %s, want an OFFER for %s", offer.Summary(), discover.TransactionID)
+	}
+
+	solicit, err := dhcpv6.NewSolicit(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertise, err := dhcpv6.FromBytes(exchange(t, client6, solicit.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if advertise.Type() != dhcpv6.MessageTypeAdvertise {
+		t.Errorf("got %s, want %s", advertise.Type(), dhcpv6.MessageTypeAdvertise)
+	}
+
+	srv.Close()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("server did not stop cleanly: %v", err)
+	}
+}
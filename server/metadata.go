@@ -0,0 +1,39 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// ifaceNames caches interface names by index, as looking an interface up
+// for every request is expensive. Indexes are not reused by the kernel until
+// they wrap around, so entries don't need to be invalidated.
+var ifaceNames sync.Map
+
+// requestMetadata builds the metadata of a request received on a listener
+// bound to the interface bound (if its index is not 0), or on the interface
+// with index ifIndex according to the control message
+func requestMetadata(bound net.Interface, ifIndex int) *handler.Metadata {
+	if bound.Index != 0 {
+		return &handler.Metadata{IfIndex: bound.Index, IfName: bound.Name}
+	}
+	md := handler.Metadata{IfIndex: ifIndex}
+	if ifIndex == 0 {
+		return &md
+	}
+	if name, ok := ifaceNames.Load(ifIndex); ok {
+		md.IfName = name.(string)
+	} else if ifi, err := net.InterfaceByIndex(ifIndex); err == nil {
+		ifaceNames.Store(ifIndex, ifi.Name)
+		md.IfName = ifi.Name
+	} else {
+		log.Warningf("Could not find interface with index %d: %v", ifIndex, err)
+	}
+	return &md
+}
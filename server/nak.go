@@ -0,0 +1,73 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// nakReportInterval is how often suppressed NAKs are reported
+const nakReportInterval = time.Minute
+
+// nakLimiter limits the rate of NAKs sent to each client. NAKs are broadcast,
+// so clients looping on NAKs after a renumbering can flood a whole segment.
+type nakLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// last holds the time the last NAK was sent, by hardware address
+	last       map[string]time.Time
+	suppressed int
+	lastReport time.Time
+}
+
+func newNakLimiter(interval time.Duration) *nakLimiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &nakLimiter{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		lastReport: time.Now(),
+	}
+}
+
+// allow returns whether a NAK can be sent to hwaddr at now, and records it
+// if so. A nil limiter allows everything.
+func (n *nakLimiter) allow(hwaddr net.HardwareAddr, now time.Time) bool {
+	if n == nil {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.lastReport) >= nakReportInterval {
+		n.report(now)
+	}
+	key := string(hwaddr)
+	if last, ok := n.last[key]; ok && now.Sub(last) < n.interval {
+		n.suppressed++
+		return false
+	}
+	n.last[key] = now
+	return true
+}
+
+// report logs the number of NAKs suppressed since the last report, and forgets
+// about clients that can be sent a NAK again. Must be called with mu held.
+func (n *nakLimiter) report(now time.Time) {
+	if n.suppressed > 0 {
+		log.Warningf("Suppressed %d NAKs in the last %s (limit: one per client every %s)",
+			n.suppressed, now.Sub(n.lastReport).Round(time.Second), n.interval)
+	}
+	for key, last := range n.last {
+		if now.Sub(last) >= n.interval {
+			delete(n.last, key)
+		}
+	}
+	n.suppressed = 0
+	n.lastReport = now
+}
@@ -0,0 +1,47 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNakLimiter(t *testing.T) {
+	if !(*nakLimiter)(nil).allow(benchHWAddr, time.Now()) {
+		t.Error("nil limiter suppressed a NAK")
+	}
+
+	n := newNakLimiter(10 * time.Second)
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	start := n.lastReport
+	steps := []struct {
+		hwaddr net.HardwareAddr
+		after  time.Duration
+		want   bool
+	}{
+		{benchHWAddr, 0, true},
+		{benchHWAddr, time.Second, false},
+		{other, time.Second, true},
+		{benchHWAddr, 9 * time.Second, false},
+		{benchHWAddr, 10 * time.Second, true},
+		{benchHWAddr, 11 * time.Second, false},
+	}
+	for i, s := range steps {
+		if got := n.allow(s.hwaddr, start.Add(s.after)); got != s.want {
+			t.Errorf("step %d: NAK to %s after %s: allowed=%v, want %v", i, s.hwaddr, s.after, got, s.want)
+		}
+	}
+	if n.suppressed != 3 {
+		t.Errorf("counted %d suppressed NAKs, want 3", n.suppressed)
+	}
+
+	// Reports reset the count and forget old entries
+	n.allow(benchHWAddr, start.Add(nakReportInterval+time.Hour))
+	if n.suppressed != 0 || len(n.last) != 1 {
+		t.Errorf("after report: %d suppressed, %d clients tracked, want 0 and 1", n.suppressed, len(n.last))
+	}
+}
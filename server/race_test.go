@@ -0,0 +1,11 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build race
+
+package server
+
+// raceEnabled reports whether the tests run under the race detector, which
+// instruments memory accesses and makes allocation counts unreliable
+const raceEnabled = true
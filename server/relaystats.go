@@ -0,0 +1,178 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Anomaly detection parameters of relayStats: a message type is reported when
+// a relay sends at least relayAnomalyMin messages of that type in an interval,
+// and more than relayAnomalyFactor times its moving average. Relays are only
+// checked once their average covers relayBaselineIntervals intervals.
+const (
+	relayAnomalyMin        = 20
+	relayAnomalyFactor     = 4
+	relayBaselineIntervals = 3
+	// relayAverageWeight is the weight of the last interval in the moving
+	// average
+	relayAverageWeight = 0.3
+)
+
+// directRelay is the relay name used for requests received without relay
+const directRelay = "direct"
+
+// relayStats counts the requests received through each relay agent, by
+// message type. At the end of each interval, it logs the message mix of every
+// relay, and warns about sudden changes, such as a spike of DECLINEs from one
+// relay, which suggests address conflicts on the links behind it.
+type relayStats struct {
+	interval time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	relays map[string]*relayCounts
+}
+
+type relayCounts struct {
+	// current holds the counts of the current interval, by message type
+	current map[string]int
+	// average holds the moving average of the counts, by message type
+	average   map[string]float64
+	intervals int
+}
+
+func newRelayStats(interval time.Duration) *relayStats {
+	if interval <= 0 {
+		return nil
+	}
+	return &relayStats{
+		interval: interval,
+		start:    time.Now(),
+		relays:   make(map[string]*relayCounts),
+	}
+}
+
+// relay4 returns the relay name of a DHCPv4 request: its gateway address
+func relay4(req *dhcpv4.DHCPv4) string {
+	if req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified() {
+		return directRelay
+	}
+	return req.GatewayIPAddr.String()
+}
+
+// relay6 returns the relay name of a DHCPv6 message: the link address of the
+// relay closest to the client
+func relay6(d dhcpv6.DHCPv6) string {
+	var relay *dhcpv6.RelayMessage
+	for d.IsRelay() {
+		relay = d.(*dhcpv6.RelayMessage)
+		inner, err := dhcpv6.DecapsulateRelay(d)
+		if err != nil {
+			break
+		}
+		d = inner
+	}
+	if relay == nil {
+		return directRelay
+	}
+	return relay.LinkAddr.String()
+}
+
+// count records a message of type msgType received through relay at now. A
+// nil relayStats counts nothing.
+func (s *relayStats) count(relay, msgType string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.start) >= s.interval {
+		s.report(now)
+	}
+	r, ok := s.relays[relay]
+	if !ok {
+		r = &relayCounts{current: make(map[string]int), average: make(map[string]float64)}
+		s.relays[relay] = r
+	}
+	r.current[msgType]++
+}
+
+// report logs the counts of the interval ending at now, and updates the moving
+// averages. Must be called with mu held.
+func (s *relayStats) report(now time.Time) {
+	elapsed := now.Sub(s.start).Round(time.Second)
+	for name, r := range s.relays {
+		if len(r.current) > 0 {
+			log.Debugf("Relay %s in the last %s: %s", name, elapsed, r.mix())
+		}
+		for _, msgType := range r.anomalies() {
+			log.Warningf("Relay %s sent %d %s in the last %s, %.1f on average: possible anomaly on its links",
+				name, r.current[msgType], msgType, elapsed, r.average[msgType])
+		}
+		idle := true
+		for msgType := range r.current {
+			if _, ok := r.average[msgType]; !ok {
+				r.average[msgType] = 0
+			}
+		}
+		for msgType, avg := range r.average {
+			avg += relayAverageWeight * (float64(r.current[msgType]) - avg)
+			if avg < 0.5 && r.current[msgType] == 0 {
+				delete(r.average, msgType)
+				continue
+			}
+			r.average[msgType] = avg
+			idle = false
+		}
+		if idle {
+			delete(s.relays, name)
+			continue
+		}
+		r.current = make(map[string]int)
+		r.intervals++
+	}
+	s.start = now
+}
+
+// anomalies returns the message types whose count in the current interval is
+// out of proportion with their average
+func (r *relayCounts) anomalies() []string {
+	if r.intervals < relayBaselineIntervals {
+		return nil
+	}
+	var types []string
+	for msgType, n := range r.current {
+		if n >= relayAnomalyMin && float64(n) > relayAnomalyFactor*r.average[msgType] {
+			types = append(types, msgType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// mix formats the counts of the current interval, by message type
+func (r *relayCounts) mix() string {
+	types := make([]string, 0, len(r.current))
+	for msgType := range r.current {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	var b strings.Builder
+	for i, msgType := range types {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d %s", r.current[msgType], msgType)
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestRelayStats(t *testing.T) {
+	s := newRelayStats(time.Minute)
+	now := s.start
+	// a steady baseline of 10 DISCOVERs and 1 DECLINE per minute
+	for i := 0; i < relayBaselineIntervals; i++ {
+		for j := 0; j < 10; j++ {
+			s.count("192.0.2.1", "DISCOVER", now)
+		}
+		s.count("192.0.2.1", "DECLINE", now)
+		now = now.Add(time.Minute)
+		s.count("192.0.2.2", "DISCOVER", now)
+	}
+	r := s.relays["192.0.2.1"]
+	if got := r.anomalies(); got != nil {
+		t.Errorf("got anomalies %v for the baseline", got)
+	}
+
+	for j := 0; j < 10; j++ {
+		s.count("192.0.2.1", "DISCOVER", now)
+	}
+	for j := 0; j < relayAnomalyMin; j++ {
+		s.count("192.0.2.1", "DECLINE", now)
+	}
+	if got, want := r.anomalies(), []string{"DECLINE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got anomalies %v, want %v", got, want)
+	}
+	if got, want := r.mix(), "20 DECLINE, 10 DISCOVER"; got != want {
+		t.Errorf("got mix %q, want %q", got, want)
+	}
+
+	// idle relays are forgotten
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Minute)
+		s.count("192.0.2.2", "DISCOVER", now)
+	}
+	if _, ok := s.relays["192.0.2.1"]; ok {
+		t.Error("idle relay was not forgotten")
+	}
+
+	var nilStats *relayStats
+	nilStats.count("192.0.2.1", "DISCOVER", now)
+}
+
+func TestRelayName(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relay4(req); got != directRelay {
+		t.Errorf("got relay %s for a direct DHCPv4 request", got)
+	}
+	req.GatewayIPAddr = net.IPv4(192, 0, 2, 1)
+	if got := relay4(req); got != "192.0.2.1" {
+		t.Errorf("got relay %s, want 192.0.2.1", got)
+	}
+
+	solicit, err := dhcpv6.NewSolicit(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relay6(solicit); got != directRelay {
+		t.Errorf("got relay %s for a direct DHCPv6 message", got)
+	}
+	inner, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8:1::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relay6(outer); got != "2001:db8:1::1" {
+		t.Errorf("got relay %s, want the link address of the relay closest to the client", got)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// SelfTestHWAddr is the hardware address used by the synthetic client of the
+// self-test. It is a locally administered address, so it should not collide
+// with real clients. Self-test requests are marked as dry runs in their
+// metadata, so that allocating plugins do not keep a lease for this client.
+var SelfTestHWAddr = net.HardwareAddr{0x02, 0x00, 0x00, 0x5e, 0x00, 0x53}
+
+// selfTestMetadata returns the metadata of a self-test request, which is not
+// received on any interface
+func selfTestMetadata() *handler.Metadata {
+	return &handler.Metadata{IfName: "selftest", DryRun: true}
+}
+
+// selfTest runs a full exchange (DISCOVER/OFFER/REQUEST/ACK for DHCPv4,
+// SOLICIT/ADVERTISE/REQUEST/REPLY for DHCPv6) against the plugin chains,
+// without sending anything on the network. Start runs it before serving when
+// the configuration asks for it. A nil chain is not tested.
+func selfTest(handlers4 []handler.Handler4, handlers6 []handler.Handler6) error {
+	var errs []error
+	if handlers4 != nil {
+		if err := selfTest4(handlers4); err != nil {
+			errs = append(errs, fmt.Errorf("DHCPv4 self-test failed: %w", err))
+		} else {
+			log.Infof("DHCPv4 self-test passed")
+		}
+	}
+	if handlers6 != nil {
+		if err := selfTest6(handlers6); err != nil {
+			errs = append(errs, fmt.Errorf("DHCPv6 self-test failed: %w", err))
+		} else {
+			log.Infof("DHCPv6 self-test passed")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SelfTest runs the self-test against the plugin chain of every listener of
+// a running server. Unlike the selftest configuration key, which runs it
+// before any client is served, it runs alongside the requests of real
+// clients. It returns an error describing every listener whose chain did not
+// produce the expected responses.
+func (s *Servers) SelfTest() error {
+	var errs []error
+	for _, l := range s.listeners {
+		var (
+			err  error
+			addr net.Addr
+		)
+		switch l := l.(type) {
+		case *listener4:
+			addr = l.LocalAddr()
+			err = selfTest4(l.handlers)
+		case *listener6:
+			addr = l.LocalAddr()
+			err = selfTest6(l.handlers)
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("self-test failed on %s: %w", addr, err))
+			continue
+		}
+		log.Infof("Self-test passed on %s", addr)
+	}
+	return errors.Join(errs...)
+}
+
+func selfTest4(handlers []handler.Handler4) error {
+	discover, err := dhcpv4.NewDiscovery(SelfTestHWAddr)
+	if err != nil {
+		return fmt.Errorf("cannot build DISCOVER: %w", err)
+	}
+	offer := process4(handlers, discover, selfTestMetadata())
+	if offer == nil {
+		return errors.New("no response to DISCOVER")
+	}
+	if mt := offer.MessageType(); mt != dhcpv4.MessageTypeOffer {
+		return fmt.Errorf("got %s in response to DISCOVER, want %s", mt, dhcpv4.MessageTypeOffer)
+	}
+	request, err := dhcpv4.NewRequestFromOffer(offer)
+	if err != nil {
+		return fmt.Errorf("cannot build REQUEST: %w", err)
+	}
+	ack := process4(handlers, request, selfTestMetadata())
+	if ack == nil {
+		return errors.New("no response to REQUEST")
+	}
+	if mt := ack.MessageType(); mt != dhcpv4.MessageTypeAck {
+		return fmt.Errorf("got %s in response to REQUEST, want %s", mt, dhcpv4.MessageTypeAck)
+	}
+	return nil
+}
+
+func selfTest6(handlers []handler.Handler6) error {
+	solicit, err := dhcpv6.NewSolicit(SelfTestHWAddr)
+	if err != nil {
+		return fmt.Errorf("cannot build SOLICIT: %w", err)
+	}
+	resp := process6(handlers, solicit, selfTestMetadata())
+	if resp == nil {
+		return errors.New("no response to SOLICIT")
+	}
+	advertise, ok := resp.(*dhcpv6.Message)
+	if !ok || advertise.Type() != dhcpv6.MessageTypeAdvertise {
+		return fmt.Errorf("got %s in response to SOLICIT, want %s", resp.Type(), dhcpv6.MessageTypeAdvertise)
+	}
+	// Not using dhcpv6.NewRequestFromAdvertise, which requires an IA_NA in
+	// the ADVERTISE: chains that only do prefix delegation or stateless
+	// configuration are still valid.
+	sid := advertise.GetOneOption(dhcpv6.OptionServerID)
+	if sid == nil {
+		return errors.New("no server ID in ADVERTISE")
+	}
+	request, err := dhcpv6.NewMessage()
+	if err != nil {
+		return fmt.Errorf("cannot build REQUEST: %w", err)
+	}
+	request.MessageType = dhcpv6.MessageTypeRequest
+	request.AddOption(solicit.GetOneOption(dhcpv6.OptionClientID))
+	request.AddOption(sid)
+	if iana := advertise.GetOneOption(dhcpv6.OptionIANA); iana != nil {
+		request.AddOption(iana)
+	} else {
+		request.AddOption(solicit.GetOneOption(dhcpv6.OptionIANA))
+	}
+	resp = process6(handlers, request, selfTestMetadata())
+	if resp == nil {
+		return errors.New("no response to REQUEST")
+	}
+	if resp.Type() != dhcpv6.MessageTypeReply {
+		return fmt.Errorf("got %s in response to REQUEST, want %s", resp.Type(), dhcpv6.MessageTypeReply)
+	}
+	return nil
+}
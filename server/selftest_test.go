@@ -0,0 +1,121 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/prefix"
+	rangepl "github.com/coredhcp/coredhcp/plugins/range"
+	"github.com/coredhcp/coredhcp/plugins/serverid"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestSelfTest4(t *testing.T) {
+	pass := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) { return resp, false }
+	drop := func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) { return nil, true }
+
+	if err := selfTest4([]handler.Handler4{pass}); err != nil {
+		t.Errorf("passthrough chain failed the self-test: %v", err)
+	}
+	if err := selfTest4([]handler.Handler4{pass, drop}); err == nil {
+		t.Error("dropping chain passed the self-test")
+	}
+}
+
+func TestSelfTest6(t *testing.T) {
+	sid := dhcpv6.WithServerID(&dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: SelfTestHWAddr})
+	withServerID := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		sid(resp)
+		return resp, false
+	}
+	drop := func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) { return nil, true }
+
+	if err := selfTest6([]handler.Handler6{withServerID}); err != nil {
+		t.Errorf("chain with a server ID failed the self-test: %v", err)
+	}
+	// A REQUEST cannot be built from an ADVERTISE without a server ID
+	if err := selfTest6(nil); err == nil {
+		t.Error("chain without a server ID passed the self-test")
+	}
+	if err := selfTest6([]handler.Handler6{drop}); err == nil {
+		t.Error("dropping chain passed the self-test")
+	}
+}
+
+func TestSelfTestKeepsState(t *testing.T) {
+	for _, p := range []*plugins.Plugin{&serverid.Plugin, &rangepl.Plugin, &prefix.Plugin} {
+		if err := plugins.RegisterPlugin(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leases := filepath.Join(t.TempDir(), "leases.sqlite3")
+	conf, err := config.FromMap(map[string]interface{}{
+		"server4": map[string]interface{}{
+			"plugins": []interface{}{
+				map[string]interface{}{"server_id": "192.0.2.1"},
+				map[string]interface{}{"range": leases + " 192.0.2.10 192.0.2.20 1h"},
+			},
+		},
+		"server6": map[string]interface{}{
+			"plugins": []interface{}{
+				map[string]interface{}{"server_id": "LL 02:00:00:00:00:01"},
+				map[string]interface{}{"prefix": "2001:db8::/48 64"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, client4, client6, err := StartInMemory(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	if err := srv.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first client gets the first address and prefix of the pools, which
+	// the self-tests left free
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.FromBytes(exchange(t, client4, discover.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := net.IPv4(192, 0, 2, 10); !offer.YourIPAddr.Equal(want) {
+		t.Errorf("offered %s after the self-tests, want %s", offer.YourIPAddr, want)
+	}
+
+	solicit, err := dhcpv6.NewSolicit(benchHWAddr, dhcpv6.WithIAPD([4]byte{0, 0, 0, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv6.FromBytes(exchange(t, client6, solicit.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	advertise, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		t.Fatalf("got %s in response to SOLICIT", resp.Type())
+	}
+	prefixes := advertise.Options.OneIAPD().Options.Prefixes()
+	if want := "2001:db8::/64"; len(prefixes) != 1 || prefixes[0].Prefix.String() != want {
+		t.Errorf("advertised %v after the self-tests, want %s", prefixes, want)
+	}
+}
@@ -20,7 +20,14 @@ import (
 //the layer3 destination address is still the broadcast address;
 //iface: the interface where the DHCP message should be sent;
 //resp: DHCPv4 struct, which should be sent;
-func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
+//payload: resp serialized, sent as is to keep its option order and padding;
+func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4, payload []byte, tos int) error {
+	// siaddr is the next server, which may be another host: prefer the
+	// server identifier as source address
+	srcIP := resp.ServerIdentifier()
+	if srcIP == nil {
+		srcIP = resp.ServerIPAddr
+	}
 
 	eth := layers.Ethernet{
 		EthernetType: layers.EthernetTypeIPv4,
@@ -29,8 +36,9 @@ func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
 	}
 	ip := layers.IPv4{
 		Version:  4,
+		TOS:      uint8(tos),
 		TTL:      64,
-		SrcIP:    resp.ServerIPAddr,
+		SrcIP:    srcIP,
 		DstIP:    resp.YourIPAddr,
 		Protocol: layers.IPProtocolUDP,
 		Flags:    layers.IPv4DontFragment,
@@ -51,14 +59,7 @@ func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
 		FixLengths:       true,
 	}
 
-	// Decode a packet
-	packet := gopacket.NewPacket(resp.ToBytes(), layers.LayerTypeDHCPv4, gopacket.NoCopy)
-	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
-	dhcp, ok := dhcpLayer.(gopacket.SerializableLayer)
-	if !ok {
-		return fmt.Errorf("Layer %s is not serializable", dhcpLayer.LayerType().String())
-	}
-	err = gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, dhcp)
+	err = gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, gopacket.Payload(payload))
 	if err != nil {
 		return fmt.Errorf("Cannot serialize layer: %v", err)
 	}
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"runtime"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -23,16 +25,55 @@ import (
 
 var log = logger.GetLogger("server")
 
+// conn6 is the subset of *ipv6.PacketConn used by listeners
+type conn6 interface {
+	ReadFrom(b []byte) (int, *ipv6.ControlMessage, net.Addr, error)
+	WriteTo(b []byte, cm *ipv6.ControlMessage, dst net.Addr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// conn4 is the subset of *ipv4.PacketConn used by listeners
+type conn4 interface {
+	ReadFrom(b []byte) (int, *ipv4.ControlMessage, net.Addr, error)
+	WriteTo(b []byte, cm *ipv4.ControlMessage, dst net.Addr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
 type listener6 struct {
-	*ipv6.PacketConn
+	conn6
 	net.Interface
 	handlers []handler.Handler6
+	workers  workers
+	deadline time.Duration
+	relays   *relayStats
+	// preferredRatio is the lifetime policy applied by finalize6
+	preferredRatio float64
+	// inMemory is set for listeners that are not UDP sockets, created by
+	// StartInMemory or listening on unix sockets, which send every response
+	// through conn6 without interface information
+	inMemory bool
 }
 
 type listener4 struct {
-	*ipv4.PacketConn
+	conn4
 	net.Interface
 	handlers []handler.Handler4
+	workers  workers
+	deadline time.Duration
+	naks     *nakLimiter
+	relays   *relayStats
+	// dscp is the DSCP the socket marks responses with
+	dscp int
+	// formats change the serialization of responses to some clients
+	formats []config.ResponseFormat
+	// offers defers OFFERs when set, see offerObserver
+	offers *offerObserver
+	// inMemory is set for listeners that are not UDP sockets, created by
+	// StartInMemory or listening on unix sockets, which send every response
+	// through conn4 without interface information
+	inMemory bool
 }
 
 type listener interface {
@@ -43,16 +84,20 @@ type listener interface {
 type Servers struct {
 	listeners []listener
 	errors    chan error
+	status    *statusFile
+	// config is the configuration the plugins were loaded from
+	config *config.Config
 }
 
-func listen4(a *net.UDPAddr) (*listener4, error) {
+func listen4(a *net.UDPAddr, dscp int) (*listener4, error) {
 	var err error
 	l4 := listener4{}
 	udpConn, err := server4.NewIPv4UDPConn(a.Zone, a)
 	if err != nil {
 		return nil, err
 	}
-	l4.PacketConn = ipv4.NewPacketConn(udpConn)
+	pc := ipv4.NewPacketConn(udpConn)
+	l4.conn4 = udpConn4{PacketConn: pc, udp: udpConn}
 	var ifi *net.Interface
 	if a.Zone != "" {
 		ifi, err = net.InterfaceByName(a.Zone)
@@ -64,14 +109,24 @@ func listen4(a *net.UDPAddr) (*listener4, error) {
 
 		// When not bound to an interface, we need the information in each
 		// packet to know which interface it came on
-		err = l4.SetControlMessage(ipv4.FlagInterface, true)
+		err = pc.SetControlMessage(ipv4.FlagInterface, true)
 		if err != nil {
 			return nil, err
 		}
 	}
+	// Details for the request metadata
+	if err = pc.SetControlMessage(ipv4.FlagTTL|ipv4.FlagDst, true); err != nil {
+		return nil, err
+	}
+	if dscp != 0 {
+		if err = pc.SetTOS(dscp << 2); err != nil {
+			return nil, fmt.Errorf("DHCPv4: cannot set DSCP: %w", err)
+		}
+		l4.dscp = dscp
+	}
 
 	if a.IP.IsMulticast() {
-		err = l4.JoinGroup(ifi, a)
+		err = pc.JoinGroup(ifi, a)
 		if err != nil {
 			return nil, err
 		}
@@ -79,13 +134,14 @@ func listen4(a *net.UDPAddr) (*listener4, error) {
 	return &l4, nil
 }
 
-func listen6(a *net.UDPAddr) (*listener6, error) {
+func listen6(a *net.UDPAddr, dscp int) (*listener6, error) {
 	l6 := listener6{}
 	udpconn, err := server6.NewIPv6UDPConn(a.Zone, a)
 	if err != nil {
 		return nil, err
 	}
-	l6.PacketConn = ipv6.NewPacketConn(udpconn)
+	pc := ipv6.NewPacketConn(udpconn)
+	l6.conn6 = pc
 	var ifi *net.Interface
 	if a.Zone != "" {
 		ifi, err = net.InterfaceByName(a.Zone)
@@ -96,14 +152,23 @@ func listen6(a *net.UDPAddr) (*listener6, error) {
 	} else {
 		// When not bound to an interface, we need the information in each
 		// packet to know which interface it came on
-		err = l6.SetControlMessage(ipv6.FlagInterface, true)
+		err = pc.SetControlMessage(ipv6.FlagInterface, true)
 		if err != nil {
 			return nil, err
 		}
 	}
+	// Details for the request metadata
+	if err = pc.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagDst|ipv6.FlagTrafficClass, true); err != nil {
+		return nil, err
+	}
+	if dscp != 0 {
+		if err = pc.SetTrafficClass(dscp << 2); err != nil {
+			return nil, fmt.Errorf("DHCPv6: cannot set DSCP: %w", err)
+		}
+	}
 
 	if a.IP.IsMulticast() {
-		err = l6.JoinGroup(ifi, a)
+		err = pc.JoinGroup(ifi, a)
 		if err != nil {
 			return nil, err
 		}
@@ -111,46 +176,160 @@ func listen6(a *net.UDPAddr) (*listener6, error) {
 	return &l6, nil
 }
 
+// Default request deadlines: the initial retransmission delay of DHCPv4
+// clients (RFC 2131, section 4.1), and SOL_TIMEOUT and REQ_TIMEOUT for DHCPv6
+// (RFC 8415, section 7.6)
+const (
+	defaultDeadline4 = 4 * time.Second
+	defaultDeadline6 = time.Second
+)
+
+// deadline returns the configured deadline, or def if it is not set
+func deadline(configured, def time.Duration) time.Duration {
+	if configured == 0 {
+		return def
+	}
+	return configured
+}
+
+// loadPlugins sets up the plugins of the configuration, and runs the self-test
+// on their chains when it is enabled, before any client is served
+func loadPlugins(config *config.Config) ([]handler.Handler4, []handler.Handler6, error) {
+	handlers4, handlers6, err := plugins.LoadPlugins(config)
+	if err != nil || !config.SelfTest {
+		return handlers4, handlers6, err
+	}
+	var test4 []handler.Handler4
+	var test6 []handler.Handler6
+	if config.Server4 != nil {
+		test4 = handlers4
+	}
+	if config.Server6 != nil {
+		test6 = handlers6
+	}
+	if err := selfTest(test4, test6); err != nil {
+		plugins.Unload(config)
+		return nil, nil, err
+	}
+	return handlers4, handlers6, nil
+}
+
 // Start will start the server asynchronously. See `Wait` to wait until
 // the execution ends.
 func Start(config *config.Config) (*Servers, error) {
-	handlers4, handlers6, err := plugins.LoadPlugins(config)
+	handlers4, handlers6, err := loadPlugins(config)
 	if err != nil {
 		return nil, err
 	}
 	srv := Servers{
 		errors: make(chan error),
+		status: newStatusFile(config.StatusFile, config.StatusInterval, config.RecentErrors),
+		config: config,
+	}
+	if srv.status != nil {
+		log.Printf("Writing status to %s every %s", config.StatusFile, config.StatusInterval)
+		go srv.status.run()
+	}
+	if config.GoMaxProcs != 0 {
+		log.Printf("Setting GOMAXPROCS to %d", config.GoMaxProcs)
+		runtime.GOMAXPROCS(config.GoMaxProcs)
 	}
 
 	// listen
 	if config.Server6 != nil {
 		log.Println("Starting DHCPv6 server")
+		relays := newRelayStats(config.Server6.RelayStatsInterval)
 		for _, addr := range config.Server6.Addresses {
 			var l6 *listener6
-			l6, err = listen6(&addr)
+			l6, err = listen6(&addr, config.Server6.DSCP)
 			if err != nil {
 				goto cleanup
 			}
 			l6.handlers = handlers6
+			l6.workers = newWorkers(config.Server6.Workers)
+			l6.deadline = deadline(config.Server6.Deadline, defaultDeadline6)
+			l6.relays = relays
+			l6.preferredRatio = config.Server6.PreferredRatio
 			srv.listeners = append(srv.listeners, l6)
 			go func() {
-				srv.errors <- l6.Serve()
+				srv.errors <- srv.status.serve("DHCPv6", l6.LocalAddr(), l6.Name, l6.Serve)
+			}()
+		}
+		for _, path := range config.Server6.UnixSockets {
+			var conn *unixConn
+			conn, err = listenUnix(path)
+			if err != nil {
+				goto cleanup
+			}
+			l6 := &listener6{
+				conn6:          unixConn6{conn},
+				handlers:       handlers6,
+				workers:        newWorkers(config.Server6.Workers),
+				deadline:       deadline(config.Server6.Deadline, defaultDeadline6),
+				relays:         relays,
+				inMemory:       true,
+				preferredRatio: config.Server6.PreferredRatio,
+			}
+			srv.listeners = append(srv.listeners, l6)
+			go func() {
+				srv.errors <- srv.status.serve("DHCPv6", l6.LocalAddr(), l6.Name, l6.Serve)
 			}()
 		}
 	}
 
 	if config.Server4 != nil {
 		log.Println("Starting DHCPv4 server")
+		relays := newRelayStats(config.Server4.RelayStatsInterval)
 		for _, addr := range config.Server4.Addresses {
 			var l4 *listener4
-			l4, err = listen4(&addr)
+			l4, err = listen4(&addr, config.Server4.DSCP)
 			if err != nil {
 				goto cleanup
 			}
 			l4.handlers = handlers4
+			l4.workers = newWorkers(config.Server4.Workers)
+			l4.naks = newNakLimiter(config.Server4.NakInterval)
+			l4.deadline = deadline(config.Server4.Deadline, defaultDeadline4)
+			l4.relays = relays
+			l4.formats = config.Server4.ResponseFormats
+			srv.listeners = append(srv.listeners, l4)
+			// The observer must be set before the listener serves, its
+			// workers read it for every request
+			if config.Server4.DeferOffers > 0 {
+				l4.offers, err = listenOffers(addr.Zone, config.Server4.DeferOffers)
+				if err != nil {
+					goto cleanup
+				}
+				srv.listeners = append(srv.listeners, l4.offers)
+				go func() {
+					srv.errors <- l4.offers.Serve()
+				}()
+			}
+			go func() {
+				srv.errors <- srv.status.serve("DHCPv4", l4.LocalAddr(), l4.Name, l4.Serve)
+			}()
+		}
+		// Unix socket listeners never defer OFFERs: other servers' OFFERs
+		// are not sent to their peers, so there is nothing to observe
+		for _, path := range config.Server4.UnixSockets {
+			var conn *unixConn
+			conn, err = listenUnix(path)
+			if err != nil {
+				goto cleanup
+			}
+			l4 := &listener4{
+				conn4:    unixConn4{conn},
+				handlers: handlers4,
+				workers:  newWorkers(config.Server4.Workers),
+				naks:     newNakLimiter(config.Server4.NakInterval),
+				deadline: deadline(config.Server4.Deadline, defaultDeadline4),
+				relays:   relays,
+				formats:  config.Server4.ResponseFormats,
+				inMemory: true,
+			}
 			srv.listeners = append(srv.listeners, l4)
 			go func() {
-				srv.errors <- l4.Serve()
+				srv.errors <- srv.status.serve("DHCPv4", l4.LocalAddr(), l4.Name, l4.Serve)
 			}()
 		}
 	}
@@ -175,11 +354,14 @@ func (s *Servers) Wait() error {
 	return errors.Join(errs...)
 }
 
-// Close closes all listening connections
+// Close closes all listening connections, and stops the background work of
+// the plugins
 func (s *Servers) Close() {
 	for _, srv := range s.listeners {
 		if srv != nil {
 			srv.Close()
 		}
 	}
+	s.status.close()
+	plugins.Unload(s.config)
 }
@@ -0,0 +1,184 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// StatusErrors is the number of recent errors in the status file
+const StatusErrors = 10
+
+// defaultRecentErrors returns the hook keeping the errors of the status file
+// for configurations without one. The hook is installed once, as every hook
+// stays on the shared logger for good.
+var defaultRecentErrors = sync.OnceValue(func() *logger.RecentErrors {
+	return logger.WithRecentErrors(log, StatusErrors)
+})
+
+// status is the document written to the status file
+type status struct {
+	Time      time.Time            `json:"time"`
+	Started   time.Time            `json:"started"`
+	Pools     []plugins.PoolStatus `json:"pools"`
+	Listeners []listenerStatus     `json:"listeners"`
+	Errors    []logger.RecentError `json:"errors"`
+}
+
+type listenerStatus struct {
+	Protocol  string `json:"protocol"`
+	Address   string `json:"address"`
+	Interface string `json:"interface,omitempty"`
+	// State is serving, stopped, or failed with Error set
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// statusFile periodically replaces a JSON document describing the state of
+// the server, for monitoring scripts. A nil statusFile writes nothing.
+type statusFile struct {
+	path     string
+	interval time.Duration
+	started  time.Time
+	errors   *logger.RecentErrors
+
+	mu        sync.Mutex
+	listeners []*listenerStatus
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newStatusFile(path string, interval time.Duration, errors *logger.RecentErrors) *statusFile {
+	if path == "" {
+		return nil
+	}
+	if errors == nil {
+		errors = defaultRecentErrors()
+	}
+	return &statusFile{
+		path:     path,
+		interval: interval,
+		started:  time.Now(),
+		errors:   errors,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// run writes the status every interval until close is called
+func (s *statusFile) run() {
+	defer close(s.done)
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		s.update()
+		select {
+		case <-t.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// update writes the current status, logging failures
+func (s *statusFile) update() {
+	if err := s.write(s.snapshot()); err != nil {
+		log.Warningf("Could not write status file: %v", err)
+	}
+}
+
+// serve runs serve, the Serve method of a listener, recording the state of
+// the listener
+func (s *statusFile) serve(protocol string, addr net.Addr, iface string, serve func() error) error {
+	if s == nil {
+		return serve()
+	}
+	l := &listenerStatus{Protocol: protocol, Address: addr.String(), Interface: iface, State: "serving"}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+	err := serve()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		l.State, l.Error = "failed", err.Error()
+	} else {
+		l.State = "stopped"
+	}
+	return err
+}
+
+func (s *statusFile) snapshot() *status {
+	st := &status{
+		Time:    time.Now(),
+		Started: s.started,
+		Pools:   plugins.Pools(),
+		Errors:  s.errors.List(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.listeners {
+		st.Listeners = append(st.Listeners, *l)
+	}
+	return st
+}
+
+// write replaces the status file with st. The new document is written to a
+// temporary file renamed over the previous one, so that readers never see a
+// partial document.
+func (s *statusFile) write(st *status) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	// CreateTemp makes files only readable by their owner
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// close stops the periodic writes, and writes the status a last time with
+// every listener stopped
+func (s *statusFile) close() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.mu.Lock()
+		for _, l := range s.listeners {
+			if l.State == "serving" {
+				l.State = "stopped"
+			}
+		}
+		s.mu.Unlock()
+		s.update()
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readStatus(t *testing.T, path string) status {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var st status
+	require.NoError(t, json.Unmarshal(b, &st))
+	return st
+}
+
+func TestStatusFile(t *testing.T) {
+	assert.Nil(t, newStatusFile("", time.Second, nil))
+	var none *statusFile
+	assert.NoError(t, none.serve("DHCPv4", nil, "", func() error { return nil }), "nil status file")
+	none.close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.json")
+	s := newStatusFile(path, time.Hour, nil)
+	go s.run()
+	assert.Same(t, s.errors, newStatusFile(path, time.Hour, nil).errors, "recent errors hook installed twice")
+
+	addr := &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+	stop := make(chan struct{})
+	serving := make(chan struct{})
+	go s.serve("DHCPv4", addr, "eth0", func() error {
+		close(serving)
+		<-stop
+		return nil
+	})
+	<-serving
+	assert.Error(t, s.serve("DHCPv6", &net.UDPAddr{IP: net.IPv6unspecified, Port: 547}, "", func() error {
+		return errors.New("read failed")
+	}))
+	log.Error("pool exhausted")
+
+	s.update()
+	st := readStatus(t, path)
+	if assert.Len(t, st.Listeners, 2) {
+		assert.Equal(t, listenerStatus{Protocol: "DHCPv4", Address: "0.0.0.0:67", Interface: "eth0", State: "serving"}, st.Listeners[0])
+		assert.Equal(t, listenerStatus{Protocol: "DHCPv6", Address: "[::]:547", State: "failed", Error: "read failed"}, st.Listeners[1])
+	}
+	if assert.NotEmpty(t, st.Errors) {
+		assert.Equal(t, "pool exhausted", st.Errors[len(st.Errors)-1].Message)
+	}
+	assert.False(t, st.Started.IsZero())
+
+	close(stop)
+	s.close()
+	st = readStatus(t, path)
+	assert.Equal(t, "stopped", st.Listeners[0].State)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files left behind")
+}
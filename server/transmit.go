@@ -0,0 +1,171 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"golang.org/x/net/ipv4"
+)
+
+// transmit4 is a way of sending a DHCPv4 response to a client
+type transmit4 interface {
+	fmt.Stringer
+	// send sends resp through l, serialized in format f, with the type of
+	// service byte tos. oob describes how the request was received, and may
+	// be nil.
+	send(l *listener4, resp *dhcpv4.DHCPv4, oob *ipv4.ControlMessage, tos int, f *config.ResponseFormat) error
+}
+
+// transmitRule4 picks the way of sending resp, a response to req received
+// from src, or returns nil to leave it to the next rule
+type transmitRule4 func(l *listener4, req, resp *dhcpv4.DHCPv4, src net.Addr) transmit4
+
+// transmitRules4 are tried in order for each response. A new way of reaching
+// clients is added as a transmit4 implementation and a rule selecting it.
+var transmitRules4 = []transmitRule4{
+	toUnixPeer4,
+	toRelay4,
+	nakBroadcast4,
+	toClientAddr4,
+	broadcast4,
+	toYourAddr4,
+}
+
+// selectTransmit4 returns the way of sending resp, a response to req
+// received from src
+func selectTransmit4(l *listener4, req, resp *dhcpv4.DHCPv4, src net.Addr) transmit4 {
+	for _, rule := range transmitRules4 {
+		if t := rule(l, req, resp, src); t != nil {
+			return t
+		}
+	}
+	// toYourAddr4 always matches
+	panic("BUG: no DHCPv4 transmission rule matched")
+}
+
+// unix listeners reply to the sending socket
+func toUnixPeer4(_ *listener4, _, _ *dhcpv4.DHCPv4, src net.Addr) transmit4 {
+	if up, ok := src.(*unixPeer); ok {
+		return udp4{dst: up}
+	}
+	return nil
+}
+
+func toRelay4(l *listener4, req, _ *dhcpv4.DHCPv4, _ net.Addr) transmit4 {
+	if req.GatewayIPAddr.IsUnspecified() {
+		return nil
+	}
+	// TODO: make RFC8357 compliant
+	return l.udp4(&net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort})
+}
+
+func nakBroadcast4(l *listener4, _, resp *dhcpv4.DHCPv4, _ net.Addr) transmit4 {
+	if resp.MessageType() != dhcpv4.MessageTypeNak {
+		return nil
+	}
+	return l.udp4(&net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort})
+}
+
+func toClientAddr4(l *listener4, req, _ *dhcpv4.DHCPv4, _ net.Addr) transmit4 {
+	if req.ClientIPAddr.IsUnspecified() {
+		return nil
+	}
+	return l.udp4(&net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort})
+}
+
+func broadcast4(l *listener4, req, _ *dhcpv4.DHCPv4, _ net.Addr) transmit4 {
+	if !req.IsBroadcast() {
+		return nil
+	}
+	return l.udp4(&net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort})
+}
+
+// toYourAddr4 unicasts to a client that has no address yet. This needs a
+// layer 2 frame to set the destination hardware address, except in memory.
+func toYourAddr4(l *listener4, _, resp *dhcpv4.DHCPv4, _ net.Addr) transmit4 {
+	if l.inMemory {
+		return udp4{dst: &net.UDPAddr{IP: resp.YourIPAddr, Port: dhcpv4.ClientPort}}
+	}
+	return ethernet4{}
+}
+
+// udp4 returns the transmission of a UDP datagram to dst. Broadcasts and
+// link-local unicasts are sent on the interface the request was received on.
+// Other packets use the normal routing table in case of asymetric routing.
+func (l *listener4) udp4(dst *net.UDPAddr) transmit4 {
+	return udp4{dst: dst, onLink: !l.inMemory && (dst.IP.Equal(net.IPv4bcast) || dst.IP.IsLinkLocalUnicast())}
+}
+
+// udp4 sends the response in a datagram to dst, on the receiving interface
+// when onLink is set
+type udp4 struct {
+	dst    net.Addr
+	onLink bool
+}
+
+func (t udp4) String() string {
+	return t.dst.String()
+}
+
+func (t udp4) send(l *listener4, resp *dhcpv4.DHCPv4, oob *ipv4.ControlMessage, tos int, f *config.ResponseFormat) error {
+	var woob *ipv4.ControlMessage
+	if t.onLink {
+		if ifIndex := l.replyIfIndex(oob); ifIndex != 0 {
+			woob = &ipv4.ControlMessage{IfIndex: ifIndex}
+		} else {
+			log.Errorf("HandleMsg4: Did not receive interface information")
+		}
+	}
+	out := bufpool.Get().(*[]byte)
+	defer bufpool.Put(out)
+	*out = marshal4(*out, resp, f)
+	// the socket marks datagrams with the DSCP of the listener
+	if w, ok := l.conn4.(tosWriter); ok && tos != l.dscp<<2 {
+		_, err := w.writeToTOS(*out, woob, t.dst, tos)
+		return err
+	}
+	_, err := l.WriteTo(*out, woob, t.dst)
+	return err
+}
+
+// ethernet4 sends the response in a layer 2 frame to the client hardware
+// address, on the interface the request was received on
+type ethernet4 struct{}
+
+func (ethernet4) String() string {
+	return "ethernet"
+}
+
+func (ethernet4) send(l *listener4, resp *dhcpv4.DHCPv4, oob *ipv4.ControlMessage, tos int, f *config.ResponseFormat) error {
+	ifIndex := l.replyIfIndex(oob)
+	if ifIndex == 0 {
+		return errors.New("did not receive interface information")
+	}
+	intf, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return fmt.Errorf("cannot get interface for index %d: %w", ifIndex, err)
+	}
+	out := bufpool.Get().(*[]byte)
+	defer bufpool.Put(out)
+	*out = marshal4(*out, resp, f)
+	return sendEthernet(*intf, resp, *out, tos)
+}
+
+// replyIfIndex returns the index of the interface to send link-scoped
+// responses on, or 0 if it is unknown
+func (l *listener4) replyIfIndex(oob *ipv4.ControlMessage) int {
+	switch {
+	case l.Interface.Index != 0:
+		return l.Interface.Index
+	case oob != nil:
+		return oob.IfIndex
+	}
+	return 0
+}
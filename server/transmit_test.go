@@ -0,0 +1,75 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"golang.org/x/net/ipv4"
+)
+
+func TestSelectTransmit4(t *testing.T) {
+	bcast := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	peer := &unixPeer{UnixAddr: &net.UnixAddr{Name: "/tmp/client.sock", Net: "unixgram"}}
+	testcases := []struct {
+		name     string
+		mods     []dhcpv4.Modifier
+		nak      bool
+		src      net.Addr
+		inMemory bool
+		want     transmit4
+	}{
+		{"relayed nak", []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(192, 0, 2, 1))}, true, nil, false,
+			udp4{dst: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: dhcpv4.ServerPort}}},
+		{"nak", []dhcpv4.Modifier{dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 10))}, true, nil, false,
+			udp4{dst: bcast, onLink: true}},
+		{"renewing", []dhcpv4.Modifier{dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 10))}, false, nil, false,
+			udp4{dst: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: dhcpv4.ClientPort}}},
+		{"broadcast flag", []dhcpv4.Modifier{dhcpv4.WithBroadcast(true)}, false, nil, false,
+			udp4{dst: bcast, onLink: true}},
+		{"broadcast in memory", []dhcpv4.Modifier{dhcpv4.WithBroadcast(true)}, false, nil, true,
+			udp4{dst: bcast}},
+		{"no address yet", nil, false, nil, false, ethernet4{}},
+		{"no address yet in memory", nil, false, nil, true,
+			udp4{dst: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 20), Port: dhcpv4.ClientPort}}},
+		{"unix socket", []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(192, 0, 2, 1))}, false, peer, true,
+			udp4{dst: peer}},
+	}
+	for _, tc := range testcases {
+		req, err := dhcpv4.NewDiscovery(benchHWAddr, tc.mods...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt := dhcpv4.MessageTypeOffer
+		if tc.nak {
+			mt = dhcpv4.MessageTypeNak
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(mt), dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 20)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &listener4{inMemory: tc.inMemory}
+		if got := selectTransmit4(l, req, resp, tc.src); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReplyIfIndex(t *testing.T) {
+	l := &listener4{}
+	if got := l.replyIfIndex(nil); got != 0 {
+		t.Errorf("got interface %d without information, want 0", got)
+	}
+	if got := l.replyIfIndex(&ipv4.ControlMessage{IfIndex: 4}); got != 4 {
+		t.Errorf("got interface %d, want the receiving interface 4", got)
+	}
+	l.Interface.Index = 2
+	if got := l.replyIfIndex(&ipv4.ControlMessage{IfIndex: 4}); got != 2 {
+		t.Errorf("got interface %d, want the bound interface 2", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Unix datagram listeners let local processes, such as sidecars, fuzzers or
+// tests, send DHCP messages through the whole server path without the
+// privileges needed to bind DHCP ports. Each datagram is a DHCP message
+// prefixed by a header:
+//
+//	version (1 byte, unixHeaderVersion)
+//	length of the interface name (1 byte), possibly 0
+//	interface name, presented to the plugins as the receiving interface
+//
+// Responses are sent to the address of the sending socket, which must be
+// bound, with the same header and the interface name of the request.
+const unixHeaderVersion = 1
+
+// unixPeer is the address of the sender of a datagram received on a unix
+// socket, along with the interface name from its header
+type unixPeer struct {
+	*net.UnixAddr
+	ifName string
+}
+
+// unixConn reads and writes DHCP messages framed with the unix listener header
+type unixConn struct {
+	*net.UnixConn
+	path string
+}
+
+func listenUnix(path string) (*unixConn, error) {
+	// remove a socket left by a previous run, but nothing else
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{UnixConn: conn, path: path}, nil
+}
+
+func (c *unixConn) readFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.ReadFromUnix(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 2 || b[0] != unixHeaderVersion || n < 2+int(b[1]) {
+			log.Printf("Invalid header in datagram from %v on %s, dropping it", addr, c.path)
+			continue
+		}
+		hdr := 2 + int(b[1])
+		peer := &unixPeer{UnixAddr: addr, ifName: string(b[2:hdr])}
+		return copy(b, b[hdr:n]), peer, nil
+	}
+}
+
+func (c *unixConn) writeTo(b []byte, dst net.Addr) (int, error) {
+	peer, ok := dst.(*unixPeer)
+	if !ok || peer.UnixAddr == nil {
+		return 0, fmt.Errorf("cannot reply to %v: the sending socket must be bound", dst)
+	}
+	if len(peer.ifName) > 255 {
+		return 0, errors.New("interface name too long")
+	}
+	msg := make([]byte, 0, 2+len(peer.ifName)+len(b))
+	msg = append(msg, unixHeaderVersion, byte(len(peer.ifName)))
+	msg = append(msg, peer.ifName...)
+	msg = append(msg, b...)
+	if _, err := c.WriteToUnix(msg, peer.UnixAddr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the socket and removes its file
+func (c *unixConn) Close() error {
+	err := c.UnixConn.Close()
+	if rmErr := os.Remove(c.path); err == nil && !errors.Is(rmErr, fs.ErrNotExist) {
+		err = rmErr
+	}
+	return err
+}
+
+// unixConn4 and unixConn6 adapt a unixConn to conn4 and conn6
+type (
+	unixConn4 struct{ *unixConn }
+	unixConn6 struct{ *unixConn }
+)
+
+func (c unixConn4) ReadFrom(b []byte) (int, *ipv4.ControlMessage, net.Addr, error) {
+	n, addr, err := c.readFrom(b)
+	return n, nil, addr, err
+}
+
+func (c unixConn4) WriteTo(b []byte, _ *ipv4.ControlMessage, dst net.Addr) (int, error) {
+	return c.writeTo(b, dst)
+}
+
+func (c unixConn6) ReadFrom(b []byte) (int, *ipv6.ControlMessage, net.Addr, error) {
+	n, addr, err := c.readFrom(b)
+	return n, nil, addr, err
+}
+
+func (c unixConn6) WriteTo(b []byte, _ *ipv6.ControlMessage, dst net.Addr) (int, error) {
+	return c.writeTo(b, dst)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// unixExchange sends msg with the unix listener header for ifName from a
+// socket bound in dir, and returns the payload of the reply
+func unixExchange(t *testing.T, dir, server, ifName string, msg []byte) []byte {
+	t.Helper()
+	client, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "client"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	hdr := append([]byte{unixHeaderVersion, byte(len(ifName))}, ifName...)
+	if _, err := client.WriteToUnix(append(hdr, msg...), &net.UnixAddr{Name: server, Net: "unixgram"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxDatagram)
+	n, _, err := client.ReadFromUnix(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf[:n], hdr) {
+		t.Fatalf("reply header %v, want %v", buf[:min(n, len(hdr))], hdr)
+	}
+	return buf[len(hdr):n]
+}
+
+func TestUnixListener4(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dhcp4.sock")
+	conn, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifNames := make(chan string, 1)
+	l := &listener4{
+		conn4: unixConn4{conn},
+		handlers: []handler.Handler4{func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			ifNames <- handler.Metadata4(req).IfName
+			return benchHandler4(req, resp)
+		}},
+		deadline: time.Minute,
+		inMemory: true,
+	}
+	done := make(chan error)
+	go func() { done <- l.Serve() }()
+
+	discover, err := dhcpv4.NewDiscovery(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := dhcpv4.FromBytes(unixExchange(t, dir, path, "vlan100", discover.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.MessageType() != dhcpv4.MessageTypeOffer || offer.TransactionID != discover.TransactionID {
+		t.Errorf("got %s, want an OFFER for transaction %s", offer.Summary(), discover.TransactionID)
+	}
+	if ifName := <-ifNames; ifName != "vlan100" {
+		t.Errorf("plugins saw interface %q, want vlan100", ifName)
+	}
+
+	l.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned %v after Close", err)
+	}
+}
+
+func TestStartUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dhcp6.sock")
+	srv, err := Start(&config.Config{
+		Server6: &config.ServerConfig{UnixSockets: []string{path}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	solicit, err := dhcpv6.NewSolicit(benchHWAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv6.FromBytes(unixExchange(t, dir, path, "", solicit.ToBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type() != dhcpv6.MessageTypeAdvertise {
+		t.Errorf("got %s, want an ADVERTISE", resp.Type())
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// The checks below run on responses built by the plugin chain, before they are
+// sent. They catch plugin bugs that would otherwise reach clients as malformed
+// or nonsensical packets.
+
+// ipUDPHeadersLen is the size of the IPv4 and UDP headers, which clients
+// include in their maximum message size
+const ipUDPHeadersLen = 28
+
+// bootpHeaderLen is the size of the fixed part of a DHCPv4 message, including
+// the magic cookie
+const bootpHeaderLen = 240
+
+// validate4 checks a DHCPv4 response to req
+func validate4(req, resp *dhcpv4.DHCPv4) error {
+	mt := resp.MessageType()
+	switch mt {
+	case dhcpv4.MessageTypeOffer, dhcpv4.MessageTypeAck:
+		if !resp.YourIPAddr.IsUnspecified() && !saneUnicast(resp.YourIPAddr) {
+			return fmt.Errorf("%s with invalid yiaddr %s", mt, resp.YourIPAddr)
+		}
+	case dhcpv4.MessageTypeNak:
+		// RFC 2131, table 3
+		if !resp.YourIPAddr.IsUnspecified() {
+			return fmt.Errorf("NAK with yiaddr %s", resp.YourIPAddr)
+		}
+		if resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+			return errors.New("NAK with a lease time")
+		}
+	case dhcpv4.MessageTypeNone:
+		return errors.New("no message type")
+	default:
+		return fmt.Errorf("message type %s is not a response", mt)
+	}
+
+	// Only checked when the client advertised a maximum size: large responses
+	// without it have always been sent, and many clients accept them.
+	// Long options are split when marshalling (RFC 3396), account for that.
+	size := bootpHeaderLen + 1 // end option
+	for _, data := range resp.Options {
+		size += len(data) + 2*((len(data)+math.MaxUint8-1)/math.MaxUint8)
+		if len(data) == 0 {
+			size += 2
+		}
+	}
+	if m, err := req.MaxMessageSize(); err == nil {
+		if maxSize := int(m) - ipUDPHeadersLen; size > maxSize {
+			return fmt.Errorf("%d bytes, over the client's maximum message size of %d", size, maxSize)
+		}
+	}
+	return nil
+}
+
+// validate6 checks a DHCPv6 response, before encapsulation for relays
+func validate6(resp dhcpv6.DHCPv6) error {
+	msg, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		return fmt.Errorf("%s is not a client/server message", resp.Type())
+	}
+	switch msg.Type() {
+	case dhcpv6.MessageTypeAdvertise, dhcpv6.MessageTypeReply:
+	case handler.MessageTypeAddrRegReply:
+		// RFC 9686, section 4.2: the reply echoes the registered address
+		if n := len(msg.Options.Get(dhcpv6.OptionIAAddr)); n != 1 {
+			return fmt.Errorf("ADDR-REG-REPLY with %d addresses, want 1", n)
+		}
+	default:
+		return fmt.Errorf("message type %s is not a response", msg.Type())
+	}
+	for _, opt := range msg.Options.Options {
+		if l := len(opt.ToBytes()); l > math.MaxUint16 {
+			return fmt.Errorf("option %s is %d bytes long, over the maximum of %d", opt.Code(), l, math.MaxUint16)
+		}
+	}
+	// RFC 8415, sections 21.6 and 21.22: lifetimes where preferred > valid
+	// must be discarded by clients
+	for _, iana := range msg.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			if !saneUnicast(addr.IPv6Addr) {
+				return fmt.Errorf("IA_NA with invalid address %s", addr.IPv6Addr)
+			}
+			if addr.PreferredLifetime > addr.ValidLifetime {
+				return fmt.Errorf("address %s has a preferred lifetime (%s) longer than its valid lifetime (%s)",
+					addr.IPv6Addr, addr.PreferredLifetime, addr.ValidLifetime)
+			}
+		}
+	}
+	for _, iapd := range msg.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			if prefix.Prefix == nil {
+				continue
+			}
+			if prefix.PreferredLifetime > prefix.ValidLifetime {
+				return fmt.Errorf("prefix %s has a preferred lifetime (%s) longer than its valid lifetime (%s)",
+					prefix.Prefix, prefix.PreferredLifetime, prefix.ValidLifetime)
+			}
+		}
+	}
+	return nil
+}
+
+// saneUnicast returns whether ip can be assigned to a client
+func saneUnicast(ip net.IP) bool {
+	return !ip.IsUnspecified() && !ip.IsLoopback() && !ip.IsMulticast() && !ip.Equal(net.IPv4bcast)
+}
@@ -0,0 +1,95 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestValidate4(t *testing.T) {
+	testcases := []struct {
+		name    string
+		reqOpts []dhcpv4.Modifier
+		modify  func(*dhcpv4.DHCPv4)
+		valid   bool
+	}{
+		{"offer", nil, func(r *dhcpv4.DHCPv4) { r.YourIPAddr = net.IPv4(192, 0, 2, 10) }, true},
+		{"offer without address", nil, func(*dhcpv4.DHCPv4) {}, true},
+		{"broadcast yiaddr", nil, func(r *dhcpv4.DHCPv4) { r.YourIPAddr = net.IPv4bcast }, false},
+		{"multicast yiaddr", nil, func(r *dhcpv4.DHCPv4) { r.YourIPAddr = net.IPv4(224, 0, 0, 1) }, false},
+		{"no message type", nil, func(r *dhcpv4.DHCPv4) { r.Options.Del(dhcpv4.OptionDHCPMessageType) }, false},
+		{"not a response", nil, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+		}, false},
+		{"nak", nil, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+		}, true},
+		{"nak with lease", nil, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+			r.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Hour))
+		}, false},
+		{"large without max size", nil, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 600)))
+		}, true},
+		{"over max size", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(576))}, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 400)))
+		}, false},
+		{"within max size", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(1500))}, func(r *dhcpv4.DHCPv4) {
+			r.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 300)))
+		}, true},
+	}
+
+	for _, tc := range testcases {
+		req, err := dhcpv4.NewDiscovery(benchHWAddr, tc.reqOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.modify(resp)
+		if err := validate4(req, resp); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid=%v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestValidate6(t *testing.T) {
+	addr := func(ip string, preferred, valid time.Duration) dhcpv6.Modifier {
+		return dhcpv6.WithIANA(dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP(ip), PreferredLifetime: preferred, ValidLifetime: valid})
+	}
+	registered := dhcpv6.WithOption(&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::1"), ValidLifetime: time.Hour})
+	testcases := []struct {
+		name  string
+		mt    dhcpv6.MessageType
+		mods  []dhcpv6.Modifier
+		valid bool
+	}{
+		{"reply", dhcpv6.MessageTypeReply, []dhcpv6.Modifier{addr("2001:db8::1", time.Hour, 2*time.Hour)}, true},
+		{"not a response", dhcpv6.MessageTypeRequest, nil, false},
+		{"multicast address", dhcpv6.MessageTypeReply, []dhcpv6.Modifier{addr("ff02::1", time.Hour, 2*time.Hour)}, false},
+		{"preferred over valid", dhcpv6.MessageTypeAdvertise, []dhcpv6.Modifier{addr("2001:db8::1", 2*time.Hour, time.Hour)}, false},
+		{"address registration", handler.MessageTypeAddrRegReply, []dhcpv6.Modifier{registered}, true},
+		{"address registration without address", handler.MessageTypeAddrRegReply, nil, false},
+	}
+
+	for _, tc := range testcases {
+		msg, err := dhcpv6.NewMessage(tc.mods...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.MessageType = tc.mt
+		if err := validate6(msg); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, want valid=%v", tc.name, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// workers runs the handling of received packets. A nil workers starts a new
+// goroutine for every packet, otherwise packets are handed over to a fixed
+// pool of goroutines, and reading from the socket blocks while they are all
+// busy.
+type workers chan func()
+
+func newWorkers(n int) workers {
+	if n <= 0 {
+		return nil
+	}
+	w := make(workers, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for f := range w {
+				f()
+			}
+		}()
+	}
+	return w
+}
+
+func (w workers) run(f func()) {
+	if w == nil {
+		go f()
+		return
+	}
+	w <- f
+}
+
+func (w workers) stop() {
+	if w != nil {
+		close(w)
+	}
+}